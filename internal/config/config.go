@@ -1,6 +1,10 @@
 package config
 
 import (
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"os"
 	"strconv"
 
@@ -20,6 +24,48 @@ type Config struct {
 	LogLevel   string
 	LogType    string
 	WADebug    string
+
+	MediaMaxDownloadMB        int
+	MediaDownloadTimeout      int
+	MediaURLAllowlist         string
+	MediaURLDenylist          string
+	MediaAllowPrivateNetworks bool
+	MediaMaxUploadMB          int
+	LinkPreviewCacheTTL       int
+
+	MediaStorage     string
+	MediaStoragePath string
+	MediaPublicURL   string
+	MediaStoreMaxMB  int
+	S3Endpoint       string
+	S3Region         string
+	S3Bucket         string
+	S3AccessKey      string
+	S3SecretKey      string
+	S3UseSSL         bool
+
+	PublicURL       string
+	MediaSigningKey string
+	MediaURLTTL     int
+	// MediaSigningKeyEphemeral is set when the signing key is derived from a
+	// generated admin token, so signed links stop working on restart
+	MediaSigningKeyEphemeral bool
+
+	WebhookWorkers      int
+	WebhookMaxInFlight  int
+	WebhookPollInterval int
+
+	NATSURL             string
+	NATSSubjectPrefix   string
+	NATSStream          string
+	NATSCreateStream    bool
+	NATSStreamMaxAge    int
+	NATSDuplicateWindow int
+
+	RedisURL           string
+	RedisStreamKey     string
+	RedisStreamMaxLen  int
+	RedisConsumerGroup string
 }
 
 func Load() (*Config, error) {
@@ -38,7 +84,54 @@ func Load() (*Config, error) {
 		LogLevel:   getEnv("LOG_LEVEL", "info"),
 		LogType:    getEnv("LOG_TYPE", "console"),
 		WADebug:    getEnv("WA_DEBUG", ""),
+
+		MediaMaxDownloadMB:        getEnvInt("MEDIA_MAX_DOWNLOAD_MB", 100),
+		MediaDownloadTimeout:      getEnvInt("MEDIA_DOWNLOAD_TIMEOUT", 120),
+		MediaURLAllowlist:         getEnv("MEDIA_URL_ALLOWLIST", ""),
+		MediaURLDenylist:          getEnv("MEDIA_URL_DENYLIST", ""),
+		MediaAllowPrivateNetworks: getEnvBool("MEDIA_ALLOW_PRIVATE_NETWORKS", false),
+		MediaMaxUploadMB:          getEnvInt("MEDIA_MAX_UPLOAD_MB", 64),
+		LinkPreviewCacheTTL:       getEnvInt("LINK_PREVIEW_CACHE_TTL", 3600),
+
+		MediaStorage:     getEnv("MEDIA_STORAGE", "local"),
+		MediaStoragePath: getEnv("MEDIA_STORAGE_PATH", "data/media"),
+		MediaPublicURL:   getEnv("MEDIA_PUBLIC_URL", ""),
+		MediaStoreMaxMB:  getEnvInt("MEDIA_STORE_MAX_MB", 100),
+		S3Endpoint:       getEnv("S3_ENDPOINT", ""),
+		S3Region:         getEnv("S3_REGION", ""),
+		S3Bucket:         getEnv("S3_BUCKET", ""),
+		S3AccessKey:      getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:      getEnv("S3_SECRET_KEY", ""),
+		S3UseSSL:         getEnvBool("S3_USE_SSL", true),
+
+		WebhookWorkers:      getEnvInt("WEBHOOK_WORKERS", 8),
+		WebhookMaxInFlight:  getEnvInt("WEBHOOK_MAX_IN_FLIGHT", 4),
+		WebhookPollInterval: getEnvInt("WEBHOOK_POLL_INTERVAL", 30),
+
+		NATSURL:             getEnv("NATS_URL", ""),
+		NATSSubjectPrefix:   getEnv("NATS_SUBJECT_PREFIX", "fiozap"),
+		NATSStream:          getEnv("NATS_STREAM", "FIOZAP"),
+		NATSCreateStream:    getEnvBool("NATS_CREATE_STREAM", true),
+		NATSStreamMaxAge:    getEnvInt("NATS_STREAM_MAX_AGE", 604800),
+		NATSDuplicateWindow: getEnvInt("NATS_DUPLICATE_WINDOW", 120),
+
+		RedisURL:           getEnv("REDIS_URL", ""),
+		RedisStreamKey:     getEnv("REDIS_STREAM_KEY", "fiozap:{userId}:{sessionId}"),
+		RedisStreamMaxLen:  getEnvInt("REDIS_STREAM_MAXLEN", 10000),
+		RedisConsumerGroup: getEnv("REDIS_CONSUMER_GROUP", ""),
+	}
+
+	cfg.PublicURL = getEnv("PUBLIC_URL", "http://localhost:"+cfg.Port)
+	cfg.MediaSigningKey = os.Getenv("MEDIA_SIGNING_KEY")
+	if cfg.MediaSigningKey == "" {
+		key, err := deriveKey(cfg.AdminToken, mediaSigningKeyLabel)
+		if err != nil {
+			return nil, err
+		}
+		cfg.MediaSigningKey = key
+		cfg.MediaSigningKeyEphemeral = os.Getenv("ADMIN_TOKEN") == ""
 	}
+	cfg.MediaURLTTL = getEnvInt("MEDIA_URL_TTL", 86400)
 
 	return cfg, nil
 }
@@ -69,10 +162,19 @@ func getEnvBool(key string, defaultValue bool) bool {
 }
 
 func generateRandomToken() string {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, 32)
-	for i := range b {
-		b[i] = charset[i%len(charset)]
+	return rand.Text()
+}
+
+// mediaSigningKeyLabel separates the media signing key from other keys that
+// may be derived from the admin token.
+const mediaSigningKeyLabel = "fiozap media url signing"
+
+// deriveKey derives a hex encoded key from secret, so a leaked signed link
+// reveals nothing about the secret itself.
+func deriveKey(secret, label string) (string, error) {
+	key, err := hkdf.Key(sha256.New, []byte(secret), nil, label, sha256.Size)
+	if err != nil {
+		return "", err
 	}
-	return string(b)
+	return hex.EncodeToString(key), nil
 }
@@ -2,9 +2,10 @@ package database
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"go.mau.fi/util/dbutil"
 
 	"fiozap/internal/config"
@@ -12,13 +13,15 @@ import (
 	"fiozap/internal/logger"
 )
 
-func Connect(cfg *config.Config) (*sqlx.DB, error) {
-	dsn := fmt.Sprintf(
+func dsn(cfg *config.Config) string {
+	return fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
 		cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.DBName, cfg.DBSSLMode,
 	)
+}
 
-	db, err := sqlx.Open("postgres", dsn)
+func Connect(cfg *config.Config) (*sqlx.DB, error) {
+	db, err := sqlx.Open("postgres", dsn(cfg))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open postgres: %w", err)
 	}
@@ -31,6 +34,25 @@ func Connect(cfg *config.Config) (*sqlx.DB, error) {
 	return db, nil
 }
 
+// Listen opens a dedicated connection that receives notifications on the
+// given channels. It reconnects on its own after connection failures.
+func Listen(cfg *config.Config, channels ...string) (*pq.Listener, error) {
+	listener := pq.NewListener(dsn(cfg), time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.Warnf("Postgres listener: %v", err)
+		}
+	})
+
+	for _, channel := range channels {
+		if err := listener.Listen(channel); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to listen on %s: %w", channel, err)
+		}
+	}
+
+	return listener, nil
+}
+
 func ConnectDBUtil(cfg *config.Config) (*dbutil.Database, error) {
 	dsn := fmt.Sprintf(
 		"postgres://%s:%s@%s:%s/%s?sslmode=%s",
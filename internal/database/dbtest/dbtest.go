@@ -0,0 +1,87 @@
+// Package dbtest provides migrated Postgres databases to tests of queries
+// that only a real server can check. Those tests need one: set
+// FIOZAP_TEST_DATABASE_URL to a postgres:// URL whose user may create
+// databases, e.g. the one of docker-compose. They are skipped otherwise.
+package dbtest
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+	"go.mau.fi/util/dbutil"
+
+	"fiozap/internal/database/migration"
+	"fiozap/internal/database/migration/upgrades"
+)
+
+// EnvDatabaseURL names the variable with the URL of the test server.
+const EnvDatabaseURL = "FIOZAP_TEST_DATABASE_URL"
+
+var counter atomic.Int64
+
+// Open creates a fresh database with all migrations applied, dropped again
+// when the test ends.
+func Open(t testing.TB) *sqlx.DB {
+	t.Helper()
+
+	rawURL := os.Getenv(EnvDatabaseURL)
+	if rawURL == "" {
+		t.Skipf("%s is not set", EnvDatabaseURL)
+	}
+
+	admin, err := sqlx.Connect("postgres", rawURL)
+	if err != nil {
+		t.Fatalf("failed to connect to the test server: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	name := fmt.Sprintf("fztest_%d_%d_%d", os.Getpid(), time.Now().UnixNano(), counter.Add(1))
+	if _, err := admin.Exec(`CREATE DATABASE "` + name + `"`); err != nil {
+		t.Fatalf("failed to create test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec(`DROP DATABASE IF EXISTS "` + name + `"`); err != nil {
+			t.Logf("failed to drop test database %s: %v", name, err)
+		}
+	})
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("invalid %s: %v", EnvDatabaseURL, err)
+	}
+	u.Path = "/" + name
+	dsn := u.String()
+
+	db, err := sqlx.Connect("postgres", dsn)
+	if err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	// registered after the drop, so it runs first
+	t.Cleanup(func() { db.Close() })
+
+	migrate(t, db)
+	return db
+}
+
+func migrate(t testing.TB, db *sqlx.DB) {
+	t.Helper()
+
+	wrapped, err := dbutil.NewWithDB(db.DB, "postgres")
+	if err != nil {
+		t.Fatalf("failed to wrap test database: %v", err)
+	}
+	wrapped.Owner = "fiozap"
+	wrapped.VersionTable = migration.VersionTable
+	wrapped.UpgradeTable = upgrades.Table
+
+	if err := wrapped.Upgrade(context.Background()); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+}
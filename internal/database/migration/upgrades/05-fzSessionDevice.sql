@@ -0,0 +1,17 @@
+-- v4 -> v5: Map each fzSession to its own whatsmeow device
+
+ALTER TABLE "fzSession" ADD COLUMN IF NOT EXISTS "deviceJid" VARCHAR(255) DEFAULT '';
+
+-- Sessions that were already paired were using their own JID as the device.
+-- When several sessions share a JID, only the oldest keeps the device; the
+-- others are unpaired and must scan a new QR code.
+UPDATE "fzSession" AS "s"
+SET "jid" = '', "connected" = 0
+WHERE "s"."jid" != ''
+  AND EXISTS (
+    SELECT 1 FROM "fzSession" AS "other"
+    WHERE "other"."jid" = "s"."jid"
+      AND (COALESCE("other"."createdAt", '-infinity'), "other"."id") < (COALESCE("s"."createdAt", '-infinity'), "s"."id")
+  );
+
+UPDATE "fzSession" SET "deviceJid" = "jid" WHERE "jid" != '' AND ("deviceJid" IS NULL OR "deviceJid" = '');
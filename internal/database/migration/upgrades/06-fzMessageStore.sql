@@ -0,0 +1,5 @@
+-- v5 -> v6: Store inbound and outbound messages per session
+
+ALTER TABLE "fzMessage" ADD COLUMN IF NOT EXISTS "fromMe" BOOLEAN NOT NULL DEFAULT false;
+
+ALTER TABLE "fzSession" ADD COLUMN IF NOT EXISTS "storeMessages" BOOLEAN NOT NULL DEFAULT true;
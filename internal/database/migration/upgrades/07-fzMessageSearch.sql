@@ -0,0 +1,13 @@
+-- v6 -> v7: Full-text search over stored messages
+
+ALTER TABLE "fzSession" ADD COLUMN IF NOT EXISTS "searchLanguage" VARCHAR(64) NOT NULL DEFAULT 'portuguese';
+
+-- Each message keeps the text search configuration it was indexed with, so
+-- changing a session's language does not invalidate existing rows
+ALTER TABLE "fzMessage" ADD COLUMN IF NOT EXISTS "searchLanguage" REGCONFIG NOT NULL DEFAULT 'portuguese';
+
+ALTER TABLE "fzMessage" ADD COLUMN IF NOT EXISTS "textSearch" TSVECTOR
+    GENERATED ALWAYS AS (to_tsvector("searchLanguage", COALESCE("textContent", ''))) STORED;
+
+CREATE INDEX IF NOT EXISTS "idxFzMessageTextSearch"
+ON "fzMessage" USING GIN ("textSearch");
@@ -0,0 +1,27 @@
+-- v7 -> v8: Create fzChat table and history sync retention settings
+
+CREATE TABLE IF NOT EXISTS "fzChat" (
+    "id" SERIAL PRIMARY KEY,
+    "userId" VARCHAR(64) NOT NULL,
+    "sessionId" VARCHAR(64) NOT NULL REFERENCES "fzSession"("id") ON DELETE CASCADE,
+    "chatJid" VARCHAR(255) NOT NULL,
+    "name" TEXT DEFAULT '',
+    "unreadCount" INTEGER DEFAULT 0,
+    "archived" BOOLEAN DEFAULT false,
+    "pinned" BOOLEAN DEFAULT false,
+    "lastMessageAt" TIMESTAMP,
+    "updatedAt" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE("sessionId", "chatJid")
+);
+
+CREATE INDEX IF NOT EXISTS "idxFzChatSessionLastMessage"
+ON "fzChat" ("sessionId", "lastMessageAt" DESC);
+
+-- 0 means no limit
+ALTER TABLE "fzSession" ADD COLUMN IF NOT EXISTS "historyDays" INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE "fzSession" ADD COLUMN IF NOT EXISTS "historyMessages" INTEGER NOT NULL DEFAULT 0;
+
+-- the HistorySync event is now HistorySyncProgress
+UPDATE "fzSession"
+SET "events" = array_to_string(array_replace(string_to_array("events", ','), 'HistorySync', 'HistorySyncProgress'), ',')
+WHERE 'HistorySync' = ANY(string_to_array("events", ','));
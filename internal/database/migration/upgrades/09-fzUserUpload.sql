@@ -0,0 +1,4 @@
+-- v8 -> v9: Add per-user media upload limit
+
+-- 0 means the server default (MEDIA_MAX_UPLOAD_MB)
+ALTER TABLE "fzUser" ADD COLUMN IF NOT EXISTS "maxUploadMB" INTEGER NOT NULL DEFAULT 0;
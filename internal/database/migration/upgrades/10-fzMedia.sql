@@ -0,0 +1,15 @@
+-- v9 -> v10: Create fzMedia table for downloaded message media
+
+CREATE TABLE IF NOT EXISTS "fzMedia" (
+    "id" VARCHAR(64) PRIMARY KEY,
+    "userId" VARCHAR(64) NOT NULL,
+    "sessionId" VARCHAR(64) NOT NULL REFERENCES "fzSession"("id") ON DELETE CASCADE,
+    "messageId" VARCHAR(255) NOT NULL,
+    "storageKey" TEXT NOT NULL,
+    "mimeType" VARCHAR(255) NOT NULL DEFAULT '',
+    "fileName" TEXT DEFAULT '',
+    "size" BIGINT NOT NULL DEFAULT 0,
+    "sha256" VARCHAR(64) NOT NULL DEFAULT '',
+    "createdAt" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE("sessionId", "messageId")
+);
@@ -0,0 +1,8 @@
+-- v10 -> v11: Add per-session webhook signing secrets
+
+ALTER TABLE "fzSession" ADD COLUMN IF NOT EXISTS "webhookSecret" VARCHAR(128) NOT NULL DEFAULT '';
+-- Kept valid after a rotation until webhookSecretPreviousExpiresAt
+ALTER TABLE "fzSession" ADD COLUMN IF NOT EXISTS "webhookSecretPrevious" VARCHAR(128) NOT NULL DEFAULT '';
+ALTER TABLE "fzSession" ADD COLUMN IF NOT EXISTS "webhookSecretPreviousExpiresAt" TIMESTAMP;
+
+UPDATE "fzSession" SET "webhookSecret" = encode(gen_random_bytes(32), 'hex') WHERE "webhookSecret" = '';
@@ -0,0 +1,11 @@
+-- v11 -> v12: Schedule webhook retries with backoff and per-session retry policy
+
+ALTER TABLE "fzWebhook" ADD COLUMN IF NOT EXISTS "nextAttemptAt" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+
+DROP INDEX IF EXISTS "idxFzWebhookPending";
+CREATE INDEX IF NOT EXISTS "idxFzWebhookPending"
+ON "fzWebhook" ("nextAttemptAt") WHERE "status" = 'pending';
+
+ALTER TABLE "fzSession" ADD COLUMN IF NOT EXISTS "webhookMaxAttempts" INTEGER NOT NULL DEFAULT 8;
+-- Seconds since the event was created, 0 means no limit
+ALTER TABLE "fzSession" ADD COLUMN IF NOT EXISTS "webhookMaxAge" INTEGER NOT NULL DEFAULT 86400;
@@ -0,0 +1,7 @@
+-- v12 -> v13: Keep the outcome of the last webhook attempt for inspection and replay
+
+ALTER TABLE "fzWebhook" ADD COLUMN IF NOT EXISTS "lastStatusCode" INTEGER;
+ALTER TABLE "fzWebhook" ADD COLUMN IF NOT EXISTS "lastResponse" TEXT NOT NULL DEFAULT '';
+ALTER TABLE "fzWebhook" ADD COLUMN IF NOT EXISTS "lastError" TEXT NOT NULL DEFAULT '';
+-- Replayed events get a fresh max age window
+ALTER TABLE "fzWebhook" ADD COLUMN IF NOT EXISTS "replayedAt" TIMESTAMP;
@@ -0,0 +1,23 @@
+-- v13 -> v14: Create fzWebhookEndpoint table for additional webhook receivers per session
+
+CREATE TABLE IF NOT EXISTS "fzWebhookEndpoint" (
+    "id" VARCHAR(64) PRIMARY KEY,
+    "userId" VARCHAR(64) NOT NULL,
+    "sessionId" VARCHAR(64) NOT NULL REFERENCES "fzSession"("id") ON DELETE CASCADE,
+    "url" TEXT NOT NULL,
+    "events" TEXT NOT NULL DEFAULT '',
+    "headers" JSONB NOT NULL DEFAULT '{}',
+    "enabled" BOOLEAN NOT NULL DEFAULT TRUE,
+    "secret" VARCHAR(128) NOT NULL,
+    "secretPrevious" VARCHAR(128) NOT NULL DEFAULT '',
+    "secretPreviousExpiresAt" TIMESTAMP,
+    "maxAttempts" INTEGER NOT NULL DEFAULT 8,
+    "maxAge" INTEGER NOT NULL DEFAULT 86400,
+    "createdAt" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    "updatedAt" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS "idxFzWebhookEndpointSession" ON "fzWebhookEndpoint" ("sessionId");
+
+-- Deliveries without an endpoint go to the session's own webhook
+ALTER TABLE "fzWebhook" ADD COLUMN IF NOT EXISTS "endpointId" VARCHAR(64) REFERENCES "fzWebhookEndpoint"("id") ON DELETE CASCADE;
@@ -0,0 +1,6 @@
+-- v14 -> v15: Lease claimed webhook events so several instances can dispatch safely
+
+ALTER TABLE "fzWebhook" ADD COLUMN IF NOT EXISTS "lockedUntil" TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS "idxFzWebhookLocked"
+ON "fzWebhook" ("sessionId") WHERE "status" = 'pending' AND "lockedUntil" IS NOT NULL;
@@ -0,0 +1,27 @@
+-- v15 -> v16: Number session events in commit order for streaming and resume
+
+-- Event IDs are taken before commit, so events can become visible out of ID
+-- order. Committed session events are numbered afterwards, in short
+-- transactions of their own, and streams follow the log by "seq"
+CREATE SEQUENCE IF NOT EXISTS "fzEventSeq";
+
+ALTER TABLE "fzWebhook" ADD COLUMN IF NOT EXISTS "seq" BIGINT;
+
+UPDATE "fzWebhook" SET "seq" = "numbered"."seq"
+FROM (
+    SELECT "id", nextval('"fzEventSeq"') AS "seq"
+    FROM (SELECT "id" FROM "fzWebhook" WHERE "endpointId" IS NULL ORDER BY "id") AS "events"
+) AS "numbered"
+WHERE "fzWebhook"."id" = "numbered"."id";
+
+CREATE UNIQUE INDEX IF NOT EXISTS "idxFzWebhookSeq"
+ON "fzWebhook" ("seq") WHERE "endpointId" IS NULL;
+
+CREATE INDEX IF NOT EXISTS "idxFzWebhookUnsequenced"
+ON "fzWebhook" ("id") WHERE "endpointId" IS NULL AND "seq" IS NULL;
+
+CREATE INDEX IF NOT EXISTS "idxFzWebhookStreamSession"
+ON "fzWebhook" ("sessionId", "seq") WHERE "endpointId" IS NULL;
+
+CREATE INDEX IF NOT EXISTS "idxFzWebhookStreamUser"
+ON "fzWebhook" ("userId", "seq") WHERE "endpointId" IS NULL;
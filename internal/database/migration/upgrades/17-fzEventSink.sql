@@ -0,0 +1,10 @@
+-- v16 -> v17: Track how far each event sink has published the event log
+
+CREATE TABLE IF NOT EXISTS "fzEventSink" (
+    "name" VARCHAR(64) PRIMARY KEY,
+    "lastSeq" BIGINT NOT NULL DEFAULT 0,
+    -- the instance publishing the sink holds it until "lockedUntil"
+    "leaseToken" VARCHAR(64),
+    "lockedUntil" TIMESTAMP,
+    "updatedAt" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
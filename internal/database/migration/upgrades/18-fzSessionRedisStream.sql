@@ -0,0 +1,3 @@
+-- v17 -> v18: Let sessions opt in to the Redis Streams event sink
+
+ALTER TABLE "fzSession" ADD COLUMN IF NOT EXISTS "redisStream" BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,14 @@
+-- v18 -> v19: Keep the revisions of edited messages
+
+ALTER TABLE "fzMessage" ADD COLUMN IF NOT EXISTS "revision" INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE "fzMessage" ADD COLUMN IF NOT EXISTS "editedAt" TIMESTAMP;
+
+-- Revision 0 is the original text, saved when the message is first edited
+CREATE TABLE IF NOT EXISTS "fzMessageRevision" (
+    "id" SERIAL PRIMARY KEY,
+    "messageRowId" INTEGER NOT NULL REFERENCES "fzMessage"("id") ON DELETE CASCADE,
+    "revision" INTEGER NOT NULL,
+    "textContent" TEXT,
+    "createdAt" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE("messageRowId", "revision")
+);
@@ -0,0 +1,29 @@
+-- v19 -> v20: Store polls and their votes to tally results
+
+-- Polls are stored with the messages of sessions that store them, votes only
+-- carry hashes of the option names
+CREATE TABLE IF NOT EXISTS "fzPoll" (
+    "id" SERIAL PRIMARY KEY,
+    "userId" VARCHAR(64) NOT NULL,
+    "sessionId" VARCHAR(64) NOT NULL REFERENCES "fzSession"("id") ON DELETE CASCADE,
+    "chatJid" VARCHAR(255) NOT NULL,
+    "senderJid" VARCHAR(255) NOT NULL,
+    "messageId" VARCHAR(255) NOT NULL,
+    "question" TEXT NOT NULL,
+    "options" TEXT[] NOT NULL,
+    "selectableCount" INTEGER NOT NULL DEFAULT 0,
+    "createdAt" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE("sessionId", "messageId")
+);
+
+-- One row per voter with their latest selection. Votes may arrive for polls
+-- that are not stored, so they reference the poll by message ID
+CREATE TABLE IF NOT EXISTS "fzPollVote" (
+    "id" SERIAL PRIMARY KEY,
+    "sessionId" VARCHAR(64) NOT NULL REFERENCES "fzSession"("id") ON DELETE CASCADE,
+    "pollMessageId" VARCHAR(255) NOT NULL,
+    "voterJid" VARCHAR(255) NOT NULL,
+    "selectedHashes" TEXT[] NOT NULL,
+    "votedAt" TIMESTAMP NOT NULL,
+    UNIQUE("sessionId", "pollMessageId", "voterJid")
+);
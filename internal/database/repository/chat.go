@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type Chat struct {
+	ID            int64      `json:"-" db:"id"`
+	UserID        string     `json:"-" db:"userId"`
+	SessionID     string     `json:"sessionId" db:"sessionId"`
+	ChatJID       string     `json:"chatJid" db:"chatJid"`
+	Name          string     `json:"name" db:"name"`
+	UnreadCount   int        `json:"unreadCount" db:"unreadCount"`
+	Archived      bool       `json:"archived" db:"archived"`
+	Pinned        bool       `json:"pinned" db:"pinned"`
+	LastMessageAt *time.Time `json:"lastMessageAt,omitempty" db:"lastMessageAt"`
+	UpdatedAt     time.Time  `json:"updatedAt" db:"updatedAt"`
+}
+
+type ChatRepository struct {
+	db *sqlx.DB
+}
+
+func NewChatRepository(db *sqlx.DB) *ChatRepository {
+	return &ChatRepository{db: db}
+}
+
+func (r *ChatRepository) Upsert(chat *Chat) error {
+	query := `
+		INSERT INTO "fzChat" ("userId", "sessionId", "chatJid", "name", "unreadCount", "archived", "pinned", "lastMessageAt", "updatedAt")
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())
+		ON CONFLICT ("sessionId", "chatJid") DO UPDATE
+		SET "name" = COALESCE(NULLIF(EXCLUDED."name", ''), "fzChat"."name"),
+		    "unreadCount" = EXCLUDED."unreadCount",
+		    "archived" = EXCLUDED."archived",
+		    "pinned" = EXCLUDED."pinned",
+		    "lastMessageAt" = GREATEST("fzChat"."lastMessageAt", EXCLUDED."lastMessageAt"),
+		    "updatedAt" = NOW()
+	`
+	_, err := r.db.Exec(query, chat.UserID, chat.SessionID, chat.ChatJID, chat.Name, chat.UnreadCount, chat.Archived, chat.Pinned, chat.LastMessageAt)
+	return err
+}
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"fiozap/internal/model"
+)
+
+const endpointColumns = `"id", "userId", "sessionId", "url", "events", "headers", "enabled", "maxAttempts", "maxAge",
+		"secret", "secretPrevious", "secretPreviousExpiresAt", "createdAt", "updatedAt"`
+
+type WebhookEndpointRepository struct {
+	db *sqlx.DB
+}
+
+func NewWebhookEndpointRepository(db *sqlx.DB) *WebhookEndpointRepository {
+	return &WebhookEndpointRepository{db: db}
+}
+
+func (r *WebhookEndpointRepository) Create(userID, sessionID string, req *model.WebhookEndpointCreateRequest) (*model.WebhookEndpoint, error) {
+	id := generateID()
+
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	maxAttempts := req.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = 8
+	}
+
+	maxAge := 86400
+	if req.MaxAge != nil {
+		maxAge = *req.MaxAge
+	}
+
+	query := `
+		INSERT INTO "fzWebhookEndpoint" ("id", "userId", "sessionId", "url", "events", "headers", "enabled", "maxAttempts", "maxAge", "secret")
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+
+	_, err := r.db.Exec(query, id, userID, sessionID, req.URL, strings.Join(req.Events, ","), model.WebhookHeaders(req.Headers),
+		enabled, maxAttempts, maxAge, generateSecret())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook endpoint: %w", err)
+	}
+
+	return r.GetByID(sessionID, id)
+}
+
+func (r *WebhookEndpointRepository) GetByID(sessionID, id string) (*model.WebhookEndpoint, error) {
+	var endpoint model.WebhookEndpoint
+	query := `
+		SELECT ` + endpointColumns + `
+		FROM "fzWebhookEndpoint"
+		WHERE "sessionId" = $1 AND "id" = $2
+	`
+
+	if err := r.db.Get(&endpoint, query, sessionID, id); err != nil {
+		return nil, err
+	}
+
+	return &endpoint, nil
+}
+
+func (r *WebhookEndpointRepository) GetAllBySession(sessionID string) ([]model.WebhookEndpoint, error) {
+	var endpoints []model.WebhookEndpoint
+	query := `
+		SELECT ` + endpointColumns + `
+		FROM "fzWebhookEndpoint"
+		WHERE "sessionId" = $1
+		ORDER BY "createdAt" ASC
+	`
+
+	err := r.db.Select(&endpoints, query, sessionID)
+	return endpoints, err
+}
+
+func (r *WebhookEndpointRepository) Update(sessionID, id string, req *model.WebhookEndpointUpdateRequest) (*model.WebhookEndpoint, error) {
+	endpoint, err := r.GetByID(sessionID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.URL != nil {
+		endpoint.URL = *req.URL
+	}
+	if req.Events != nil {
+		endpoint.Events = strings.Join(*req.Events, ",")
+	}
+	if req.Headers != nil {
+		endpoint.Headers = *req.Headers
+	}
+	if req.Enabled != nil {
+		endpoint.Enabled = *req.Enabled
+	}
+	if req.MaxAttempts != nil {
+		endpoint.MaxAttempts = *req.MaxAttempts
+	}
+	if req.MaxAge != nil {
+		endpoint.MaxAge = *req.MaxAge
+	}
+
+	query := `
+		UPDATE "fzWebhookEndpoint"
+		SET "url" = $1, "events" = $2, "headers" = $3, "enabled" = $4, "maxAttempts" = $5, "maxAge" = $6, "updatedAt" = NOW()
+		WHERE "sessionId" = $7 AND "id" = $8
+	`
+
+	_, err = r.db.Exec(query, endpoint.URL, endpoint.Events, endpoint.Headers, endpoint.Enabled, endpoint.MaxAttempts, endpoint.MaxAge,
+		sessionID, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update webhook endpoint: %w", err)
+	}
+
+	return r.GetByID(sessionID, id)
+}
+
+// Delete removes the endpoint along with its queued deliveries.
+func (r *WebhookEndpointRepository) Delete(sessionID, id string) (bool, error) {
+	query := `DELETE FROM "fzWebhookEndpoint" WHERE "sessionId" = $1 AND "id" = $2`
+	result, err := r.db.Exec(query, sessionID, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// RotateSecret works like SessionRepository.RotateWebhookSecret.
+func (r *WebhookEndpointRepository) RotateSecret(sessionID, id string, gracePeriod time.Duration) (*model.WebhookEndpoint, error) {
+	query := `
+		UPDATE "fzWebhookEndpoint"
+		SET "secretPrevious" = "secret", "secret" = $1, "secretPreviousExpiresAt" = $2, "updatedAt" = NOW()
+		WHERE "sessionId" = $3 AND "id" = $4
+	`
+	if _, err := r.db.Exec(query, generateSecret(), time.Now().UTC().Add(gracePeriod), sessionID, id); err != nil {
+		return nil, fmt.Errorf("failed to rotate webhook endpoint secret: %w", err)
+	}
+
+	return r.GetByID(sessionID, id)
+}
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type Media struct {
+	ID         string    `json:"id" db:"id"`
+	UserID     string    `json:"-" db:"userId"`
+	SessionID  string    `json:"sessionId" db:"sessionId"`
+	MessageID  string    `json:"messageId" db:"messageId"`
+	StorageKey string    `json:"-" db:"storageKey"`
+	MimeType   string    `json:"mimeType" db:"mimeType"`
+	FileName   string    `json:"fileName" db:"fileName"`
+	Size       int64     `json:"size" db:"size"`
+	SHA256     string    `json:"sha256" db:"sha256"`
+	CreatedAt  time.Time `json:"createdAt" db:"createdAt"`
+}
+
+type MediaRepository struct {
+	db *sqlx.DB
+}
+
+func NewMediaRepository(db *sqlx.DB) *MediaRepository {
+	return &MediaRepository{db: db}
+}
+
+const mediaColumns = `"id", "userId", "sessionId", "messageId", "storageKey", "mimeType",
+		COALESCE("fileName", '') as "fileName", "size", "sha256", "createdAt"`
+
+// Create stores the media record. A message that was already downloaded
+// keeps its existing record, which is returned instead.
+func (r *MediaRepository) Create(media *Media) (*Media, error) {
+	if media.ID == "" {
+		media.ID = generateID()
+	}
+
+	query := `
+		INSERT INTO "fzMedia" ("id", "userId", "sessionId", "messageId", "storageKey", "mimeType", "fileName", "size", "sha256")
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT ("sessionId", "messageId") DO NOTHING
+	`
+	_, err := r.db.Exec(query, media.ID, media.UserID, media.SessionID, media.MessageID, media.StorageKey,
+		media.MimeType, media.FileName, media.Size, media.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.GetByMessageID(media.SessionID, media.MessageID)
+}
+
+func (r *MediaRepository) GetByID(sessionID, id string) (*Media, error) {
+	var media Media
+	query := `SELECT ` + mediaColumns + ` FROM "fzMedia" WHERE "sessionId" = $1 AND "id" = $2`
+	if err := r.db.Get(&media, query, sessionID, id); err != nil {
+		return nil, err
+	}
+	return &media, nil
+}
+
+func (r *MediaRepository) GetByMessageID(sessionID, messageID string) (*Media, error) {
+	var media Media
+	query := `SELECT ` + mediaColumns + ` FROM "fzMedia" WHERE "sessionId" = $1 AND "messageId" = $2`
+	if err := r.db.Get(&media, query, sessionID, messageID); err != nil {
+		return nil, err
+	}
+	return &media, nil
+}
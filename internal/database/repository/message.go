@@ -1,22 +1,64 @@
 package repository
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
+const messageColumns = `"id", "userId", "sessionId", "chatJid", "senderJid", "messageId", "timestamp", "messageType", "fromMe", "textContent", "mediaLink", "quotedMessageId", "revision", "editedAt"`
+
+const createMessageQuery = `
+	INSERT INTO "fzMessage" ("userId", "sessionId", "chatJid", "senderJid", "messageId", "timestamp", "messageType", "fromMe", "textContent", "mediaLink", "quotedMessageId", "searchLanguage")
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11,
+	        COALESCE((SELECT "searchLanguage"::regconfig FROM "fzSession" WHERE "id" = $2), 'portuguese'))
+	ON CONFLICT ("sessionId", "messageId") DO NOTHING
+`
+
 type Message struct {
-	ID              int64     `db:"id"`
-	UserID          string    `db:"userId"`
-	ChatJID         string    `db:"chatJid"`
-	SenderJID       string    `db:"senderJid"`
-	MessageID       string    `db:"messageId"`
-	Timestamp       time.Time `db:"timestamp"`
-	MessageType     string    `db:"messageType"`
-	TextContent     *string   `db:"textContent"`
-	MediaLink       *string   `db:"mediaLink"`
-	QuotedMessageID *string   `db:"quotedMessageId"`
+	ID              int64      `json:"-" db:"id"`
+	UserID          string     `json:"-" db:"userId"`
+	SessionID       string     `json:"sessionId" db:"sessionId"`
+	ChatJID         string     `json:"chatJid" db:"chatJid"`
+	SenderJID       string     `json:"senderJid" db:"senderJid"`
+	MessageID       string     `json:"messageId" db:"messageId"`
+	Timestamp       time.Time  `json:"timestamp" db:"timestamp"`
+	MessageType     string     `json:"messageType" db:"messageType"`
+	FromMe          bool       `json:"fromMe" db:"fromMe"`
+	TextContent     *string    `json:"textContent,omitempty" db:"textContent"`
+	MediaLink       *string    `json:"mediaLink,omitempty" db:"mediaLink"`
+	QuotedMessageID *string    `json:"quotedMessageId,omitempty" db:"quotedMessageId"`
+	Revision        int        `json:"revision" db:"revision"`
+	EditedAt        *time.Time `json:"editedAt,omitempty" db:"editedAt"`
+
+	Revisions []MessageRevision `json:"revisions,omitempty" db:"-"`
+}
+
+// MessageRevision is a past or current text of an edited message. Revision 0
+// is the text the message was sent with.
+type MessageRevision struct {
+	Revision    int       `json:"revision" db:"revision"`
+	TextContent *string   `json:"textContent,omitempty" db:"textContent"`
+	CreatedAt   time.Time `json:"createdAt" db:"createdAt"`
+}
+
+// MessageFilter narrows a chat history query. Zero values are ignored.
+// BeforeTimestamp/BeforeID form a keyset cursor: only messages strictly older
+// than that (timestamp, id) pair are returned.
+type MessageFilter struct {
+	MessageType     string
+	SenderJID       string
+	FromMe          *bool
+	Since           *time.Time
+	Until           *time.Time
+	BeforeTimestamp *time.Time
+	BeforeID        int64
+	Limit           int
 }
 
 type MessageRepository struct {
@@ -27,43 +69,292 @@ func NewMessageRepository(db *sqlx.DB) *MessageRepository {
 	return &MessageRepository{db: db}
 }
 
+type MessageSearch struct {
+	Query   string
+	ChatJID string
+	Since   *time.Time
+	Until   *time.Time
+	Limit   int
+	Offset  int
+}
+
+type MessageSearchResult struct {
+	Message
+	Rank    float64 `json:"rank" db:"rank"`
+	Snippet string  `json:"snippet" db:"snippet"`
+}
+
 func (r *MessageRepository) Create(msg *Message) error {
-	query := `
-		INSERT INTO "fzMessage" ("userId", "chatJid", "senderJid", "messageId", "timestamp", "messageType", "textContent", "mediaLink", "quotedMessageId")
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-		ON CONFLICT ("userId", "messageId") DO NOTHING
-	`
-	_, err := r.db.Exec(query, msg.UserID, msg.ChatJID, msg.SenderJID, msg.MessageID, msg.Timestamp, msg.MessageType, msg.TextContent, msg.MediaLink, msg.QuotedMessageID)
+	_, err := r.db.Exec(createMessageQuery, msg.UserID, msg.SessionID, msg.ChatJID, msg.SenderJID, msg.MessageID, msg.Timestamp, msg.MessageType, msg.FromMe, msg.TextContent, msg.MediaLink, msg.QuotedMessageID)
 	return err
 }
 
-func (r *MessageRepository) GetByChat(userID, chatJID string, limit, offset int) ([]Message, error) {
-	var messages []Message
-	query := `
-		SELECT "id", "userId", "chatJid", "senderJid", "messageId", "timestamp", "messageType", "textContent", "mediaLink", "quotedMessageId"
+// CreateBatch inserts messages in a single transaction and returns how many
+// were new. Messages already stored for the session are left untouched.
+func (r *MessageRepository) CreateBatch(msgs []Message) (int64, error) {
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(createMessageQuery)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var inserted int64
+	for _, msg := range msgs {
+		res, err := stmt.Exec(msg.UserID, msg.SessionID, msg.ChatJID, msg.SenderJID, msg.MessageID, msg.Timestamp, msg.MessageType, msg.FromMe, msg.TextContent, msg.MediaLink, msg.QuotedMessageID)
+		if err != nil {
+			return 0, err
+		}
+		if n, err := res.RowsAffected(); err == nil {
+			inserted += n
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return inserted, nil
+}
+
+func (r *MessageRepository) GetByChat(sessionID, chatJID string, filter MessageFilter) ([]Message, error) {
+	conditions := []string{`"sessionId" = $1`, `"chatJid" = $2`}
+	args := []interface{}{sessionID, chatJID}
+
+	addCondition := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if filter.MessageType != "" {
+		addCondition(`"messageType" = $%d`, filter.MessageType)
+	}
+	if filter.SenderJID != "" {
+		addCondition(`"senderJid" = $%d`, filter.SenderJID)
+	}
+	if filter.FromMe != nil {
+		addCondition(`"fromMe" = $%d`, *filter.FromMe)
+	}
+	if filter.Since != nil {
+		addCondition(`"timestamp" >= $%d`, filter.Since.UTC())
+	}
+	if filter.Until != nil {
+		addCondition(`"timestamp" <= $%d`, filter.Until.UTC())
+	}
+	if filter.BeforeTimestamp != nil {
+		args = append(args, filter.BeforeTimestamp.UTC(), filter.BeforeID)
+		conditions = append(conditions, fmt.Sprintf(`("timestamp", "id") < ($%d, $%d)`, len(args)-1, len(args)))
+	}
+
+	args = append(args, filter.Limit)
+	query := fmt.Sprintf(`
+		SELECT %s
 		FROM "fzMessage"
-		WHERE "userId" = $1 AND "chatJid" = $2
-		ORDER BY "timestamp" DESC
-		LIMIT $3 OFFSET $4
-	`
-	err := r.db.Select(&messages, query, userID, chatJID, limit, offset)
+		WHERE %s
+		ORDER BY "timestamp" DESC, "id" DESC
+		LIMIT $%d
+	`, messageColumns, strings.Join(conditions, " AND "), len(args))
+
+	var messages []Message
+	err := r.db.Select(&messages, query, args...)
 	return messages, err
 }
 
-func (r *MessageRepository) GetByID(userID, messageID string) (*Message, error) {
+// Sentinels ts_headline wraps matches in. They are stripped from the text
+// first, so after HTML-escaping the snippet they only mark matches.
+const (
+	snippetStartSel = "\x01"
+	snippetStopSel  = "\x02"
+)
+
+var snippetHighlighter = strings.NewReplacer(snippetStartSel, "<mark>", snippetStopSel, "</mark>")
+
+// Search runs a full-text query over textContent, ranked by relevance. Each
+// message is matched in the language it was indexed with. The returned
+// snippet is HTML-escaped, with matches wrapped in <mark></mark>.
+func (r *MessageRepository) Search(sessionID string, search MessageSearch) ([]MessageSearchResult, error) {
+	conditions := []string{`"sessionId" = $1`, `"textSearch" @@ "search"."query"`}
+	headlineOptions := fmt.Sprintf(`StartSel="%s", StopSel="%s", MaxFragments=2, MaxWords=30, MinWords=10`, snippetStartSel, snippetStopSel)
+	args := []interface{}{sessionID, search.Query, headlineOptions, snippetStartSel + snippetStopSel}
+
+	addCondition := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if search.ChatJID != "" {
+		addCondition(`"chatJid" = $%d`, search.ChatJID)
+	}
+	if search.Since != nil {
+		addCondition(`"timestamp" >= $%d`, search.Since.UTC())
+	}
+	if search.Until != nil {
+		addCondition(`"timestamp" <= $%d`, search.Until.UTC())
+	}
+
+	// the query is parsed once per text search configuration and joined to
+	// the messages indexed with it, which keeps the GIN index usable
+	args = append(args, search.Limit, search.Offset)
+	query := fmt.Sprintf(`
+		SELECT %s,
+		       ts_rank("textSearch", "search"."query") AS "rank",
+		       ts_headline("searchLanguage", translate(COALESCE("textContent", ''), $4, ''), "search"."query", $3) AS "snippet"
+		FROM "fzMessage"
+		JOIN (
+			SELECT "oid"::regconfig AS "language", websearch_to_tsquery("oid"::regconfig, $2) AS "query"
+			FROM pg_ts_config
+		) AS "search" ON "search"."language" = "fzMessage"."searchLanguage"
+		WHERE %s
+		ORDER BY "rank" DESC, "timestamp" DESC
+		LIMIT $%d OFFSET $%d
+	`, messageColumns, strings.Join(conditions, " AND "), len(args)-1, len(args))
+
+	var results []MessageSearchResult
+	if err := r.db.Select(&results, query, args...); err != nil {
+		return nil, err
+	}
+	for i := range results {
+		results[i].Snippet = highlightSnippet(results[i].Snippet)
+	}
+	return results, nil
+}
+
+// highlightSnippet escapes a headline built with the snippet sentinels and
+// turns them into <mark> tags.
+func highlightSnippet(headline string) string {
+	return snippetHighlighter.Replace(html.EscapeString(headline))
+}
+
+func (r *MessageRepository) GetByID(sessionID, messageID string) (*Message, error) {
 	var msg Message
 	query := `
-		SELECT "id", "userId", "chatJid", "senderJid", "messageId", "timestamp", "messageType", "textContent", "mediaLink", "quotedMessageId"
+		SELECT ` + messageColumns + `
 		FROM "fzMessage"
-		WHERE "userId" = $1 AND "messageId" = $2
+		WHERE "sessionId" = $1 AND "messageId" = $2
 	`
-	err := r.db.Get(&msg, query, userID, messageID)
+	err := r.db.Get(&msg, query, sessionID, messageID)
 	if err != nil {
 		return nil, err
 	}
 	return &msg, nil
 }
 
+// SetMediaLink points a stored message at our copy of its media.
+func (r *MessageRepository) SetMediaLink(sessionID, messageID, mediaLink string) error {
+	query := `UPDATE "fzMessage" SET "mediaLink" = $1 WHERE "sessionId" = $2 AND "messageId" = $3`
+	_, err := r.db.Exec(query, mediaLink, sessionID, messageID)
+	return err
+}
+
+// ErrEditNotAllowed is returned for edits that do not come from the chat and
+// sender of the edited message. WhatsApp clients ignore them.
+var ErrEditNotAllowed = errors.New("edit does not come from the sender of the message")
+
+// MessageEdit is the new text of a message, edited by SenderJID in ChatJID.
+// FromMe marks edits made by the session's own devices.
+type MessageEdit struct {
+	MessageID string
+	ChatJID   string
+	SenderJID string
+	FromMe    bool
+	Text      string
+	EditedAt  time.Time
+}
+
+// AddRevision records an edit of a stored message: the original text is kept
+// as revision 0 on the first edit, and the new text becomes the next revision
+// and the message's current text. It returns false when the message is not
+// stored, and ErrEditNotAllowed when the edit comes from another chat or
+// sender. Edits repeating the current text are ignored.
+func (r *MessageRepository) AddRevision(sessionID string, edit *MessageEdit) (bool, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var current struct {
+		ID          int64     `db:"id"`
+		ChatJID     string    `db:"chatJid"`
+		SenderJID   string    `db:"senderJid"`
+		FromMe      bool      `db:"fromMe"`
+		Revision    int       `db:"revision"`
+		TextContent *string   `db:"textContent"`
+		Timestamp   time.Time `db:"timestamp"`
+	}
+	err = tx.Get(&current, `
+		SELECT "id", "chatJid", "senderJid", "fromMe", "revision", "textContent", "timestamp"
+		FROM "fzMessage"
+		WHERE "sessionId" = $1 AND "messageId" = $2
+		FOR UPDATE
+	`, sessionID, edit.MessageID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// messages are edited by whoever sent them, from any of their devices
+	sameSender := current.FromMe && edit.FromMe ||
+		!current.FromMe && !edit.FromMe && current.SenderJID == edit.SenderJID
+	if current.ChatJID != edit.ChatJID || !sameSender {
+		return true, ErrEditNotAllowed
+	}
+
+	text := edit.Text
+	if current.TextContent != nil && *current.TextContent == text {
+		return true, nil
+	}
+
+	insertRevision := `
+		INSERT INTO "fzMessageRevision" ("messageRowId", "revision", "textContent", "createdAt")
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT ("messageRowId", "revision") DO NOTHING
+	`
+	if current.Revision == 0 {
+		if _, err := tx.Exec(insertRevision, current.ID, 0, current.TextContent, current.Timestamp); err != nil {
+			return false, err
+		}
+	}
+	if _, err := tx.Exec(insertRevision, current.ID, current.Revision+1, text, edit.EditedAt.UTC()); err != nil {
+		return false, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE "fzMessage"
+		SET "textContent" = $2, "revision" = $3, "editedAt" = $4
+		WHERE "id" = $1
+	`, current.ID, text, current.Revision+1, edit.EditedAt.UTC())
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// GetRevisions returns the revisions of a stored message, oldest first.
+func (r *MessageRepository) GetRevisions(sessionID, messageID string) ([]MessageRevision, error) {
+	var revisions []MessageRevision
+	query := `
+		SELECT "fzMessageRevision"."revision", "fzMessageRevision"."textContent", "fzMessageRevision"."createdAt"
+		FROM "fzMessageRevision"
+		JOIN "fzMessage" ON "fzMessage"."id" = "fzMessageRevision"."messageRowId"
+		WHERE "fzMessage"."sessionId" = $1 AND "fzMessage"."messageId" = $2
+		ORDER BY "fzMessageRevision"."revision"
+	`
+	err := r.db.Select(&revisions, query, sessionID, messageID)
+	return revisions, err
+}
+
 func (r *MessageRepository) DeleteOld(olderThan time.Duration) error {
 	query := `DELETE FROM "fzMessage" WHERE "timestamp" < NOW() - $1::interval`
 	_, err := r.db.Exec(query, olderThan.String())
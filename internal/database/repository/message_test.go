@@ -0,0 +1,22 @@
+package repository
+
+import "testing"
+
+func TestHighlightSnippet(t *testing.T) {
+	tests := []struct {
+		headline string
+		want     string
+	}{
+		{"plain text", "plain text"},
+		{"say \x01hello\x02 world", "say <mark>hello</mark> world"},
+		{"\x01<script>\x02alert(1)</script>", "<mark>&lt;script&gt;</mark>alert(1)&lt;/script&gt;"},
+		{"<mark>fake</mark> & \x01real\x02", "&lt;mark&gt;fake&lt;/mark&gt; &amp; <mark>real</mark>"},
+		{`"quoted" 'single'`, "&#34;quoted&#34; &#39;single&#39;"},
+	}
+
+	for _, tt := range tests {
+		if got := highlightSnippet(tt.headline); got != tt.want {
+			t.Errorf("highlightSnippet(%q) = %q, want %q", tt.headline, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+type Poll struct {
+	ID              int64          `json:"-" db:"id"`
+	UserID          string         `json:"-" db:"userId"`
+	SessionID       string         `json:"sessionId" db:"sessionId"`
+	ChatJID         string         `json:"chatJid" db:"chatJid"`
+	SenderJID       string         `json:"senderJid" db:"senderJid"`
+	MessageID       string         `json:"messageId" db:"messageId"`
+	Question        string         `json:"question" db:"question"`
+	Options         pq.StringArray `json:"options" db:"options"`
+	SelectableCount int            `json:"selectableCount" db:"selectableCount"`
+	CreatedAt       time.Time      `json:"createdAt" db:"createdAt"`
+}
+
+// PollVote is the latest selection of a voter, as hex SHA-256 hashes of the
+// option names. An empty selection is a withdrawn vote.
+type PollVote struct {
+	VoterJID       string         `json:"voterJid" db:"voterJid"`
+	SelectedHashes pq.StringArray `json:"selectedHashes" db:"selectedHashes"`
+	VotedAt        time.Time      `json:"votedAt" db:"votedAt"`
+}
+
+type PollRepository struct {
+	db *sqlx.DB
+}
+
+func NewPollRepository(db *sqlx.DB) *PollRepository {
+	return &PollRepository{db: db}
+}
+
+// Create stores a poll. Polls already stored for the session are left
+// untouched.
+func (r *PollRepository) Create(poll *Poll) error {
+	query := `
+		INSERT INTO "fzPoll" ("userId", "sessionId", "chatJid", "senderJid", "messageId", "question", "options", "selectableCount", "createdAt")
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT ("sessionId", "messageId") DO NOTHING
+	`
+	_, err := r.db.Exec(query, poll.UserID, poll.SessionID, poll.ChatJID, poll.SenderJID, poll.MessageID,
+		poll.Question, poll.Options, poll.SelectableCount, poll.CreatedAt)
+	return err
+}
+
+func (r *PollRepository) GetByMessageID(sessionID, messageID string) (*Poll, error) {
+	var poll Poll
+	query := `
+		SELECT "id", "userId", "sessionId", "chatJid", "senderJid", "messageId", "question", "options", "selectableCount", "createdAt"
+		FROM "fzPoll"
+		WHERE "sessionId" = $1 AND "messageId" = $2
+	`
+	err := r.db.Get(&poll, query, sessionID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	return &poll, nil
+}
+
+// SaveVote replaces the voter's selection on a poll, unless a later vote is
+// already stored.
+func (r *PollRepository) SaveVote(sessionID, pollMessageID string, vote *PollVote) error {
+	query := `
+		INSERT INTO "fzPollVote" ("sessionId", "pollMessageId", "voterJid", "selectedHashes", "votedAt")
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT ("sessionId", "pollMessageId", "voterJid") DO UPDATE
+		SET "selectedHashes" = EXCLUDED."selectedHashes", "votedAt" = EXCLUDED."votedAt"
+		WHERE "fzPollVote"."votedAt" <= EXCLUDED."votedAt"
+	`
+	_, err := r.db.Exec(query, sessionID, pollMessageID, vote.VoterJID, vote.SelectedHashes, vote.VotedAt.UTC())
+	return err
+}
+
+func (r *PollRepository) GetVotes(sessionID, pollMessageID string) ([]PollVote, error) {
+	var votes []PollVote
+	query := `
+		SELECT "voterJid", "selectedHashes", "votedAt"
+		FROM "fzPollVote"
+		WHERE "sessionId" = $1 AND "pollMessageId" = $2
+		ORDER BY "votedAt"
+	`
+	err := r.db.Select(&votes, query, sessionID, pollMessageID)
+	return votes, err
+}
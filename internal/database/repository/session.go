@@ -2,12 +2,18 @@ package repository
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 
 	"fiozap/internal/model"
 )
 
+const sessionColumns = `"id", "userId", "name", "jid", "qrCode", "connected", "webhook", "events", "proxyUrl",
+		COALESCE("deviceJid", '') as "deviceJid", "createdAt", "storeMessages", "searchLanguage",
+		"historyDays", "historyMessages", "webhookSecret", "webhookSecretPrevious", "webhookSecretPreviousExpiresAt",
+		"webhookMaxAttempts", "webhookMaxAge", "redisStream"`
+
 type SessionRepository struct {
 	db *sqlx.DB
 }
@@ -19,12 +25,34 @@ func NewSessionRepository(db *sqlx.DB) *SessionRepository {
 func (r *SessionRepository) Create(userID string, req *model.SessionCreateRequest) (*model.Session, error) {
 	id := generateID()
 
+	storeMessages := true
+	if req.StoreMessages != nil {
+		storeMessages = *req.StoreMessages
+	}
+
+	searchLanguage := req.SearchLanguage
+	if searchLanguage == "" {
+		searchLanguage = "portuguese"
+	}
+
+	webhookMaxAttempts := req.WebhookMaxAttempts
+	if webhookMaxAttempts == 0 {
+		webhookMaxAttempts = 8
+	}
+
+	webhookMaxAge := 86400
+	if req.WebhookMaxAge != nil {
+		webhookMaxAge = *req.WebhookMaxAge
+	}
+
 	query := `
-		INSERT INTO "fzSession" ("id", "userId", "name", "webhook", "events", "proxyUrl")
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO "fzSession" ("id", "userId", "name", "webhook", "events", "proxyUrl", "storeMessages", "searchLanguage", "historyDays", "historyMessages",
+		                         "webhookSecret", "webhookMaxAttempts", "webhookMaxAge", "redisStream")
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
 	`
 
-	_, err := r.db.Exec(query, id, userID, req.Name, req.Webhook, req.Events, req.ProxyURL)
+	_, err := r.db.Exec(query, id, userID, req.Name, req.Webhook, req.Events, req.ProxyURL, storeMessages, searchLanguage, req.HistoryDays, req.HistoryMessages,
+		generateSecret(), webhookMaxAttempts, webhookMaxAge, req.RedisStream)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create session: %w", err)
 	}
@@ -35,7 +63,7 @@ func (r *SessionRepository) Create(userID string, req *model.SessionCreateReques
 func (r *SessionRepository) GetByID(id string) (*model.Session, error) {
 	var session model.Session
 	query := `
-		SELECT "id", "userId", "name", "jid", "qrCode", "connected", "webhook", "events", "proxyUrl", "createdAt"
+		SELECT ` + sessionColumns + `
 		FROM "fzSession" 
 		WHERE "id" = $1
 	`
@@ -50,7 +78,7 @@ func (r *SessionRepository) GetByID(id string) (*model.Session, error) {
 func (r *SessionRepository) GetByUserAndName(userID, name string) (*model.Session, error) {
 	var session model.Session
 	query := `
-		SELECT "id", "userId", "name", "jid", "qrCode", "connected", "webhook", "events", "proxyUrl", "createdAt"
+		SELECT ` + sessionColumns + `
 		FROM "fzSession" 
 		WHERE "userId" = $1 AND "name" = $2
 	`
@@ -65,7 +93,7 @@ func (r *SessionRepository) GetByUserAndName(userID, name string) (*model.Sessio
 func (r *SessionRepository) GetAllByUser(userID string) ([]model.Session, error) {
 	var sessions []model.Session
 	query := `
-		SELECT "id", "userId", "name", "jid", "qrCode", "connected", "webhook", "events", "proxyUrl", "createdAt"
+		SELECT ` + sessionColumns + `
 		FROM "fzSession" 
 		WHERE "userId" = $1
 		ORDER BY "createdAt" DESC
@@ -81,7 +109,7 @@ func (r *SessionRepository) GetAllByUser(userID string) ([]model.Session, error)
 func (r *SessionRepository) GetAll() ([]model.Session, error) {
 	var sessions []model.Session
 	query := `
-		SELECT "id", "userId", "name", "jid", "qrCode", "connected", "webhook", "events", "proxyUrl", "createdAt"
+		SELECT ` + sessionColumns + `
 		FROM "fzSession"
 		ORDER BY "createdAt" DESC
 	`
@@ -111,14 +139,37 @@ func (r *SessionRepository) Update(id string, req *model.SessionUpdateRequest) (
 	if req.ProxyURL != nil {
 		session.ProxyURL = *req.ProxyURL
 	}
+	if req.StoreMessages != nil {
+		session.StoreMessages = *req.StoreMessages
+	}
+	if req.SearchLanguage != nil && *req.SearchLanguage != "" {
+		session.SearchLanguage = *req.SearchLanguage
+	}
+	if req.HistoryDays != nil {
+		session.HistoryDays = *req.HistoryDays
+	}
+	if req.HistoryMessages != nil {
+		session.HistoryMessages = *req.HistoryMessages
+	}
+	if req.WebhookMaxAttempts != nil {
+		session.WebhookMaxAttempts = *req.WebhookMaxAttempts
+	}
+	if req.WebhookMaxAge != nil {
+		session.WebhookMaxAge = *req.WebhookMaxAge
+	}
+	if req.RedisStream != nil {
+		session.RedisStream = *req.RedisStream
+	}
 
 	query := `
 		UPDATE "fzSession" 
-		SET "name" = $1, "webhook" = $2, "events" = $3, "proxyUrl" = $4
-		WHERE "id" = $5
+		SET "name" = $1, "webhook" = $2, "events" = $3, "proxyUrl" = $4, "storeMessages" = $5, "searchLanguage" = $6,
+		    "historyDays" = $7, "historyMessages" = $8, "webhookMaxAttempts" = $9, "webhookMaxAge" = $10, "redisStream" = $11
+		WHERE "id" = $12
 	`
 
-	_, err = r.db.Exec(query, session.Name, session.Webhook, session.Events, session.ProxyURL, id)
+	_, err = r.db.Exec(query, session.Name, session.Webhook, session.Events, session.ProxyURL, session.StoreMessages, session.SearchLanguage,
+		session.HistoryDays, session.HistoryMessages, session.WebhookMaxAttempts, session.WebhookMaxAge, session.RedisStream, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update session: %w", err)
 	}
@@ -150,6 +201,12 @@ func (r *SessionRepository) UpdateJID(id string, jid string) error {
 	return err
 }
 
+func (r *SessionRepository) UpdateDeviceJID(id string, deviceJID string) error {
+	query := `UPDATE "fzSession" SET "deviceJid" = $1 WHERE "id" = $2`
+	_, err := r.db.Exec(query, deviceJID, id)
+	return err
+}
+
 func (r *SessionRepository) UpdateQRCode(id string, qrcode string) error {
 	query := `UPDATE "fzSession" SET "qrCode" = $1 WHERE "id" = $2`
 	_, err := r.db.Exec(query, qrcode, id)
@@ -162,10 +219,26 @@ func (r *SessionRepository) UpdateWebhook(id string, webhook string, events stri
 	return err
 }
 
+// RotateWebhookSecret replaces the webhook secret. The old secret keeps being
+// used to sign deliveries for the grace period, so receivers can switch over.
+func (r *SessionRepository) RotateWebhookSecret(id string, gracePeriod time.Duration) (*model.Session, error) {
+	query := `
+		UPDATE "fzSession"
+		SET "webhookSecretPrevious" = "webhookSecret", "webhookSecret" = $1,
+		    "webhookSecretPreviousExpiresAt" = $2
+		WHERE "id" = $3
+	`
+	if _, err := r.db.Exec(query, generateSecret(), time.Now().UTC().Add(gracePeriod), id); err != nil {
+		return nil, fmt.Errorf("failed to rotate webhook secret: %w", err)
+	}
+
+	return r.GetByID(id)
+}
+
 func (r *SessionRepository) GetConnectedSessions() ([]model.Session, error) {
 	var sessions []model.Session
 	query := `
-		SELECT "id", "userId", "name", "jid", "qrCode", "connected", "webhook", "events", "proxyUrl", "createdAt"
+		SELECT ` + sessionColumns + `
 		FROM "fzSession" 
 		WHERE "connected" = 1
 	`
@@ -198,3 +271,14 @@ func (r *SessionRepository) BelongsToUser(sessionID, userID string) (bool, error
 
 	return count > 0, nil
 }
+
+func (r *SessionRepository) SearchLanguageExists(language string) (bool, error) {
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM pg_ts_config WHERE "cfgname" = $1)`
+
+	if err := r.db.Get(&exists, query, language); err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// EventSinkRepository keeps the position of each event sink in the event
+// log (the session deliveries of fzWebhook).
+type EventSinkRepository struct {
+	db *sqlx.DB
+}
+
+func NewEventSinkRepository(db *sqlx.DB) *EventSinkRepository {
+	return &EventSinkRepository{db: db}
+}
+
+// EventSinkLease is a sink taken by one instance, at Position, the seq of
+// the last event published.
+type EventSinkLease struct {
+	Token    string
+	Position int64
+}
+
+// Init registers a sink at the end of the event log, so it only publishes
+// events created from then on. Known sinks keep their position.
+func (r *EventSinkRepository) Init(name string) error {
+	query := `
+		INSERT INTO "fzEventSink" ("name", "lastSeq")
+		SELECT $1, COALESCE(MAX("seq"), 0) FROM "fzWebhook" WHERE "endpointId" IS NULL
+		ON CONFLICT ("name") DO NOTHING
+	`
+	_, err := r.db.Exec(query, name)
+	return err
+}
+
+// Lease takes the sink for lease so only one instance publishes it at a
+// time. It returns nil when another instance holds the sink.
+func (r *EventSinkRepository) Lease(name string, lease time.Duration) (*EventSinkLease, error) {
+	l := EventSinkLease{Token: generateID()}
+	query := `
+		UPDATE "fzEventSink"
+		SET "leaseToken" = $2, "lockedUntil" = NOW() + make_interval(secs => $3)
+		WHERE "name" = $1 AND ("lockedUntil" IS NULL OR "lockedUntil" < NOW())
+		RETURNING "lastSeq"
+	`
+	err := r.db.Get(&l.Position, query, name, l.Token, lease.Seconds())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// Release stores the position reached under the lease and gives the sink
+// up. Nothing is stored when the lease expired and another instance took the
+// sink over.
+func (r *EventSinkRepository) Release(name string, lease *EventSinkLease) error {
+	query := `
+		UPDATE "fzEventSink"
+		SET "lastSeq" = $3, "leaseToken" = NULL, "lockedUntil" = NULL, "updatedAt" = NOW()
+		WHERE "name" = $1 AND "leaseToken" = $2
+	`
+	_, err := r.db.Exec(query, name, lease.Token, lease.Position)
+	return err
+}
@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"fiozap/internal/database/dbtest"
+)
+
+func lease(t *testing.T, repo *EventSinkRepository, lease time.Duration) *EventSinkLease {
+	t.Helper()
+
+	l, err := repo.Lease("test", lease)
+	if err != nil {
+		t.Fatalf("Lease() error = %v", err)
+	}
+	return l
+}
+
+func TestEventSinkInit(t *testing.T) {
+	db := dbtest.Open(t)
+	repo := NewEventSinkRepository(db)
+	webhookRepo := NewWebhookRepository(db)
+
+	createEvents(t, db, newTestSession(t, db, "a"), 2)
+	last, err := webhookRepo.LastSeq()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a new sink starts at the end of the log
+	if err := repo.Init("test"); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	l := lease(t, repo, time.Minute)
+	if l == nil || l.Position != last {
+		t.Fatalf("Lease() = %+v, want position %d", l, last)
+	}
+	l.Position = last - 1
+	if err := repo.Release("test", l); err != nil {
+		t.Fatal(err)
+	}
+
+	// a known sink keeps its position
+	if err := repo.Init("test"); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	if l := lease(t, repo, time.Minute); l == nil || l.Position != last-1 {
+		t.Fatalf("Lease() = %+v after Init(), want position %d", l, last-1)
+	}
+}
+
+func TestEventSinkLease(t *testing.T) {
+	db := dbtest.Open(t)
+	repo := NewEventSinkRepository(db)
+
+	if err := repo.Init("test"); err != nil {
+		t.Fatal(err)
+	}
+
+	first := lease(t, repo, time.Minute)
+	if first == nil {
+		t.Fatal("Lease() = nil for a free sink")
+	}
+	if l := lease(t, repo, time.Minute); l != nil {
+		t.Fatalf("Lease() = %+v while the sink is held", l)
+	}
+
+	first.Position = 5
+	if err := repo.Release("test", first); err != nil {
+		t.Fatal(err)
+	}
+	second := lease(t, repo, time.Minute)
+	if second == nil || second.Position != 5 {
+		t.Fatalf("Lease() = %+v after Release(), want position 5", second)
+	}
+}
+
+func TestEventSinkExpiredLease(t *testing.T) {
+	db := dbtest.Open(t)
+	repo := NewEventSinkRepository(db)
+
+	if err := repo.Init("test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a lease that already ran out, as if the instance holding it stalled
+	stale := lease(t, repo, -time.Second)
+	if stale == nil {
+		t.Fatal("Lease() = nil for a free sink")
+	}
+	current := lease(t, repo, time.Minute)
+	if current == nil {
+		t.Fatal("Lease() = nil after the lease expired")
+	}
+
+	current.Position = 7
+	if err := repo.Release("test", current); err != nil {
+		t.Fatal(err)
+	}
+
+	// the stalled instance must not move the sink back
+	stale.Position = 3
+	if err := repo.Release("test", stale); err != nil {
+		t.Fatal(err)
+	}
+	if l := lease(t, repo, time.Minute); l == nil || l.Position != 7 {
+		t.Fatalf("Lease() = %+v, want position 7", l)
+	}
+}
@@ -27,11 +27,11 @@ func (r *UserRepository) Create(req *model.UserCreateRequest) (*model.User, erro
 	}
 
 	query := `
-		INSERT INTO "fzUser" ("id", "name", "token", "maxSessions")
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO "fzUser" ("id", "name", "token", "maxSessions", "maxUploadMB")
+		VALUES ($1, $2, $3, $4, $5)
 	`
 
-	_, err := r.db.Exec(query, id, req.Name, req.Token, maxSessions)
+	_, err := r.db.Exec(query, id, req.Name, req.Token, maxSessions, req.MaxUploadMB)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
@@ -41,7 +41,7 @@ func (r *UserRepository) Create(req *model.UserCreateRequest) (*model.User, erro
 
 func (r *UserRepository) GetByID(id string) (*model.User, error) {
 	var user model.User
-	query := `SELECT "id", "name", "token", COALESCE("maxSessions", 5) as "maxSessions", "createdAt" FROM "fzUser" WHERE "id" = $1`
+	query := `SELECT "id", "name", "token", COALESCE("maxSessions", 5) as "maxSessions", "maxUploadMB", "createdAt" FROM "fzUser" WHERE "id" = $1`
 
 	if err := r.db.Get(&user, query, id); err != nil {
 		return nil, err
@@ -52,7 +52,7 @@ func (r *UserRepository) GetByID(id string) (*model.User, error) {
 
 func (r *UserRepository) GetByToken(token string) (*model.User, error) {
 	var user model.User
-	query := `SELECT "id", "name", "token", COALESCE("maxSessions", 5) as "maxSessions", "createdAt" FROM "fzUser" WHERE "token" = $1`
+	query := `SELECT "id", "name", "token", COALESCE("maxSessions", 5) as "maxSessions", "maxUploadMB", "createdAt" FROM "fzUser" WHERE "token" = $1`
 
 	if err := r.db.Get(&user, query, token); err != nil {
 		return nil, err
@@ -63,7 +63,7 @@ func (r *UserRepository) GetByToken(token string) (*model.User, error) {
 
 func (r *UserRepository) GetAll() ([]model.User, error) {
 	var users []model.User
-	query := `SELECT "id", "name", "token", COALESCE("maxSessions", 5) as "maxSessions", "createdAt" FROM "fzUser"`
+	query := `SELECT "id", "name", "token", COALESCE("maxSessions", 5) as "maxSessions", "maxUploadMB", "createdAt" FROM "fzUser"`
 
 	if err := r.db.Select(&users, query); err != nil {
 		return nil, err
@@ -87,14 +87,17 @@ func (r *UserRepository) Update(id string, req *model.UserUpdateRequest) (*model
 	if req.MaxSessions != nil {
 		user.MaxSessions = *req.MaxSessions
 	}
+	if req.MaxUploadMB != nil {
+		user.MaxUploadMB = *req.MaxUploadMB
+	}
 
 	query := `
 		UPDATE "fzUser" 
-		SET "name" = $1, "token" = $2, "maxSessions" = $3
-		WHERE "id" = $4
+		SET "name" = $1, "token" = $2, "maxSessions" = $3, "maxUploadMB" = $4
+		WHERE "id" = $5
 	`
 
-	_, err = r.db.Exec(query, user.Name, user.Token, user.MaxSessions, id)
+	_, err = r.db.Exec(query, user.Name, user.Token, user.MaxSessions, user.MaxUploadMB, id)
 	if err != nil {
 		return nil, fmt.Errorf("failed to update user: %w", err)
 	}
@@ -113,3 +116,9 @@ func generateID() string {
 	rand.Read(b)
 	return hex.EncodeToString(b)
 }
+
+func generateSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -2,21 +2,111 @@ package repository
 
 import (
 	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
+// WebhookNotifyChannel is notified whenever events become due for dispatch.
+const WebhookNotifyChannel = "fzWebhook"
+
+// EventNotifyChannel is notified with "<userId>:<sessionId>" for every new
+// event, for live event streams.
+const EventNotifyChannel = "fzEvent"
+
+const webhookColumns = `"id", "userId", COALESCE("sessionId", '') AS "sessionId", COALESCE("endpointId", '') AS "endpointId", "eventType", "payload", "status", "attempts", "lastAttempt",
+	"nextAttemptAt", "lastStatusCode", "lastResponse", "lastError", "replayedAt", "createdAt", COALESCE("seq", 0) AS "seq"`
+
 type WebhookEvent struct {
-	ID          int64           `db:"id"`
-	UserID      string          `db:"userId"`
-	SessionID   string          `db:"sessionId"`
-	EventType   string          `db:"eventType"`
-	Payload     json.RawMessage `db:"payload"`
-	Status      string          `db:"status"`
-	Attempts    int             `db:"attempts"`
-	LastAttempt *time.Time      `db:"lastAttempt"`
-	CreatedAt   time.Time       `db:"createdAt"`
+	ID             int64           `json:"id" db:"id"`
+	UserID         string          `json:"-" db:"userId"`
+	SessionID      string          `json:"sessionId" db:"sessionId"`
+	EndpointID     string          `json:"endpointId,omitempty" db:"endpointId"`
+	EventType      string          `json:"eventType" db:"eventType"`
+	Payload        json.RawMessage `json:"payload" db:"payload"`
+	Status         string          `json:"status" db:"status"`
+	Attempts       int             `json:"attempts" db:"attempts"`
+	LastAttempt    *time.Time      `json:"lastAttempt,omitempty" db:"lastAttempt"`
+	NextAttemptAt  time.Time       `json:"nextAttemptAt" db:"nextAttemptAt"`
+	LastStatusCode *int            `json:"lastStatusCode,omitempty" db:"lastStatusCode"`
+	LastResponse   string          `json:"lastResponse,omitempty" db:"lastResponse"`
+	LastError      string          `json:"lastError,omitempty" db:"lastError"`
+	ReplayedAt     *time.Time      `json:"replayedAt,omitempty" db:"replayedAt"`
+	CreatedAt      time.Time       `json:"createdAt" db:"createdAt"`
+	Seq            int64           `json:"-" db:"seq"`
+}
+
+// WebhookAttempt is the outcome of a delivery attempt. StatusCode is 0 when
+// no response was received.
+type WebhookAttempt struct {
+	StatusCode int
+	Response   string
+	Error      string
+}
+
+func (a WebhookAttempt) statusCode() *int {
+	if a.StatusCode == 0 {
+		return nil
+	}
+	return &a.StatusCode
+}
+
+// WebhookFilter narrows a delivery listing or replay. Zero values are
+// ignored. BeforeID is a keyset cursor: only older events are returned.
+type WebhookFilter struct {
+	ID         int64
+	EndpointID string
+	Statuses   []string
+	EventType  string
+	Since      *time.Time
+	Until      *time.Time
+	BeforeID   int64
+	Limit      int
+}
+
+// conditions builds the WHERE clause for the filter, after the sessionId
+// placeholder $1.
+func (f WebhookFilter) conditions(sessionID string) (string, []interface{}) {
+	conditions := []string{`"sessionId" = $1`}
+	args := []interface{}{sessionID}
+
+	addCondition := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if f.ID > 0 {
+		addCondition(`"id" = $%d`, f.ID)
+	}
+	if f.EndpointID != "" {
+		addCondition(`"endpointId" = $%d`, f.EndpointID)
+	}
+	if len(f.Statuses) > 0 {
+		placeholders := make([]string, len(f.Statuses))
+		for i, status := range f.Statuses {
+			args = append(args, status)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		conditions = append(conditions, `"status" IN (`+strings.Join(placeholders, ", ")+`)`)
+	}
+	if f.EventType != "" {
+		addCondition(`"eventType" = $%d`, f.EventType)
+	}
+	if f.Since != nil {
+		addCondition(`"createdAt" >= $%d`, f.Since.UTC())
+	}
+	if f.Until != nil {
+		addCondition(`"createdAt" <= $%d`, f.Until.UTC())
+	}
+	if f.BeforeID > 0 {
+		addCondition(`"id" < $%d`, f.BeforeID)
+	}
+
+	return strings.Join(conditions, " AND "), args
 }
 
 type WebhookRepository struct {
@@ -27,50 +117,340 @@ func NewWebhookRepository(db *sqlx.DB) *WebhookRepository {
 	return &WebhookRepository{db: db}
 }
 
+// Create queues an event. Session events fan out to one delivery for the
+// session's own webhook and one for each enabled endpoint subscribed to the
+// event type. The session delivery is always recorded, as "skipped" when no
+// webhook is set, since it also backs the event streams.
 func (r *WebhookRepository) Create(userID, sessionID, eventType string, payload interface{}) error {
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
 
+	if sessionID == "" {
+		query := `
+			WITH "inserted" AS (
+				INSERT INTO "fzWebhook" ("userId", "sessionId", "eventType", "payload", "status", "attempts", "createdAt")
+				VALUES ($1, $2, $3, $4, 'pending', 0, NOW())
+			)
+			SELECT pg_notify($5, ''), pg_notify($6, $1 || ':')
+		`
+		_, err = r.db.Exec(query, userID, sessionID, eventType, payloadBytes, WebhookNotifyChannel, EventNotifyChannel)
+		return err
+	}
+
+	// data-modifying CTEs run to completion even though they are not read
 	query := `
-		INSERT INTO "fzWebhook" ("userId", "sessionId", "eventType", "payload", "status", "attempts", "createdAt")
-		VALUES ($1, $2, $3, $4, 'pending', 0, NOW())
+		WITH "inserted" AS (
+			INSERT INTO "fzWebhook" ("userId", "sessionId", "endpointId", "eventType", "payload", "status", "attempts", "createdAt")
+			SELECT $1::varchar, "id", NULL, $3::varchar, $4::jsonb,
+			       CASE WHEN "webhook" <> '' THEN 'pending' ELSE 'skipped' END, 0, NOW()
+			FROM "fzSession"
+			WHERE "id" = $2
+			UNION ALL
+			SELECT $1::varchar, "sessionId", "id", $3::varchar, $4::jsonb, 'pending', 0, NOW()
+			FROM "fzWebhookEndpoint"
+			WHERE "sessionId" = $2 AND "enabled"
+			  AND string_to_array("events", ',') && ARRAY[$3::text, 'All']
+		)
+		SELECT pg_notify($5, ''), pg_notify($6, $1::text || ':' || $2::text)
 	`
-	_, err = r.db.Exec(query, userID, sessionID, eventType, payloadBytes)
+	_, err = r.db.Exec(query, userID, sessionID, eventType, payloadBytes, WebhookNotifyChannel, EventNotifyChannel)
 	return err
 }
 
-func (r *WebhookRepository) GetPending(limit int) ([]WebhookEvent, error) {
+// EventFilter selects events for a stream: those of a user, or of one of
+// its sessions, after a position in the event log. Leaving both empty
+// selects every event.
+type EventFilter struct {
+	UserID     string
+	SessionID  string
+	EventTypes []string
+	// After is the seq of the last event read
+	After int64
+	Limit int
+	// RedisStream keeps the events of sessions with redisStream enabled at
+	// the time of reading, whatever the flag was when they were created
+	RedisStream bool
+}
+
+// Sequence numbers the committed session events that are not in the event
+// log yet, in ID order. Event IDs are taken before commit, so events can
+// become visible out of ID order; seq is assigned afterwards, in a short
+// transaction of its own, so it only grows in commit order and readers
+// following the log by seq never skip an event. Sequencing is serialized
+// with an advisory lock, and a transaction that is still running only
+// delays its own events.
+func (r *WebhookRepository) Sequence() error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('fzEventSeq'))`); err != nil {
+		return err
+	}
+
+	query := `
+		WITH "numbered" AS (
+			SELECT "id", nextval('"fzEventSeq"') AS "seq"
+			FROM (
+				SELECT "id" FROM "fzWebhook"
+				WHERE "endpointId" IS NULL AND "seq" IS NULL
+				ORDER BY "id"
+			) AS "unsequenced"
+		)
+		UPDATE "fzWebhook"
+		SET "seq" = "numbered"."seq"
+		FROM "numbered"
+		WHERE "fzWebhook"."id" = "numbered"."id"
+	`
+	if _, err := tx.Exec(query); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListEvents sequences newly committed events and returns those after
+// filter.After in log order. Each event is listed once, through its session
+// delivery, whatever endpoints it was fanned out to.
+func (r *WebhookRepository) ListEvents(filter EventFilter) ([]WebhookEvent, error) {
+	if err := r.Sequence(); err != nil {
+		return nil, err
+	}
+
+	conditions := []string{`"endpointId" IS NULL`, `"seq" > $1`}
+	args := []interface{}{filter.After}
+
+	if filter.SessionID != "" {
+		args = append(args, filter.SessionID)
+		conditions = append(conditions, fmt.Sprintf(`"sessionId" = $%d`, len(args)))
+	} else if filter.UserID != "" {
+		args = append(args, filter.UserID)
+		conditions = append(conditions, fmt.Sprintf(`"userId" = $%d`, len(args)))
+	}
+	if len(filter.EventTypes) > 0 {
+		args = append(args, pq.Array(filter.EventTypes))
+		conditions = append(conditions, fmt.Sprintf(`"eventType" = ANY($%d)`, len(args)))
+	}
+	if filter.RedisStream {
+		conditions = append(conditions, `"sessionId" IN (SELECT "id" FROM "fzSession" WHERE "redisStream")`)
+	}
+
+	args = append(args, filter.Limit)
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM "fzWebhook"
+		WHERE %s
+		ORDER BY "seq" ASC
+		LIMIT $%d
+	`, webhookColumns, strings.Join(conditions, " AND "), len(args))
+
 	var events []WebhookEvent
+	err := r.db.Select(&events, query, args...)
+	return events, err
+}
+
+// LastSeq returns the end of the event log. Events that are not sequenced
+// yet come after it.
+func (r *WebhookRepository) LastSeq() (int64, error) {
+	var seq int64
+	err := r.db.Get(&seq, `SELECT COALESCE(MAX("seq"), 0) FROM "fzWebhook" WHERE "endpointId" IS NULL`)
+	return seq, err
+}
+
+// Claim leases up to limit due events for dispatching. Events of a receiver
+// (session and endpoint) are only claimed while none of its events is leased
+// elsewhere or waiting for a retry, and at most perReceiver at a time, so
+// each receiver gets its events in order even with several instances
+// dispatching. Claims are
+// serialized with an advisory lock; a lease that is not released by one of
+// the Mark methods or Release expires after lease.
+func (r *WebhookRepository) Claim(limit, perReceiver int, lease time.Duration) ([]WebhookEvent, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('fzWebhookClaim'))`); err != nil {
+		return nil, err
+	}
+
 	query := `
-		SELECT "id", "userId", COALESCE("sessionId", '') as "sessionId", "eventType", "payload", "status", "attempts", "lastAttempt", "createdAt"
+		WITH "ready" AS (
+			SELECT "id", ROW_NUMBER() OVER (PARTITION BY "sessionId", "endpointId" ORDER BY "id") AS "position"
+			FROM "fzWebhook" AS "w"
+			WHERE "status" = 'pending' AND "nextAttemptAt" <= NOW()
+			  AND ("lockedUntil" IS NULL OR "lockedUntil" < NOW())
+			  AND NOT EXISTS (
+			      SELECT 1 FROM "fzWebhook" AS "l"
+			      WHERE "l"."sessionId" = "w"."sessionId" AND "l"."endpointId" IS NOT DISTINCT FROM "w"."endpointId"
+			        AND "l"."status" = 'pending' AND "l"."lockedUntil" >= NOW()
+			  )
+			  -- an older event waiting for its retry goes first
+			  AND NOT EXISTS (
+			      SELECT 1 FROM "fzWebhook" AS "o"
+			      WHERE "o"."sessionId" = "w"."sessionId" AND "o"."endpointId" IS NOT DISTINCT FROM "w"."endpointId"
+			        AND "o"."status" = 'pending' AND "o"."id" < "w"."id" AND "o"."nextAttemptAt" > NOW()
+			  )
+		), "claimed" AS (
+			SELECT "id" FROM "fzWebhook"
+			WHERE "id" IN (SELECT "id" FROM "ready" WHERE "position" <= $2)
+			ORDER BY "id"
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE "fzWebhook"
+		SET "lockedUntil" = NOW() + make_interval(secs => $3)
+		WHERE "id" IN (SELECT "id" FROM "claimed")
+		RETURNING ` + webhookColumns
+
+	var events []WebhookEvent
+	if err := tx.Select(&events, query, limit, perReceiver, lease.Seconds()); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	// each receiver's events are handed out in ID order
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].ID < events[j].ID
+	})
+	return events, nil
+}
+
+// Release gives up the lease on events that were claimed but not handled.
+func (r *WebhookRepository) Release(ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	query := `UPDATE "fzWebhook" SET "lockedUntil" = NULL WHERE "id" = ANY($1)`
+	if _, err := r.db.Exec(query, pq.Array(ids)); err != nil {
+		return err
+	}
+	return r.notify()
+}
+
+func (r *WebhookRepository) notify() error {
+	_, err := r.db.Exec(`SELECT pg_notify($1, '')`, WebhookNotifyChannel)
+	return err
+}
+
+// List returns the session's events matching the filter, newest first.
+func (r *WebhookRepository) List(sessionID string, filter WebhookFilter) ([]WebhookEvent, error) {
+	where, args := filter.conditions(sessionID)
+	args = append(args, filter.Limit)
+	query := fmt.Sprintf(`
+		SELECT %s
 		FROM "fzWebhook"
-		WHERE "status" = 'pending' AND "attempts" < 3
-		ORDER BY "createdAt" ASC
-		LIMIT $1
-	`
-	err := r.db.Select(&events, query, limit)
+		WHERE %s
+		ORDER BY "id" DESC
+		LIMIT $%d
+	`, webhookColumns, where, len(args))
+
+	var events []WebhookEvent
+	err := r.db.Select(&events, query, args...)
 	return events, err
 }
 
+func (r *WebhookRepository) GetByID(sessionID string, id int64) (*WebhookEvent, error) {
+	var event WebhookEvent
+	query := `
+		SELECT ` + webhookColumns + `
+		FROM "fzWebhook"
+		WHERE "sessionId" = $1 AND "id" = $2
+	`
+	if err := r.db.Get(&event, query, sessionID, id); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// Replay queues the matching sent or failed events again with a fresh
+// attempt budget. Pending and skipped events are left alone. It returns how
+// many events were queued.
+func (r *WebhookRepository) Replay(sessionID string, filter WebhookFilter) (int64, error) {
+	where, args := filter.conditions(sessionID)
+	query := `
+		UPDATE "fzWebhook"
+		SET "status" = 'pending', "attempts" = 0, "nextAttemptAt" = NOW(), "replayedAt" = NOW(), "lockedUntil" = NULL
+		WHERE ` + where + ` AND "status" IN ('sent', 'failed')`
+
+	result, err := r.db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err == nil && n > 0 {
+		err = r.notify()
+	}
+	return n, err
+}
+
+// MarkSent marks an event as handled without delivering it, e.g. when the
+// session is not subscribed to its type.
 func (r *WebhookRepository) MarkSent(id int64) error {
-	query := `UPDATE "fzWebhook" SET "status" = 'sent', "lastAttempt" = NOW() WHERE "id" = $1`
+	query := `UPDATE "fzWebhook" SET "status" = 'sent', "lastAttempt" = NOW(), "lockedUntil" = NULL WHERE "id" = $1`
 	_, err := r.db.Exec(query, id)
 	return err
 }
 
-func (r *WebhookRepository) MarkFailed(id int64) error {
+// MarkDelivered records a successful attempt.
+func (r *WebhookRepository) MarkDelivered(id int64, attempt WebhookAttempt) error {
 	query := `
-		UPDATE "fzWebhook" 
-		SET "attempts" = "attempts" + 1, "lastAttempt" = NOW(),
-		    "status" = CASE WHEN "attempts" >= 2 THEN 'failed' ELSE 'pending' END
+		UPDATE "fzWebhook"
+		SET "status" = 'sent', "attempts" = "attempts" + 1, "lastAttempt" = NOW(), "lockedUntil" = NULL,
+		    "lastStatusCode" = $2, "lastResponse" = $3, "lastError" = ''
 		WHERE "id" = $1
 	`
-	_, err := r.db.Exec(query, id)
+	_, err := r.db.Exec(query, id, attempt.statusCode(), attempt.Response)
 	return err
 }
 
+// MarkFailed gives up on the event without further retries.
+func (r *WebhookRepository) MarkFailed(id int64, attempt WebhookAttempt) error {
+	query := `
+		UPDATE "fzWebhook"
+		SET "attempts" = "attempts" + 1, "lastAttempt" = NOW(), "status" = 'failed', "lockedUntil" = NULL,
+		    "lastStatusCode" = $2, "lastResponse" = $3, "lastError" = $4
+		WHERE "id" = $1
+	`
+	_, err := r.db.Exec(query, id, attempt.statusCode(), attempt.Response, attempt.Error)
+	return err
+}
+
+// ScheduleRetry records a failed attempt and schedules the next one after
+// delay. The event fails instead once maxAttempts is reached or the retry
+// would land after maxAge (0 means no age limit). It reports whether another
+// attempt was scheduled.
+func (r *WebhookRepository) ScheduleRetry(id int64, attempt WebhookAttempt, delay time.Duration, maxAttempts int, maxAge time.Duration) (bool, error) {
+	query := `
+		UPDATE "fzWebhook"
+		SET "attempts" = "attempts" + 1, "lastAttempt" = NOW(), "lockedUntil" = NULL,
+		    "lastStatusCode" = $5, "lastResponse" = $6, "lastError" = $7,
+		    "nextAttemptAt" = NOW() + make_interval(secs => $2),
+		    "status" = CASE
+		        WHEN "attempts" + 1 >= $3 THEN 'failed'
+		        WHEN $4 > 0 AND NOW() + make_interval(secs => $2) > COALESCE("replayedAt", "createdAt") + make_interval(secs => $4) THEN 'failed'
+		        ELSE 'pending'
+		    END
+		WHERE "id" = $1
+		RETURNING "status"
+	`
+	var status string
+	err := r.db.Get(&status, query, id, delay.Seconds(), maxAttempts, int(maxAge.Seconds()),
+		attempt.statusCode(), attempt.Response, attempt.Error)
+	if err != nil {
+		return false, err
+	}
+	return status == "pending", nil
+}
+
 func (r *WebhookRepository) DeleteOld(olderThan time.Duration) error {
 	query := `DELETE FROM "fzWebhook" WHERE "createdAt" < NOW() - $1::interval`
 	_, err := r.db.Exec(query, olderThan.String())
@@ -0,0 +1,234 @@
+package repository
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"fiozap/internal/database/dbtest"
+	"fiozap/internal/model"
+)
+
+func newTestSession(t *testing.T, db *sqlx.DB, name string) *model.Session {
+	t.Helper()
+
+	user, err := NewUserRepository(db).Create(&model.UserCreateRequest{Name: name, Token: "token-" + name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := NewSessionRepository(db).Create(user.ID, &model.SessionCreateRequest{
+		Name:    name,
+		Webhook: "https://example.com/" + name,
+		Events:  "All",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+// createEvents queues n events for the session and returns the IDs of their
+// deliveries.
+func createEvents(t *testing.T, db *sqlx.DB, session *model.Session, n int) []int64 {
+	t.Helper()
+
+	repo := NewWebhookRepository(db)
+	for range n {
+		if err := repo.Create(session.UserID, session.ID, "Message", map[string]string{"text": "hi"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ids []int64
+	if err := db.Select(&ids, `SELECT "id" FROM "fzWebhook" WHERE "sessionId" = $1 ORDER BY "id"`, session.ID); err != nil {
+		t.Fatal(err)
+	}
+	return ids[len(ids)-n:]
+}
+
+func eventIDs(events []WebhookEvent) []int64 {
+	ids := make([]int64, len(events))
+	for i, event := range events {
+		ids[i] = event.ID
+	}
+	return ids
+}
+
+func claim(t *testing.T, repo *WebhookRepository, limit, perReceiver int, lease time.Duration) []int64 {
+	t.Helper()
+
+	events, err := repo.Claim(limit, perReceiver, lease)
+	if err != nil {
+		t.Fatalf("Claim() error = %v", err)
+	}
+	return eventIDs(events)
+}
+
+func TestClaimKeepsReceiversInOrder(t *testing.T) {
+	db := dbtest.Open(t)
+	repo := NewWebhookRepository(db)
+
+	a := createEvents(t, db, newTestSession(t, db, "a"), 3)
+	b := createEvents(t, db, newTestSession(t, db, "b"), 1)
+
+	if got, want := claim(t, repo, 10, 2, time.Minute), []int64{a[0], a[1], b[0]}; !slices.Equal(got, want) {
+		t.Fatalf("Claim() = %v, want %v", got, want)
+	}
+
+	// a receiver with leased events gets none of its later ones
+	if got := claim(t, repo, 10, 2, time.Minute); len(got) != 0 {
+		t.Fatalf("Claim() = %v while every receiver holds a lease", got)
+	}
+
+	if err := repo.MarkDelivered(b[0], WebhookAttempt{StatusCode: 200}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.ScheduleRetry(a[0], WebhookAttempt{StatusCode: 503}, time.Hour, 8, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Release([]int64{a[1]}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a's later events wait for the retry of its first one
+	if got := claim(t, repo, 10, 2, time.Minute); len(got) != 0 {
+		t.Fatalf("Claim() = %v while an older event waits for its retry", got)
+	}
+
+	if _, err := db.Exec(`UPDATE "fzWebhook" SET "nextAttemptAt" = NOW() WHERE "id" = $1`, a[0]); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := claim(t, repo, 10, 2, time.Minute), []int64{a[0], a[1]}; !slices.Equal(got, want) {
+		t.Fatalf("Claim() = %v once the retry is due, want %v", got, want)
+	}
+}
+
+func TestClaimLimit(t *testing.T) {
+	db := dbtest.Open(t)
+	repo := NewWebhookRepository(db)
+
+	var ids []int64
+	for _, name := range []string{"a", "b", "c"} {
+		ids = append(ids, createEvents(t, db, newTestSession(t, db, name), 1)...)
+	}
+
+	if got, want := claim(t, repo, 2, 1, time.Minute), ids[:2]; !slices.Equal(got, want) {
+		t.Fatalf("Claim() = %v, want %v", got, want)
+	}
+	if got, want := claim(t, repo, 2, 1, time.Minute), ids[2:]; !slices.Equal(got, want) {
+		t.Fatalf("Claim() = %v, want %v", got, want)
+	}
+}
+
+func TestClaimExpiredLease(t *testing.T) {
+	db := dbtest.Open(t)
+	repo := NewWebhookRepository(db)
+
+	ids := createEvents(t, db, newTestSession(t, db, "a"), 1)
+
+	// a lease that already ran out, as if the instance holding it died
+	if got := claim(t, repo, 10, 1, -time.Second); !slices.Equal(got, ids) {
+		t.Fatalf("Claim() = %v, want %v", got, ids)
+	}
+	if got := claim(t, repo, 10, 1, time.Minute); !slices.Equal(got, ids) {
+		t.Fatalf("Claim() = %v after the lease expired, want %v", got, ids)
+	}
+	if got := claim(t, repo, 10, 1, time.Minute); len(got) != 0 {
+		t.Fatalf("Claim() = %v while the lease holds", got)
+	}
+}
+
+func listEvents(t *testing.T, repo *WebhookRepository, filter EventFilter) []WebhookEvent {
+	t.Helper()
+
+	events, err := repo.ListEvents(filter)
+	if err != nil {
+		t.Fatalf("ListEvents() error = %v", err)
+	}
+	return events
+}
+
+func TestListEventsInCommitOrder(t *testing.T) {
+	db := dbtest.Open(t)
+	repo := NewWebhookRepository(db)
+	session := newTestSession(t, db, "a")
+
+	// an event whose transaction commits late, such as a history sync batch
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	var lateID int64
+	err = tx.Get(&lateID, `
+		INSERT INTO "fzWebhook" ("userId", "sessionId", "eventType", "payload", "status", "attempts", "createdAt")
+		VALUES ($1, $2, 'Message', '{}', 'skipped', 0, NOW())
+		RETURNING "id"
+	`, session.UserID, session.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := createEvents(t, db, session, 2)
+
+	// the open transaction holds back its own event only
+	events := listEvents(t, repo, EventFilter{SessionID: session.ID, Limit: 10})
+	if got := eventIDs(events); !slices.Equal(got, ids) {
+		t.Fatalf("ListEvents() = %v, want %v", got, ids)
+	}
+	if events[0].Seq == 0 || events[1].Seq <= events[0].Seq {
+		t.Fatalf("ListEvents() seqs = %d, %d, want increasing", events[0].Seq, events[1].Seq)
+	}
+	last := events[1].Seq
+
+	if got, err := repo.LastSeq(); err != nil || got != last {
+		t.Fatalf("LastSeq() = %d, %v, want %d", got, err, last)
+	}
+
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// committed after the others, it comes after them despite its lower ID
+	events = listEvents(t, repo, EventFilter{SessionID: session.ID, After: last, Limit: 10})
+	if got := eventIDs(events); !slices.Equal(got, []int64{lateID}) {
+		t.Fatalf("ListEvents() after %d = %v, want [%d]", last, got, lateID)
+	}
+	if events[0].Seq <= last {
+		t.Errorf("late event seq = %d, want after %d", events[0].Seq, last)
+	}
+}
+
+func TestListEventsFilter(t *testing.T) {
+	db := dbtest.Open(t)
+	repo := NewWebhookRepository(db)
+
+	a := newTestSession(t, db, "a")
+	b := newTestSession(t, db, "b")
+	createEvents(t, db, a, 1)
+	createEvents(t, db, b, 1)
+	if err := repo.Create(a.UserID, a.ID, "ReadReceipt", map[string]string{}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		filter EventFilter
+		want   int
+	}{
+		{"session", EventFilter{SessionID: a.ID}, 2},
+		{"user", EventFilter{UserID: b.UserID}, 1},
+		{"event types", EventFilter{SessionID: a.ID, EventTypes: []string{"Message"}}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.filter.Limit = 10
+			if got := listEvents(t, repo, tt.filter); len(got) != tt.want {
+				t.Errorf("ListEvents() = %v, want %d events", eventIDs(got), tt.want)
+			}
+		})
+	}
+}
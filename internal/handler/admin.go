@@ -81,6 +81,11 @@ func (h *AdminHandler) AddUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.MaxUploadMB < 0 {
+		model.RespondBadRequest(w, errors.New("maxUploadMB must not be negative"))
+		return
+	}
+
 	user, err := h.userRepo.Create(&req)
 	if err != nil {
 		model.RespondInternalError(w, err)
@@ -112,6 +117,11 @@ func (h *AdminHandler) EditUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.MaxUploadMB != nil && *req.MaxUploadMB < 0 {
+		model.RespondBadRequest(w, errors.New("maxUploadMB must not be negative"))
+		return
+	}
+
 	user, err := h.userRepo.Update(id, &req)
 	if err != nil {
 		model.RespondInternalError(w, err)
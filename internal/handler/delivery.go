@@ -0,0 +1,285 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"fiozap/internal/database/repository"
+	"fiozap/internal/middleware"
+	"fiozap/internal/model"
+)
+
+const (
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 200
+)
+
+var deliveryStatuses = []string{"pending", "sent", "failed", "skipped"}
+
+// ListDeliveries godoc
+// @Summary List webhook deliveries
+// @Description List the webhook events of the session, newest first, with the outcome of their last attempt
+// @Tags Webhook
+// @Produce json
+// @Param sessionId path string true "Session name"
+// @Param status query string false "Comma separated statuses (pending, sent, failed, skipped)"
+// @Param event query string false "Event type"
+// @Param endpoint query string false "Endpoint ID"
+// @Param since query string false "Start of date range (unix seconds or RFC3339)"
+// @Param until query string false "End of date range (unix seconds or RFC3339)"
+// @Param cursor query string false "Cursor returned as nextCursor by the previous page"
+// @Param limit query int false "Page size (default 50, max 200)"
+// @Success 200 {object} model.Response
+// @Failure 400 {object} model.Response
+// @Security ApiKeyAuth
+// @Router /sessions/{sessionId}/webhook/deliveries [get]
+func (h *WebhookHandler) ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	session := middleware.GetSessionFromContext(r.Context())
+	if session == nil {
+		model.RespondUnauthorized(w, errors.New("session not found"))
+		return
+	}
+
+	query := r.URL.Query()
+	filter, err := parseDeliveryFilter(query.Get("status"), query.Get("event"), query.Get("since"), query.Get("until"))
+	if err != nil {
+		model.RespondBadRequest(w, err)
+		return
+	}
+	filter.EndpointID = query.Get("endpoint")
+
+	filter.Limit = defaultDeliveryLimit
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			model.RespondBadRequest(w, errors.New("limit must be a positive integer"))
+			return
+		}
+		filter.Limit = min(n, maxDeliveryLimit)
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		id, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil || id < 1 {
+			model.RespondBadRequest(w, errors.New("invalid cursor"))
+			return
+		}
+		filter.BeforeID = id
+	}
+
+	limit := filter.Limit
+	filter.Limit++
+	deliveries, err := h.webhookRepo.List(session.ID, filter)
+	if err != nil {
+		model.RespondInternalError(w, err)
+		return
+	}
+
+	nextCursor := ""
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+		nextCursor = strconv.FormatInt(deliveries[len(deliveries)-1].ID, 10)
+	}
+
+	if deliveries == nil {
+		deliveries = []repository.WebhookEvent{}
+	}
+
+	model.RespondOK(w, map[string]interface{}{
+		"deliveries": deliveries,
+		"nextCursor": nextCursor,
+	})
+}
+
+// GetDelivery godoc
+// @Summary Get webhook delivery
+// @Description Get a webhook event with its payload and the outcome of its last attempt
+// @Tags Webhook
+// @Produce json
+// @Param sessionId path string true "Session name"
+// @Param deliveryId path int true "Delivery ID"
+// @Success 200 {object} model.Response
+// @Failure 404 {object} model.Response
+// @Security ApiKeyAuth
+// @Router /sessions/{sessionId}/webhook/deliveries/{deliveryId} [get]
+func (h *WebhookHandler) GetDelivery(w http.ResponseWriter, r *http.Request) {
+	session := middleware.GetSessionFromContext(r.Context())
+	if session == nil {
+		model.RespondUnauthorized(w, errors.New("session not found"))
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["deliveryId"], 10, 64)
+	if err != nil {
+		model.RespondNotFound(w, errors.New("delivery not found"))
+		return
+	}
+
+	delivery, err := h.webhookRepo.GetByID(session.ID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		model.RespondNotFound(w, errors.New("delivery not found"))
+		return
+	}
+	if err != nil {
+		model.RespondInternalError(w, err)
+		return
+	}
+
+	model.RespondOK(w, delivery)
+}
+
+// ReplayDelivery godoc
+// @Summary Replay webhook delivery
+// @Description Queue a sent or failed webhook event for delivery again. The delivery ID is kept, so receivers that deduplicate on it must allow replays
+// @Tags Webhook
+// @Produce json
+// @Param sessionId path string true "Session name"
+// @Param deliveryId path int true "Delivery ID"
+// @Success 200 {object} model.Response
+// @Failure 404 {object} model.Response
+// @Failure 409 {object} model.Response
+// @Security ApiKeyAuth
+// @Router /sessions/{sessionId}/webhook/deliveries/{deliveryId}/replay [post]
+func (h *WebhookHandler) ReplayDelivery(w http.ResponseWriter, r *http.Request) {
+	session := middleware.GetSessionFromContext(r.Context())
+	if session == nil {
+		model.RespondUnauthorized(w, errors.New("session not found"))
+		return
+	}
+
+	id, err := strconv.ParseInt(mux.Vars(r)["deliveryId"], 10, 64)
+	if err != nil {
+		model.RespondNotFound(w, errors.New("delivery not found"))
+		return
+	}
+
+	delivery, err := h.webhookRepo.GetByID(session.ID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		model.RespondNotFound(w, errors.New("delivery not found"))
+		return
+	}
+	if err != nil {
+		model.RespondInternalError(w, err)
+		return
+	}
+
+	replayed := int64(0)
+	if delivery.Status == "sent" || delivery.Status == "failed" {
+		replayed, err = h.webhookRepo.Replay(session.ID, repository.WebhookFilter{ID: id})
+		if err != nil {
+			model.RespondInternalError(w, err)
+			return
+		}
+	}
+
+	if replayed == 0 {
+		model.RespondError(w, http.StatusConflict, fmt.Errorf("%s deliveries cannot be replayed", delivery.Status))
+		return
+	}
+
+	model.RespondOK(w, map[string]interface{}{
+		"replayed": replayed,
+	})
+}
+
+// ReplayDeliveries godoc
+// @Summary Replay webhook deliveries
+// @Description Queue matching webhook events for delivery again, e.g. every failed event or everything sent during a receiver outage. Only failed events are replayed unless status says otherwise
+// @Tags Webhook
+// @Accept json
+// @Produce json
+// @Param sessionId path string true "Session name"
+// @Param request body object{status=string,event=string,endpoint=string,since=string,until=string} false "Filters: comma separated statuses (sent, failed), event type, endpoint ID and date range (unix seconds or RFC3339)"
+// @Success 200 {object} model.Response
+// @Failure 400 {object} model.Response
+// @Security ApiKeyAuth
+// @Router /sessions/{sessionId}/webhook/deliveries/replay [post]
+func (h *WebhookHandler) ReplayDeliveries(w http.ResponseWriter, r *http.Request) {
+	session := middleware.GetSessionFromContext(r.Context())
+	if session == nil {
+		model.RespondUnauthorized(w, errors.New("session not found"))
+		return
+	}
+
+	req := struct {
+		Status   string `json:"status"`
+		Event    string `json:"event"`
+		Endpoint string `json:"endpoint"`
+		Since    string `json:"since"`
+		Until    string `json:"until"`
+	}{}
+	// the body is optional
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		model.RespondBadRequest(w, errors.New("invalid payload"))
+		return
+	}
+
+	if req.Status == "" {
+		req.Status = "failed"
+	}
+
+	filter, err := parseDeliveryFilter(req.Status, req.Event, req.Since, req.Until)
+	if err != nil {
+		model.RespondBadRequest(w, err)
+		return
+	}
+	filter.EndpointID = req.Endpoint
+
+	for _, status := range filter.Statuses {
+		if status != "sent" && status != "failed" {
+			model.RespondBadRequest(w, fmt.Errorf("%s deliveries cannot be replayed", status))
+			return
+		}
+	}
+
+	replayed, err := h.webhookRepo.Replay(session.ID, filter)
+	if err != nil {
+		model.RespondInternalError(w, err)
+		return
+	}
+
+	model.RespondOK(w, map[string]interface{}{
+		"replayed": replayed,
+	})
+}
+
+func parseDeliveryFilter(status, event, since, until string) (repository.WebhookFilter, error) {
+	filter := repository.WebhookFilter{EventType: event}
+
+	if status != "" {
+		for _, s := range strings.Split(status, ",") {
+			s = strings.TrimSpace(s)
+			if !isDeliveryStatus(s) {
+				return filter, fmt.Errorf("invalid status: %s", s)
+			}
+			filter.Statuses = append(filter.Statuses, s)
+		}
+	}
+
+	var err error
+	if filter.Since, err = parseTimeParam(since); err != nil {
+		return filter, fmt.Errorf("since: %w", err)
+	}
+	if filter.Until, err = parseTimeParam(until); err != nil {
+		return filter, fmt.Errorf("until: %w", err)
+	}
+
+	return filter, nil
+}
+
+func isDeliveryStatus(status string) bool {
+	for _, s := range deliveryStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,351 @@
+package handler
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"fiozap/internal/middleware"
+	"fiozap/internal/model"
+	"fiozap/internal/webhook"
+)
+
+// ListEndpoints godoc
+// @Summary List webhook endpoints
+// @Description List the additional webhook endpoints of the session
+// @Tags Webhook
+// @Produce json
+// @Param sessionId path string true "Session name"
+// @Success 200 {object} model.Response
+// @Failure 401 {object} model.Response
+// @Security ApiKeyAuth
+// @Router /sessions/{sessionId}/webhook/endpoints [get]
+func (h *WebhookHandler) ListEndpoints(w http.ResponseWriter, r *http.Request) {
+	session := middleware.GetSessionFromContext(r.Context())
+	if session == nil {
+		model.RespondUnauthorized(w, errors.New("session not found"))
+		return
+	}
+
+	endpoints, err := h.endpointRepo.GetAllBySession(session.ID)
+	if err != nil {
+		model.RespondInternalError(w, err)
+		return
+	}
+
+	result := make([]map[string]interface{}, 0, len(endpoints))
+	for i := range endpoints {
+		result = append(result, endpointResponse(&endpoints[i]))
+	}
+
+	model.RespondOK(w, result)
+}
+
+// CreateEndpoint godoc
+// @Summary Create webhook endpoint
+// @Description Add a webhook endpoint with its own event subscription, signing secret, custom headers and retry policy. Every event is delivered to each subscribed endpoint separately. Without events the endpoint subscribes to All
+// @Tags Webhook
+// @Accept json
+// @Produce json
+// @Param sessionId path string true "Session name"
+// @Param request body model.WebhookEndpointCreateRequest true "Endpoint configuration"
+// @Success 201 {object} model.Response
+// @Failure 400 {object} model.Response
+// @Security ApiKeyAuth
+// @Router /sessions/{sessionId}/webhook/endpoints [post]
+func (h *WebhookHandler) CreateEndpoint(w http.ResponseWriter, r *http.Request) {
+	session := middleware.GetSessionFromContext(r.Context())
+	if session == nil {
+		model.RespondUnauthorized(w, errors.New("session not found"))
+		return
+	}
+
+	var req model.WebhookEndpointCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		model.RespondBadRequest(w, errors.New("invalid payload"))
+		return
+	}
+
+	if err := validateEndpointURL(req.URL); err != nil {
+		model.RespondBadRequest(w, err)
+		return
+	}
+
+	if err := validateEndpointHeaders(req.Headers); err != nil {
+		model.RespondBadRequest(w, err)
+		return
+	}
+
+	if req.MaxAttempts < 0 || (req.MaxAge != nil && *req.MaxAge < 0) {
+		model.RespondBadRequest(w, errors.New("maxAttempts and maxAge must not be negative"))
+		return
+	}
+
+	events, err := endpointEvents(req.Events)
+	if err != nil {
+		model.RespondBadRequest(w, err)
+		return
+	}
+	req.Events = events
+
+	endpoint, err := h.endpointRepo.Create(session.UserID, session.ID, &req)
+	if err != nil {
+		model.RespondInternalError(w, err)
+		return
+	}
+
+	model.RespondCreated(w, endpointResponse(endpoint))
+}
+
+// GetEndpoint godoc
+// @Summary Get webhook endpoint
+// @Description Get a webhook endpoint, including its signing secret
+// @Tags Webhook
+// @Produce json
+// @Param sessionId path string true "Session name"
+// @Param endpointId path string true "Endpoint ID"
+// @Success 200 {object} model.Response
+// @Failure 404 {object} model.Response
+// @Security ApiKeyAuth
+// @Router /sessions/{sessionId}/webhook/endpoints/{endpointId} [get]
+func (h *WebhookHandler) GetEndpoint(w http.ResponseWriter, r *http.Request) {
+	session := middleware.GetSessionFromContext(r.Context())
+	if session == nil {
+		model.RespondUnauthorized(w, errors.New("session not found"))
+		return
+	}
+
+	endpoint, err := h.endpointRepo.GetByID(session.ID, mux.Vars(r)["endpointId"])
+	if errors.Is(err, sql.ErrNoRows) {
+		model.RespondNotFound(w, errors.New("endpoint not found"))
+		return
+	}
+	if err != nil {
+		model.RespondInternalError(w, err)
+		return
+	}
+
+	model.RespondOK(w, endpointResponse(endpoint))
+}
+
+// UpdateEndpoint godoc
+// @Summary Update webhook endpoint
+// @Description Update the URL, events, headers, enabled flag or retry policy of a webhook endpoint
+// @Tags Webhook
+// @Accept json
+// @Produce json
+// @Param sessionId path string true "Session name"
+// @Param endpointId path string true "Endpoint ID"
+// @Param request body model.WebhookEndpointUpdateRequest true "Fields to update"
+// @Success 200 {object} model.Response
+// @Failure 400 {object} model.Response
+// @Failure 404 {object} model.Response
+// @Security ApiKeyAuth
+// @Router /sessions/{sessionId}/webhook/endpoints/{endpointId} [put]
+func (h *WebhookHandler) UpdateEndpoint(w http.ResponseWriter, r *http.Request) {
+	session := middleware.GetSessionFromContext(r.Context())
+	if session == nil {
+		model.RespondUnauthorized(w, errors.New("session not found"))
+		return
+	}
+
+	var req model.WebhookEndpointUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		model.RespondBadRequest(w, errors.New("invalid payload"))
+		return
+	}
+
+	if req.URL != nil {
+		if err := validateEndpointURL(*req.URL); err != nil {
+			model.RespondBadRequest(w, err)
+			return
+		}
+	}
+
+	if req.Headers != nil {
+		if err := validateEndpointHeaders(*req.Headers); err != nil {
+			model.RespondBadRequest(w, err)
+			return
+		}
+	}
+
+	if req.MaxAttempts != nil && *req.MaxAttempts < 1 {
+		model.RespondBadRequest(w, errors.New("maxAttempts must be at least 1"))
+		return
+	}
+
+	if req.MaxAge != nil && *req.MaxAge < 0 {
+		model.RespondBadRequest(w, errors.New("maxAge must not be negative"))
+		return
+	}
+
+	if req.Events != nil {
+		events, err := endpointEvents(*req.Events)
+		if err != nil {
+			model.RespondBadRequest(w, err)
+			return
+		}
+		req.Events = &events
+	}
+
+	endpoint, err := h.endpointRepo.Update(session.ID, mux.Vars(r)["endpointId"], &req)
+	if errors.Is(err, sql.ErrNoRows) {
+		model.RespondNotFound(w, errors.New("endpoint not found"))
+		return
+	}
+	if err != nil {
+		model.RespondInternalError(w, err)
+		return
+	}
+
+	model.RespondOK(w, endpointResponse(endpoint))
+}
+
+// DeleteEndpoint godoc
+// @Summary Delete webhook endpoint
+// @Description Delete a webhook endpoint along with its queued and past deliveries
+// @Tags Webhook
+// @Produce json
+// @Param sessionId path string true "Session name"
+// @Param endpointId path string true "Endpoint ID"
+// @Success 200 {object} model.Response
+// @Failure 404 {object} model.Response
+// @Security ApiKeyAuth
+// @Router /sessions/{sessionId}/webhook/endpoints/{endpointId} [delete]
+func (h *WebhookHandler) DeleteEndpoint(w http.ResponseWriter, r *http.Request) {
+	session := middleware.GetSessionFromContext(r.Context())
+	if session == nil {
+		model.RespondUnauthorized(w, errors.New("session not found"))
+		return
+	}
+
+	deleted, err := h.endpointRepo.Delete(session.ID, mux.Vars(r)["endpointId"])
+	if err != nil {
+		model.RespondInternalError(w, err)
+		return
+	}
+
+	if !deleted {
+		model.RespondNotFound(w, errors.New("endpoint not found"))
+		return
+	}
+
+	model.RespondOK(w, map[string]string{
+		"details": "Endpoint deleted successfully",
+	})
+}
+
+// RotateEndpointSecret godoc
+// @Summary Rotate webhook endpoint secret
+// @Description Generate a new signing secret for the endpoint. During the grace period deliveries carry signatures for both the new and the previous secret
+// @Tags Webhook
+// @Accept json
+// @Produce json
+// @Param sessionId path string true "Session name"
+// @Param endpointId path string true "Endpoint ID"
+// @Param request body object{gracePeriod=int} false "Grace period in seconds (default 86400, max 604800)"
+// @Success 200 {object} model.Response
+// @Failure 400 {object} model.Response
+// @Failure 404 {object} model.Response
+// @Security ApiKeyAuth
+// @Router /sessions/{sessionId}/webhook/endpoints/{endpointId}/secret/rotate [post]
+func (h *WebhookHandler) RotateEndpointSecret(w http.ResponseWriter, r *http.Request) {
+	session := middleware.GetSessionFromContext(r.Context())
+	if session == nil {
+		model.RespondUnauthorized(w, errors.New("session not found"))
+		return
+	}
+
+	gracePeriod, err := readGracePeriod(r)
+	if err != nil {
+		model.RespondBadRequest(w, err)
+		return
+	}
+
+	endpointID := mux.Vars(r)["endpointId"]
+	if _, err := h.endpointRepo.GetByID(session.ID, endpointID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			model.RespondNotFound(w, errors.New("endpoint not found"))
+			return
+		}
+		model.RespondInternalError(w, err)
+		return
+	}
+
+	updated, err := h.endpointRepo.RotateSecret(session.ID, endpointID, gracePeriod)
+	if err != nil {
+		model.RespondInternalError(w, err)
+		return
+	}
+
+	model.RespondOK(w, map[string]interface{}{
+		"secret":                  updated.Secret,
+		"previousSecretExpiresAt": updated.SecretPreviousExpiresAt,
+	})
+}
+
+func endpointResponse(e *model.WebhookEndpoint) map[string]interface{} {
+	events := []string{}
+	if e.Events != "" {
+		events = strings.Split(e.Events, ",")
+	}
+
+	return map[string]interface{}{
+		"id":          e.ID,
+		"url":         e.URL,
+		"events":      events,
+		"headers":     e.Headers,
+		"enabled":     e.Enabled,
+		"maxAttempts": e.MaxAttempts,
+		"maxAge":      e.MaxAge,
+		"secret":      e.Secret,
+		"createdAt":   e.CreatedAt,
+		"updatedAt":   e.UpdatedAt,
+	}
+}
+
+// endpointEvents returns the event types an endpoint subscribes to. No
+// event types subscribe it to all of them, while a list of only unknown
+// ones is rejected rather than leaving the endpoint subscribed to nothing.
+func endpointEvents(events []string) ([]string, error) {
+	if len(events) == 0 {
+		return []string{"All"}, nil
+	}
+
+	valid := filterValidEvents(events)
+	if len(valid) == 0 {
+		return nil, fmt.Errorf("no valid event types in %s", strings.Join(events, ","))
+	}
+	return valid, nil
+}
+
+func validateEndpointURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http(s) URL")
+	}
+	return nil
+}
+
+// validateEndpointHeaders rejects malformed headers and the ones the sender
+// sets itself.
+func validateEndpointHeaders(headers map[string]string) error {
+	for name, value := range headers {
+		canonical := http.CanonicalHeaderKey(name)
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") || strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("invalid header: %q", name)
+		}
+		switch canonical {
+		case "Content-Type", "Content-Length", "Host", "User-Agent",
+			webhook.HeaderSignature, webhook.HeaderTimestamp, webhook.HeaderDeliveryID:
+			return fmt.Errorf("header %s cannot be overridden", canonical)
+		}
+	}
+	return nil
+}
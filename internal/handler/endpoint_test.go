@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestEndpointEvents(t *testing.T) {
+	tests := []struct {
+		name    string
+		events  []string
+		want    []string
+		wantErr bool
+	}{
+		{"none subscribes to all", nil, []string{"All"}, false},
+		{"empty subscribes to all", []string{}, []string{"All"}, false},
+		{"valid", []string{"Message", "ReadReceipt"}, []string{"Message", "ReadReceipt"}, false},
+		{"unknown dropped", []string{"Message", "Nope"}, []string{"Message"}, false},
+		{"renamed", []string{"HistorySync"}, []string{"HistorySyncProgress"}, false},
+		{"only unknown", []string{"Nope", ""}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := endpointEvents(tt.events)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("endpointEvents() = %v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("endpointEvents() error = %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("endpointEvents() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
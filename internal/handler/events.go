@@ -0,0 +1,358 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+
+	"fiozap/internal/database/repository"
+	"fiozap/internal/logger"
+	"fiozap/internal/middleware"
+	"fiozap/internal/model"
+	"fiozap/internal/service"
+	"fiozap/internal/stream"
+	"fiozap/internal/webhook"
+)
+
+const (
+	streamBatchSize    = 100
+	streamPingInterval = 30 * time.Second
+	streamWriteTimeout = 10 * time.Second
+)
+
+type EventHandler struct {
+	webhookRepo    *repository.WebhookRepository
+	hub            *stream.Hub
+	sessionService *service.SessionService
+}
+
+func NewEventHandler(webhookRepo *repository.WebhookRepository, hub *stream.Hub, sessionService *service.SessionService) *EventHandler {
+	return &EventHandler{webhookRepo: webhookRepo, hub: hub, sessionService: sessionService}
+}
+
+// streamRequest is a parsed event stream request.
+type streamRequest struct {
+	userID     string
+	sessionID  string
+	eventTypes []string
+	lastID     int64
+	resume     bool
+}
+
+// SessionWebSocket godoc
+// @Summary Stream session events over WebSocket
+// @Description Stream the events of the session as JSON messages {id, event, sessionId, timestamp, data}, the same events sent to webhooks. Pass lastEventId to first receive the events missed since then. Browsers can pass the token as a query parameter
+// @Tags Events
+// @Param sessionId path string true "Session name"
+// @Param events query string false "Comma separated event types (default all)"
+// @Param lastEventId query int false "Resume after this event ID"
+// @Success 101
+// @Failure 400 {object} model.Response
+// @Security ApiKeyAuth
+// @Router /sessions/{sessionId}/events/ws [get]
+func (h *EventHandler) SessionWebSocket(w http.ResponseWriter, r *http.Request) {
+	session := middleware.GetSessionFromContext(r.Context())
+	if session == nil {
+		model.RespondUnauthorized(w, errors.New("session not found"))
+		return
+	}
+
+	req, err := parseStreamRequest(r)
+	if err != nil {
+		model.RespondBadRequest(w, err)
+		return
+	}
+	req.userID = session.UserID
+	req.sessionID = session.ID
+
+	h.serveWebSocket(w, r, req)
+}
+
+// UserWebSocket godoc
+// @Summary Stream events of all sessions over WebSocket
+// @Description Like the session stream, for the events of every session of the user
+// @Tags Events
+// @Param events query string false "Comma separated event types (default all)"
+// @Param lastEventId query int false "Resume after this event ID"
+// @Success 101
+// @Failure 400 {object} model.Response
+// @Security ApiKeyAuth
+// @Router /events/ws [get]
+func (h *EventHandler) UserWebSocket(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUserFromContext(r.Context())
+	if user == nil {
+		model.RespondUnauthorized(w, errors.New("user not found"))
+		return
+	}
+
+	req, err := parseStreamRequest(r)
+	if err != nil {
+		model.RespondBadRequest(w, err)
+		return
+	}
+	req.userID = user.ID
+
+	h.serveWebSocket(w, r, req)
+}
+
+func (h *EventHandler) serveWebSocket(w http.ResponseWriter, r *http.Request, req *streamRequest) {
+	// subscribe before reading the backlog so no event falls in between
+	sub := h.hub.Subscribe(req.userID, req.sessionID)
+	defer h.hub.Unsubscribe(sub)
+
+	if err := h.startPosition(req); err != nil {
+		model.RespondInternalError(w, err)
+		return
+	}
+
+	// the connection outlives the server's write timeout
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+
+	// like the rest of the API, access is granted by the token rather than
+	// the page origin
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{InsecureSkipVerify: true})
+	if err != nil {
+		logger.Warnf("Failed to accept event stream: %v", err)
+		return
+	}
+	defer conn.CloseNow()
+
+	// clients only send control frames
+	ctx := conn.CloseRead(r.Context())
+
+	err = h.follow(ctx, req, sub, func(event stream.Event) error {
+		writeCtx, cancel := context.WithTimeout(ctx, streamWriteTimeout)
+		defer cancel()
+		return wsjson.Write(writeCtx, conn, event)
+	}, func() error {
+		pingCtx, cancel := context.WithTimeout(ctx, streamWriteTimeout)
+		defer cancel()
+		return conn.Ping(pingCtx)
+	})
+
+	if err != nil && ctx.Err() == nil {
+		logger.Warnf("Event stream closed: %v", err)
+		conn.Close(websocket.StatusInternalError, "stream error")
+		return
+	}
+	conn.Close(websocket.StatusNormalClosure, "")
+}
+
+// startPosition makes new streams start at the end of the event log.
+// Resumed streams continue after the last event received.
+func (h *EventHandler) startPosition(req *streamRequest) error {
+	if req.resume {
+		return nil
+	}
+	var err error
+	req.lastID, err = h.webhookRepo.LastSeq()
+	return err
+}
+
+// follow sends the backlog after req.lastID and then new events as they are
+// signaled, until ctx is done or send fails. keepalive is called when the
+// stream has been idle for a while.
+func (h *EventHandler) follow(ctx context.Context, req *streamRequest, sub *stream.Subscription, send func(stream.Event) error, keepalive func() error) error {
+	ticker := time.NewTicker(streamPingInterval)
+	defer ticker.Stop()
+
+	filter := repository.EventFilter{
+		UserID:     req.userID,
+		SessionID:  req.sessionID,
+		EventTypes: req.eventTypes,
+		After:      req.lastID,
+		Limit:      streamBatchSize,
+	}
+
+	for {
+		events, err := h.webhookRepo.ListEvents(filter)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			if err := send(stream.NewEvent(event)); err != nil {
+				return err
+			}
+			filter.After = event.Seq
+		}
+
+		if len(events) == streamBatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-sub.C:
+		case <-ticker.C:
+			if err := keepalive(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// SessionSSE godoc
+// @Summary Stream session events with Server-Sent Events
+// @Description Stream the events of the session (QR, Connected, LoggedOut, Message, ...) as Server-Sent Events, for environments where WebSockets are blocked. Each event has its ID, type and the webhook payload as data. A first Status event without ID carries the current connection state and QR code. Reconnecting EventSource clients resume through the Last-Event-ID header
+// @Tags Events
+// @Produce text/event-stream
+// @Param sessionId path string true "Session name"
+// @Param events query string false "Comma separated event types (default all)"
+// @Param lastEventId query int false "Resume after this event ID"
+// @Success 200
+// @Failure 400 {object} model.Response
+// @Security ApiKeyAuth
+// @Router /sessions/{sessionId}/events/sse [get]
+func (h *EventHandler) SessionSSE(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUserFromContext(r.Context())
+	session := middleware.GetSessionFromContext(r.Context())
+	if user == nil || session == nil {
+		model.RespondUnauthorized(w, errors.New("session not found"))
+		return
+	}
+
+	req, err := parseStreamRequest(r)
+	if err != nil {
+		model.RespondBadRequest(w, err)
+		return
+	}
+	req.userID = session.UserID
+	req.sessionID = session.ID
+
+	status := h.sessionService.GetStatus(user.ID, session)
+	if qr, err := h.sessionService.GetQR(user.ID, session); err == nil && qr != "" {
+		status["qrCode"] = qr
+	}
+
+	h.serveSSE(w, r, req, status)
+}
+
+// UserSSE godoc
+// @Summary Stream events of all sessions with Server-Sent Events
+// @Description Like the session stream, for the events of every session of the user
+// @Tags Events
+// @Produce text/event-stream
+// @Param events query string false "Comma separated event types (default all)"
+// @Param lastEventId query int false "Resume after this event ID"
+// @Success 200
+// @Failure 400 {object} model.Response
+// @Security ApiKeyAuth
+// @Router /events/sse [get]
+func (h *EventHandler) UserSSE(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUserFromContext(r.Context())
+	if user == nil {
+		model.RespondUnauthorized(w, errors.New("user not found"))
+		return
+	}
+
+	req, err := parseStreamRequest(r)
+	if err != nil {
+		model.RespondBadRequest(w, err)
+		return
+	}
+	req.userID = user.ID
+
+	h.serveSSE(w, r, req, nil)
+}
+
+// serveSSE streams events in the text/event-stream format, preceded by a
+// Status event when status is set.
+func (h *EventHandler) serveSSE(w http.ResponseWriter, r *http.Request, req *streamRequest, status map[string]interface{}) {
+	sub := h.hub.Subscribe(req.userID, req.sessionID)
+	defer h.hub.Unsubscribe(sub)
+
+	if err := h.startPosition(req); err != nil {
+		model.RespondInternalError(w, err)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// keep reverse proxies such as nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	write := func(frame string) error {
+		rc.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if _, err := io.WriteString(w, frame); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	// ask EventSource to reconnect quickly after a drop
+	frame := "retry: 3000\n\n"
+	if status != nil {
+		data, _ := json.Marshal(status)
+		frame += "event: Status\ndata: " + string(data) + "\n\n"
+	}
+	if err := write(frame); err != nil {
+		return
+	}
+
+	err := h.follow(r.Context(), req, sub, func(event stream.Event) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		return write(fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Event, data))
+	}, func() error {
+		return write(": ping\n\n")
+	})
+
+	if err != nil && r.Context().Err() == nil {
+		logger.Warnf("Event stream closed: %v", err)
+	}
+}
+
+func parseStreamRequest(r *http.Request) (*streamRequest, error) {
+	query := r.URL.Query()
+	req := &streamRequest{}
+
+	if events := query.Get("events"); events != "" {
+		for _, event := range strings.Split(events, ",") {
+			event = webhook.CanonicalEvent(strings.TrimSpace(event))
+			if !isValidEvent(event) {
+				return nil, fmt.Errorf("invalid event type: %s", event)
+			}
+			if event == "All" {
+				req.eventTypes = nil
+				break
+			}
+			req.eventTypes = append(req.eventTypes, event)
+		}
+	}
+
+	// EventSource sends the header when reconnecting
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = query.Get("lastEventId")
+	}
+	if lastID != "" {
+		id, err := strconv.ParseInt(lastID, 10, 64)
+		if err != nil || id < 0 {
+			return nil, errors.New("lastEventId must be a non-negative integer")
+		}
+		req.lastID = id
+		req.resume = true
+	}
+
+	return req, nil
+}
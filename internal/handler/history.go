@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"fiozap/internal/middleware"
+	"fiozap/internal/model"
+	"fiozap/internal/service"
+)
+
+// ListChatMessages godoc
+// @Summary List chat messages
+// @Description List stored messages of a chat, newest first, using cursor pagination
+// @Tags Messages
+// @Produce json
+// @Param sessionId path string true "Session name"
+// @Param jid path string true "Chat JID or phone number"
+// @Param cursor query string false "Cursor returned as nextCursor by the previous page"
+// @Param limit query int false "Page size (default 50, max 200)"
+// @Param type query string false "Message type (text, image, video, audio, document, sticker, contact, location, reaction)"
+// @Param sender query string false "Sender JID or phone number"
+// @Param fromMe query bool false "Only messages sent (true) or received (false) by the session"
+// @Param since query string false "Start of date range (unix seconds or RFC3339)"
+// @Param until query string false "End of date range (unix seconds or RFC3339)"
+// @Success 200 {object} model.Response
+// @Failure 400 {object} model.Response
+// @Security ApiKeyAuth
+// @Router /sessions/{sessionId}/chats/{jid}/messages [get]
+func (h *MessageHandler) ListChatMessages(w http.ResponseWriter, r *http.Request) {
+	session := middleware.GetSessionFromContext(r.Context())
+	if session == nil {
+		model.RespondUnauthorized(w, errors.New("session not found"))
+		return
+	}
+
+	query := r.URL.Query()
+	q := service.MessageHistoryQuery{
+		Cursor:      query.Get("cursor"),
+		MessageType: query.Get("type"),
+		Sender:      query.Get("sender"),
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			model.RespondBadRequest(w, errors.New("limit must be a positive integer"))
+			return
+		}
+		q.Limit = n
+	}
+
+	if fromMe := query.Get("fromMe"); fromMe != "" {
+		b, err := strconv.ParseBool(fromMe)
+		if err != nil {
+			model.RespondBadRequest(w, errors.New("fromMe must be true or false"))
+			return
+		}
+		q.FromMe = &b
+	}
+
+	var err error
+	if q.Since, err = parseTimeParam(query.Get("since")); err != nil {
+		model.RespondBadRequest(w, fmt.Errorf("since: %w", err))
+		return
+	}
+	if q.Until, err = parseTimeParam(query.Get("until")); err != nil {
+		model.RespondBadRequest(w, fmt.Errorf("until: %w", err))
+		return
+	}
+
+	result, err := h.messageService.ListChatMessages(session.ID, mux.Vars(r)["jid"], &q)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidQuery) {
+			model.RespondBadRequest(w, err)
+			return
+		}
+		model.RespondInternalError(w, err)
+		return
+	}
+
+	model.RespondOK(w, result)
+}
+
+// GetMessage godoc
+// @Summary Get stored message
+// @Description Get a single stored message by its WhatsApp message ID. Edited messages include their revisions, starting with the original text
+// @Tags Messages
+// @Produce json
+// @Param sessionId path string true "Session name"
+// @Param messageId path string true "Message ID"
+// @Success 200 {object} model.Response
+// @Failure 404 {object} model.Response
+// @Security ApiKeyAuth
+// @Router /sessions/{sessionId}/messages/{messageId} [get]
+func (h *MessageHandler) GetMessage(w http.ResponseWriter, r *http.Request) {
+	session := middleware.GetSessionFromContext(r.Context())
+	if session == nil {
+		model.RespondUnauthorized(w, errors.New("session not found"))
+		return
+	}
+
+	msg, err := h.messageService.GetMessage(session.ID, mux.Vars(r)["messageId"])
+	if errors.Is(err, sql.ErrNoRows) {
+		model.RespondNotFound(w, errors.New("message not found"))
+		return
+	}
+	if err != nil {
+		model.RespondInternalError(w, err)
+		return
+	}
+
+	model.RespondOK(w, msg)
+}
+
+// GetPollResults godoc
+// @Summary Get poll results
+// @Description Tally the votes of a poll sent or received by the session, counting the latest vote of each voter. Polls and votes are stored, like messages, only while the session has storeMessages enabled
+// @Tags Messages
+// @Produce json
+// @Param sessionId path string true "Session name"
+// @Param messageId path string true "Poll message ID"
+// @Success 200 {object} model.Response
+// @Failure 404 {object} model.Response
+// @Security ApiKeyAuth
+// @Router /sessions/{sessionId}/polls/{messageId}/results [get]
+func (h *MessageHandler) GetPollResults(w http.ResponseWriter, r *http.Request) {
+	session := middleware.GetSessionFromContext(r.Context())
+	if session == nil {
+		model.RespondUnauthorized(w, errors.New("session not found"))
+		return
+	}
+
+	results, err := h.messageService.GetPollResults(session.ID, mux.Vars(r)["messageId"])
+	if errors.Is(err, sql.ErrNoRows) {
+		model.RespondNotFound(w, errors.New("poll not found"))
+		return
+	}
+	if err != nil {
+		model.RespondInternalError(w, err)
+		return
+	}
+
+	model.RespondOK(w, results)
+}
+
+// SearchMessages godoc
+// @Summary Search stored messages
+// @Description Full-text search over stored message text, ranked by relevance with highlighted snippets
+// @Tags Messages
+// @Produce json
+// @Param sessionId path string true "Session name"
+// @Param q query string true "Search terms (supports quoted phrases, OR and -exclusions)"
+// @Param chat query string false "Restrict to a chat JID or phone number"
+// @Param since query string false "Start of date range (unix seconds or RFC3339)"
+// @Param until query string false "End of date range (unix seconds or RFC3339)"
+// @Param limit query int false "Page size (default 50, max 200)"
+// @Param offset query int false "Number of results to skip"
+// @Success 200 {object} model.Response
+// @Failure 400 {object} model.Response
+// @Security ApiKeyAuth
+// @Router /sessions/{sessionId}/messages/search [get]
+func (h *MessageHandler) SearchMessages(w http.ResponseWriter, r *http.Request) {
+	session := middleware.GetSessionFromContext(r.Context())
+	if session == nil {
+		model.RespondUnauthorized(w, errors.New("session not found"))
+		return
+	}
+
+	query := r.URL.Query()
+	q := service.MessageSearchQuery{
+		Query: query.Get("q"),
+		Chat:  query.Get("chat"),
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			model.RespondBadRequest(w, errors.New("limit must be a positive integer"))
+			return
+		}
+		q.Limit = n
+	}
+
+	if offset := query.Get("offset"); offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			model.RespondBadRequest(w, errors.New("offset must be a non-negative integer"))
+			return
+		}
+		q.Offset = n
+	}
+
+	var err error
+	if q.Since, err = parseTimeParam(query.Get("since")); err != nil {
+		model.RespondBadRequest(w, fmt.Errorf("since: %w", err))
+		return
+	}
+	if q.Until, err = parseTimeParam(query.Get("until")); err != nil {
+		model.RespondBadRequest(w, fmt.Errorf("until: %w", err))
+		return
+	}
+
+	result, err := h.messageService.SearchMessages(session, &q)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidQuery) {
+			model.RespondBadRequest(w, err)
+			return
+		}
+		model.RespondInternalError(w, err)
+		return
+	}
+
+	model.RespondOK(w, result)
+}
+
+// parseTimeParam accepts unix seconds or RFC3339. An empty value yields nil.
+func parseTimeParam(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		t := time.Unix(secs, 0)
+		return &t, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, errors.New("expected unix seconds or RFC3339 time")
+	}
+	return &t, nil
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"fiozap/internal/middleware"
+	"fiozap/internal/model"
+	"fiozap/internal/service"
+)
+
+type MediaHandler struct {
+	mediaService *service.MediaService
+}
+
+func NewMediaHandler(mediaService *service.MediaService) *MediaHandler {
+	return &MediaHandler{mediaService: mediaService}
+}
+
+// Download godoc
+// @Summary Download media
+// @Description Download media received by the session. Supports Range requests
+// @Tags Media
+// @Produce octet-stream
+// @Param sessionId path string true "Session name"
+// @Param mediaId path string true "Media ID"
+// @Success 200 {file} file
+// @Success 206 {file} file
+// @Failure 404 {object} model.Response
+// @Security ApiKeyAuth
+// @Router /sessions/{sessionId}/media/{mediaId} [get]
+func (h *MediaHandler) Download(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUserFromContext(r.Context())
+	session := middleware.GetSessionFromContext(r.Context())
+	if user == nil || session == nil {
+		model.RespondUnauthorized(w, errors.New("user not found"))
+		return
+	}
+
+	h.serve(w, r, session.ID, mux.Vars(r)["mediaId"])
+}
+
+// DownloadSigned godoc
+// @Summary Download media with a signed link
+// @Description Download media through a signed, expiring link as sent in the mediaUrl of Message events. No API token is needed. Supports Range requests
+// @Tags Media
+// @Produce octet-stream
+// @Param sessionId path string true "Opaque session identifier from the link"
+// @Param mediaId path string true "Media ID"
+// @Param expires query int true "Expiry as unix timestamp"
+// @Param signature query string true "HMAC signature"
+// @Success 200 {file} file
+// @Success 206 {file} file
+// @Failure 403 {object} model.Response
+// @Failure 404 {object} model.Response
+// @Router /media/signed/{sessionId}/{mediaId} [get]
+func (h *MediaHandler) DownloadSigned(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+
+	err := h.mediaService.VerifySignature(vars["sessionId"], vars["mediaId"], query.Get("expires"), query.Get("signature"))
+	if err != nil {
+		model.RespondForbidden(w, err)
+		return
+	}
+
+	h.serve(w, r, vars["sessionId"], vars["mediaId"])
+}
+
+// GetURL godoc
+// @Summary Get signed media link
+// @Description Issue a signed, expiring download link that works without the API token, e.g. for browsers
+// @Tags Media
+// @Produce json
+// @Param sessionId path string true "Session name"
+// @Param mediaId path string true "Media ID"
+// @Success 200 {object} model.Response
+// @Failure 404 {object} model.Response
+// @Security ApiKeyAuth
+// @Router /sessions/{sessionId}/media/{mediaId}/url [get]
+func (h *MediaHandler) GetURL(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUserFromContext(r.Context())
+	session := middleware.GetSessionFromContext(r.Context())
+	if user == nil || session == nil {
+		model.RespondUnauthorized(w, errors.New("user not found"))
+		return
+	}
+
+	result, err := h.mediaService.SignedURL(session.ID, mux.Vars(r)["mediaId"])
+	if errors.Is(err, service.ErrMediaNotFound) {
+		model.RespondNotFound(w, err)
+		return
+	}
+	if err != nil {
+		model.RespondInternalError(w, err)
+		return
+	}
+
+	model.RespondOK(w, result)
+}
+
+func (h *MediaHandler) serve(w http.ResponseWriter, r *http.Request, sessionID, mediaID string) {
+	media, content, err := h.mediaService.Open(r.Context(), sessionID, mediaID)
+	if errors.Is(err, service.ErrMediaNotFound) {
+		model.RespondNotFound(w, err)
+		return
+	}
+	if err != nil {
+		model.RespondInternalError(w, err)
+		return
+	}
+	defer content.Close()
+
+	if media.MimeType != "" {
+		w.Header().Set("Content-Type", media.MimeType)
+	}
+	if media.FileName != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": media.FileName}))
+	}
+	if media.SHA256 != "" {
+		w.Header().Set("ETag", fmt.Sprintf("%q", media.SHA256))
+	}
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+
+	// ServeContent takes care of Range, If-Range and conditional requests
+	http.ServeContent(w, r, media.FileName, media.CreatedAt, content)
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"strconv"
 
+	"fiozap/internal/media"
 	"fiozap/internal/middleware"
 	"fiozap/internal/model"
 	"fiozap/internal/service"
@@ -20,7 +22,7 @@ func NewMessageHandler(messageService *service.MessageService) *MessageHandler {
 
 // SendText godoc
 // @Summary Send text message
-// @Description Send a text message to a phone number
+// @Description Send a text message to a phone number. Set quotedMessageId to reply to a message and mentions to mention participants; without mentions, @number tokens in the message are mentioned. linkPreview attaches the OpenGraph preview of the first link
 // @Tags Messages
 // @Accept json
 // @Produce json
@@ -55,6 +57,10 @@ func (h *MessageHandler) SendText(w http.ResponseWriter, r *http.Request) {
 
 	result, err := h.messageService.SendText(r.Context(), user.ID, session.ID, &req)
 	if err != nil {
+		if errors.Is(err, service.ErrInvalidMessage) {
+			model.RespondBadRequest(w, err)
+			return
+		}
 		model.RespondInternalError(w, err)
 		return
 	}
@@ -64,13 +70,16 @@ func (h *MessageHandler) SendText(w http.ResponseWriter, r *http.Request) {
 
 // SendImage godoc
 // @Summary Send image
-// @Description Send an image to a phone number
+// @Description Send an image (base64 data URL or http(s) URL) to a phone number. Multipart uploads put the file in an image or file part after the other fields
 // @Tags Messages
 // @Accept json
+// @Accept mpfd
 // @Produce json
 // @Param message body model.ImageMessage true "Image data"
 // @Success 200 {object} model.Response
 // @Failure 400 {object} model.Response
+// @Failure 413 {object} model.Response
+// @Failure 415 {object} model.Response
 // @Security ApiKeyAuth
 // @Router /chat/send/image [post]
 func (h *MessageHandler) SendImage(w http.ResponseWriter, r *http.Request) {
@@ -82,7 +91,21 @@ func (h *MessageHandler) SendImage(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req model.ImageMessage
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	var file *media.Source
+	if isMultipart(r) {
+		upload, err := readMediaUpload(w, r, "image", h.messageService.UploadLimit(user))
+		if err != nil {
+			respondUploadError(w, err)
+			return
+		}
+		req = model.ImageMessage{
+			Phone:    upload.get("phone"),
+			Caption:  upload.get("caption"),
+			ID:       upload.get("id"),
+			MimeType: upload.get("mimetype"),
+		}
+		file = upload.source
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		model.RespondBadRequest(w, errors.New("invalid payload"))
 		return
 	}
@@ -92,14 +115,14 @@ func (h *MessageHandler) SendImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Image == "" {
+	if req.Image == "" && file == nil {
 		model.RespondBadRequest(w, errors.New("image is required"))
 		return
 	}
 
-	result, err := h.messageService.SendImage(r.Context(), user.ID, session.ID, &req)
+	result, err := h.messageService.SendImage(r.Context(), user.ID, session.ID, &req, file)
 	if err != nil {
-		model.RespondInternalError(w, err)
+		respondSendError(w, err)
 		return
 	}
 
@@ -108,13 +131,16 @@ func (h *MessageHandler) SendImage(w http.ResponseWriter, r *http.Request) {
 
 // SendAudio godoc
 // @Summary Send audio
-// @Description Send an audio file to a phone number
+// @Description Send an audio file (base64 data URL or http(s) URL) to a phone number. Multipart uploads put the file in an audio or file part after the other fields
 // @Tags Messages
 // @Accept json
+// @Accept mpfd
 // @Produce json
 // @Param message body model.AudioMessage true "Audio data"
 // @Success 200 {object} model.Response
 // @Failure 400 {object} model.Response
+// @Failure 413 {object} model.Response
+// @Failure 415 {object} model.Response
 // @Security ApiKeyAuth
 // @Router /chat/send/audio [post]
 func (h *MessageHandler) SendAudio(w http.ResponseWriter, r *http.Request) {
@@ -126,7 +152,28 @@ func (h *MessageHandler) SendAudio(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req model.AudioMessage
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	var file *media.Source
+	if isMultipart(r) {
+		upload, err := readMediaUpload(w, r, "audio", h.messageService.UploadLimit(user))
+		if err != nil {
+			respondUploadError(w, err)
+			return
+		}
+		req = model.AudioMessage{
+			Phone:    upload.get("phone"),
+			ID:       upload.get("id"),
+			MimeType: upload.get("mimetype"),
+		}
+		if value := upload.get("ptt"); value != "" {
+			ptt, err := strconv.ParseBool(value)
+			if err != nil {
+				model.RespondBadRequest(w, errors.New("invalid ptt value"))
+				return
+			}
+			req.PTT = &ptt
+		}
+		file = upload.source
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		model.RespondBadRequest(w, errors.New("invalid payload"))
 		return
 	}
@@ -136,14 +183,14 @@ func (h *MessageHandler) SendAudio(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Audio == "" {
+	if req.Audio == "" && file == nil {
 		model.RespondBadRequest(w, errors.New("audio is required"))
 		return
 	}
 
-	result, err := h.messageService.SendAudio(r.Context(), user.ID, session.ID, &req)
+	result, err := h.messageService.SendAudio(r.Context(), user.ID, session.ID, &req, file)
 	if err != nil {
-		model.RespondInternalError(w, err)
+		respondSendError(w, err)
 		return
 	}
 
@@ -152,13 +199,16 @@ func (h *MessageHandler) SendAudio(w http.ResponseWriter, r *http.Request) {
 
 // SendVideo godoc
 // @Summary Send video
-// @Description Send a video to a phone number
+// @Description Send a video (base64 data URL or http(s) URL) to a phone number. Multipart uploads put the file in a video or file part after the other fields
 // @Tags Messages
 // @Accept json
+// @Accept mpfd
 // @Produce json
 // @Param message body model.VideoMessage true "Video data"
 // @Success 200 {object} model.Response
 // @Failure 400 {object} model.Response
+// @Failure 413 {object} model.Response
+// @Failure 415 {object} model.Response
 // @Security ApiKeyAuth
 // @Router /chat/send/video [post]
 func (h *MessageHandler) SendVideo(w http.ResponseWriter, r *http.Request) {
@@ -170,7 +220,21 @@ func (h *MessageHandler) SendVideo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req model.VideoMessage
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	var file *media.Source
+	if isMultipart(r) {
+		upload, err := readMediaUpload(w, r, "video", h.messageService.UploadLimit(user))
+		if err != nil {
+			respondUploadError(w, err)
+			return
+		}
+		req = model.VideoMessage{
+			Phone:    upload.get("phone"),
+			Caption:  upload.get("caption"),
+			ID:       upload.get("id"),
+			MimeType: upload.get("mimetype"),
+		}
+		file = upload.source
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		model.RespondBadRequest(w, errors.New("invalid payload"))
 		return
 	}
@@ -180,14 +244,14 @@ func (h *MessageHandler) SendVideo(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Video == "" {
+	if req.Video == "" && file == nil {
 		model.RespondBadRequest(w, errors.New("video is required"))
 		return
 	}
 
-	result, err := h.messageService.SendVideo(r.Context(), user.ID, session.ID, &req)
+	result, err := h.messageService.SendVideo(r.Context(), user.ID, session.ID, &req, file)
 	if err != nil {
-		model.RespondInternalError(w, err)
+		respondSendError(w, err)
 		return
 	}
 
@@ -196,13 +260,16 @@ func (h *MessageHandler) SendVideo(w http.ResponseWriter, r *http.Request) {
 
 // SendDocument godoc
 // @Summary Send document
-// @Description Send a document to a phone number
+// @Description Send a document (base64 data URL or http(s) URL) to a phone number. Multipart uploads put the file in a document or file part after the other fields
 // @Tags Messages
 // @Accept json
+// @Accept mpfd
 // @Produce json
 // @Param message body model.DocumentMessage true "Document data"
 // @Success 200 {object} model.Response
 // @Failure 400 {object} model.Response
+// @Failure 413 {object} model.Response
+// @Failure 415 {object} model.Response
 // @Security ApiKeyAuth
 // @Router /chat/send/document [post]
 func (h *MessageHandler) SendDocument(w http.ResponseWriter, r *http.Request) {
@@ -214,7 +281,25 @@ func (h *MessageHandler) SendDocument(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req model.DocumentMessage
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	var file *media.Source
+	if isMultipart(r) {
+		upload, err := readMediaUpload(w, r, "document", h.messageService.UploadLimit(user))
+		if err != nil {
+			respondUploadError(w, err)
+			return
+		}
+		req = model.DocumentMessage{
+			Phone:    upload.get("phone"),
+			FileName: upload.get("filename"),
+			Caption:  upload.get("caption"),
+			ID:       upload.get("id"),
+			MimeType: upload.get("mimetype"),
+		}
+		if req.FileName == "" {
+			req.FileName = upload.fileName
+		}
+		file = upload.source
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		model.RespondBadRequest(w, errors.New("invalid payload"))
 		return
 	}
@@ -224,7 +309,7 @@ func (h *MessageHandler) SendDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Document == "" {
+	if req.Document == "" && file == nil {
 		model.RespondBadRequest(w, errors.New("document is required"))
 		return
 	}
@@ -234,9 +319,9 @@ func (h *MessageHandler) SendDocument(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result, err := h.messageService.SendDocument(r.Context(), user.ID, session.ID, &req)
+	result, err := h.messageService.SendDocument(r.Context(), user.ID, session.ID, &req, file)
 	if err != nil {
-		model.RespondInternalError(w, err)
+		respondSendError(w, err)
 		return
 	}
 
@@ -321,6 +406,55 @@ func (h *MessageHandler) SendContact(w http.ResponseWriter, r *http.Request) {
 	model.RespondOK(w, result)
 }
 
+// SendPoll godoc
+// @Summary Send poll
+// @Description Send a poll with 2 to 12 options. selectableCount limits how many options a voter may pick, 0 (default) for any number. Votes are tallied by the poll results endpoint and sent as PollVote events
+// @Tags Messages
+// @Accept json
+// @Produce json
+// @Param sessionId path string true "Session name"
+// @Param message body model.PollMessage true "Poll data"
+// @Success 200 {object} model.Response
+// @Failure 400 {object} model.Response
+// @Security ApiKeyAuth
+// @Router /sessions/{sessionId}/messages/poll [post]
+func (h *MessageHandler) SendPoll(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUserFromContext(r.Context())
+	session := middleware.GetSessionFromContext(r.Context())
+	if user == nil || session == nil {
+		model.RespondUnauthorized(w, errors.New("user not found"))
+		return
+	}
+
+	var req model.PollMessage
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		model.RespondBadRequest(w, errors.New("invalid payload"))
+		return
+	}
+
+	if req.Phone == "" {
+		model.RespondBadRequest(w, errors.New("phone is required"))
+		return
+	}
+
+	if req.Question == "" {
+		model.RespondBadRequest(w, errors.New("question is required"))
+		return
+	}
+
+	result, err := h.messageService.SendPoll(r.Context(), user.ID, session.ID, &req)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidMessage) {
+			model.RespondBadRequest(w, err)
+			return
+		}
+		model.RespondInternalError(w, err)
+		return
+	}
+
+	model.RespondOK(w, result)
+}
+
 // React godoc
 // @Summary React to message
 // @Description Send a reaction to a message
@@ -408,3 +542,57 @@ func (h *MessageHandler) Delete(w http.ResponseWriter, r *http.Request) {
 
 	model.RespondOK(w, result)
 }
+
+// Edit godoc
+// @Summary Edit message
+// @Description Replace the text of a sent text message, or the caption of a sent image, video or document. Pass either text or caption; captions can only be edited on stored messages. The new text is kept as a revision of the stored message
+// @Tags Messages
+// @Accept json
+// @Produce json
+// @Param sessionId path string true "Session name"
+// @Param message body model.EditMessage true "Edit data"
+// @Success 200 {object} model.Response
+// @Failure 400 {object} model.Response
+// @Security ApiKeyAuth
+// @Router /sessions/{sessionId}/messages/edit [post]
+func (h *MessageHandler) Edit(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUserFromContext(r.Context())
+	session := middleware.GetSessionFromContext(r.Context())
+	if user == nil || session == nil {
+		model.RespondUnauthorized(w, errors.New("user not found"))
+		return
+	}
+
+	var req model.EditMessage
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		model.RespondBadRequest(w, errors.New("invalid payload"))
+		return
+	}
+
+	if req.Phone == "" {
+		model.RespondBadRequest(w, errors.New("phone is required"))
+		return
+	}
+
+	if req.MessageID == "" {
+		model.RespondBadRequest(w, errors.New("message_id is required"))
+		return
+	}
+
+	if (req.Text == "") == (req.Caption == "") {
+		model.RespondBadRequest(w, errors.New("either text or caption is required"))
+		return
+	}
+
+	result, err := h.messageService.Edit(r.Context(), user.ID, session.ID, &req)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidMessage) {
+			model.RespondBadRequest(w, err)
+			return
+		}
+		model.RespondInternalError(w, err)
+		return
+	}
+
+	model.RespondOK(w, result)
+}
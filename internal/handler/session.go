@@ -75,6 +75,26 @@ func (h *SessionHandler) CreateSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := service.ValidateProxyURL(req.ProxyURL); err != nil {
+		model.RespondBadRequest(w, err)
+		return
+	}
+
+	if err := h.sessionService.ValidateSearchLanguage(req.SearchLanguage); err != nil {
+		model.RespondBadRequest(w, err)
+		return
+	}
+
+	if req.HistoryDays < 0 || req.HistoryMessages < 0 {
+		model.RespondBadRequest(w, errors.New("historyDays and historyMessages must not be negative"))
+		return
+	}
+
+	if req.WebhookMaxAttempts < 0 || (req.WebhookMaxAge != nil && *req.WebhookMaxAge < 0) {
+		model.RespondBadRequest(w, errors.New("webhookMaxAttempts and webhookMaxAge must not be negative"))
+		return
+	}
+
 	session, err := h.sessionService.CreateSession(user.ID, &req)
 	if err != nil {
 		model.RespondInternalError(w, err)
@@ -131,6 +151,35 @@ func (h *SessionHandler) UpdateSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.ProxyURL != nil {
+		if err := service.ValidateProxyURL(*req.ProxyURL); err != nil {
+			model.RespondBadRequest(w, err)
+			return
+		}
+	}
+
+	if req.SearchLanguage != nil {
+		if err := h.sessionService.ValidateSearchLanguage(*req.SearchLanguage); err != nil {
+			model.RespondBadRequest(w, err)
+			return
+		}
+	}
+
+	if (req.HistoryDays != nil && *req.HistoryDays < 0) || (req.HistoryMessages != nil && *req.HistoryMessages < 0) {
+		model.RespondBadRequest(w, errors.New("historyDays and historyMessages must not be negative"))
+		return
+	}
+
+	if req.WebhookMaxAttempts != nil && *req.WebhookMaxAttempts < 1 {
+		model.RespondBadRequest(w, errors.New("webhookMaxAttempts must be at least 1"))
+		return
+	}
+
+	if req.WebhookMaxAge != nil && *req.WebhookMaxAge < 0 {
+		model.RespondBadRequest(w, errors.New("webhookMaxAge must not be negative"))
+		return
+	}
+
 	updated, err := h.sessionService.UpdateSession(session.ID, &req)
 	if err != nil {
 		model.RespondInternalError(w, err)
@@ -159,7 +208,7 @@ func (h *SessionHandler) DeleteSession(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.sessionService.DeleteSession(user.ID, session.ID); err != nil {
+	if err := h.sessionService.DeleteSession(r.Context(), user.ID, session.ID); err != nil {
 		model.RespondInternalError(w, err)
 		return
 	}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"fiozap/internal/media"
+	"fiozap/internal/model"
+)
+
+// maxFormFieldSize caps the text fields sent next to the file.
+const maxFormFieldSize = 64 << 10
+
+// mediaUpload is a multipart media request. The file part is streamed, so
+// the text fields (phone, caption, ...) must come before it in the form.
+type mediaUpload struct {
+	fields   map[string]string
+	fileName string
+	source   *media.Source
+}
+
+func (u *mediaUpload) get(name string) string {
+	return u.fields[name]
+}
+
+func isMultipart(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// readMediaUpload reads the form fields up to the file part, which must be
+// named after the media kind or "file". The returned source reads straight
+// from the request body and fails with media.ErrTooLarge past the limit.
+func readMediaUpload(w http.ResponseWriter, r *http.Request, kind string, limit int64) (*mediaUpload, error) {
+	if limit > 0 {
+		// leave room for the other fields and the multipart framing
+		r.Body = http.MaxBytesReader(w, r.Body, limit+1<<20)
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, errors.New("invalid multipart form")
+	}
+
+	upload := &mediaUpload{fields: make(map[string]string)}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s file is required", kind)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := part.FormName()
+		if part.FileName() == "" || (name != kind && name != "file") {
+			value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize+1))
+			if err != nil {
+				return nil, err
+			}
+			if len(value) > maxFormFieldSize {
+				return nil, fmt.Errorf("form field %s is too large", name)
+			}
+			upload.fields[name] = string(value)
+			continue
+		}
+
+		src, err := media.NewUpload(part, part.Header.Get("Content-Type"), limit)
+		if err != nil {
+			return nil, err
+		}
+		if err := media.ValidateMimeType(kind, src.MimeType); err != nil {
+			return nil, err
+		}
+
+		upload.fileName = part.FileName()
+		upload.source = src
+		return upload, nil
+	}
+}
+
+// respondUploadError reports a form that could not be read.
+func respondUploadError(w http.ResponseWriter, err error) {
+	if code := mediaErrorStatus(err); code != 0 {
+		model.RespondError(w, code, err)
+		return
+	}
+	model.RespondBadRequest(w, err)
+}
+
+// respondSendError is used after sending, since an upload is only read in
+// full once it is streamed to WhatsApp.
+func respondSendError(w http.ResponseWriter, err error) {
+	if code := mediaErrorStatus(err); code != 0 {
+		model.RespondError(w, code, err)
+		return
+	}
+	model.RespondInternalError(w, err)
+}
+
+func mediaErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, media.ErrTooLarge), errors.As(err, &maxBytesErr):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, media.ErrUnsupportedType):
+		return http.StatusUnsupportedMediaType
+	}
+	return 0
+}
@@ -3,18 +3,24 @@ package handler
 import (
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"fiozap/internal/database/repository"
 	"fiozap/internal/middleware"
 	"fiozap/internal/model"
+	"fiozap/internal/webhook"
 )
 
 var supportedEventTypes = []string{
 	"Message",
+	"MessageEdited",
+	"PollVote",
+	"MediaStored",
 	"ReadReceipt",
-	"HistorySync",
+	"HistorySyncProgress",
 	"ChatPresence",
 	"Presence",
 	"Connected",
@@ -27,12 +33,19 @@ var supportedEventTypes = []string{
 	"All",
 }
 
+const (
+	defaultSecretGracePeriod = 24 * time.Hour
+	maxSecretGracePeriod     = 7 * 24 * time.Hour
+)
+
 type WebhookHandler struct {
-	sessionRepo *repository.SessionRepository
+	sessionRepo  *repository.SessionRepository
+	webhookRepo  *repository.WebhookRepository
+	endpointRepo *repository.WebhookEndpointRepository
 }
 
-func NewWebhookHandler(sessionRepo *repository.SessionRepository) *WebhookHandler {
-	return &WebhookHandler{sessionRepo: sessionRepo}
+func NewWebhookHandler(sessionRepo *repository.SessionRepository, webhookRepo *repository.WebhookRepository, endpointRepo *repository.WebhookEndpointRepository) *WebhookHandler {
+	return &WebhookHandler{sessionRepo: sessionRepo, webhookRepo: webhookRepo, endpointRepo: endpointRepo}
 }
 
 // Get godoc
@@ -58,8 +71,12 @@ func (h *WebhookHandler) Get(w http.ResponseWriter, r *http.Request) {
 	}
 
 	model.RespondOK(w, map[string]interface{}{
-		"webhook":   session.Webhook,
-		"subscribe": events,
+		"webhook":     session.Webhook,
+		"subscribe":   events,
+		"secret":      session.WebhookSecret,
+		"maxAttempts": session.WebhookMaxAttempts,
+		"maxAge":      session.WebhookMaxAge,
+		"redisStream": session.RedisStream,
 	})
 }
 
@@ -88,13 +105,7 @@ func (h *WebhookHandler) Set(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var validEvents []string
-	for _, event := range req.Events {
-		if isValidEvent(event) {
-			validEvents = append(validEvents, event)
-		}
-	}
-
+	validEvents := filterValidEvents(req.Events)
 	eventString := strings.Join(validEvents, ",")
 
 	if err := h.sessionRepo.UpdateWebhook(session.ID, req.WebhookURL, eventString); err != nil {
@@ -141,13 +152,7 @@ func (h *WebhookHandler) Update(w http.ResponseWriter, r *http.Request) {
 	var eventString string
 
 	if req.Active {
-		var validEvents []string
-		for _, event := range req.Events {
-			if isValidEvent(event) {
-				validEvents = append(validEvents, event)
-			}
-		}
-		eventString = strings.Join(validEvents, ",")
+		eventString = strings.Join(filterValidEvents(req.Events), ",")
 	} else {
 		webhook = ""
 		eventString = ""
@@ -192,6 +197,61 @@ func (h *WebhookHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// RotateSecret godoc
+// @Summary Rotate webhook secret
+// @Description Generate a new webhook signing secret. During the grace period deliveries carry signatures for both the new and the previous secret
+// @Tags Webhook
+// @Accept json
+// @Produce json
+// @Param sessionId path string true "Session name"
+// @Param request body object{gracePeriod=int} false "Grace period in seconds (default 86400, max 604800)"
+// @Success 200 {object} model.Response
+// @Failure 400 {object} model.Response
+// @Security ApiKeyAuth
+// @Router /sessions/{sessionId}/webhook/secret/rotate [post]
+func (h *WebhookHandler) RotateSecret(w http.ResponseWriter, r *http.Request) {
+	session := middleware.GetSessionFromContext(r.Context())
+	if session == nil {
+		model.RespondUnauthorized(w, errors.New("session not found"))
+		return
+	}
+
+	gracePeriod, err := readGracePeriod(r)
+	if err != nil {
+		model.RespondBadRequest(w, err)
+		return
+	}
+
+	updated, err := h.sessionRepo.RotateWebhookSecret(session.ID, gracePeriod)
+	if err != nil {
+		model.RespondInternalError(w, err)
+		return
+	}
+
+	model.RespondOK(w, map[string]interface{}{
+		"secret":                  updated.WebhookSecret,
+		"previousSecretExpiresAt": updated.WebhookSecretPreviousExpiresAt,
+	})
+}
+
+// readGracePeriod reads the optional {gracePeriod} body of a secret rotation.
+func readGracePeriod(r *http.Request) (time.Duration, error) {
+	req := struct {
+		GracePeriod *int `json:"gracePeriod"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		return 0, errors.New("invalid payload")
+	}
+
+	if req.GracePeriod == nil {
+		return defaultSecretGracePeriod, nil
+	}
+	if *req.GracePeriod < 0 || time.Duration(*req.GracePeriod)*time.Second > maxSecretGracePeriod {
+		return 0, errors.New("gracePeriod must be between 0 and 604800 seconds")
+	}
+	return time.Duration(*req.GracePeriod) * time.Second, nil
+}
+
 func isValidEvent(event string) bool {
 	for _, e := range supportedEventTypes {
 		if e == event {
@@ -200,3 +260,16 @@ func isValidEvent(event string) bool {
 	}
 	return false
 }
+
+// filterValidEvents drops unknown event types and stores renamed ones under
+// their current name.
+func filterValidEvents(events []string) []string {
+	valid := []string{}
+	for _, event := range events {
+		event = webhook.CanonicalEvent(event)
+		if isValidEvent(event) {
+			valid = append(valid, event)
+		}
+	}
+	return valid
+}
@@ -0,0 +1,303 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vincent-petithory/dataurl"
+)
+
+const maxRedirects = 5
+
+var ErrTooLarge = errors.New("media exceeds the maximum allowed size")
+
+type FetcherConfig struct {
+	MaxSize              int64
+	Timeout              time.Duration
+	Allowlist            []string
+	Denylist             []string
+	AllowPrivateNetworks bool
+}
+
+// Fetcher opens media given as data URLs or remote http(s) URLs. Remote
+// downloads are streamed, size limited and restricted to public addresses
+// unless configured otherwise, so callers can't use fiozap to reach
+// internal services.
+type Fetcher struct {
+	client    *http.Client
+	dialer    *net.Dialer
+	maxSize   int64
+	allowlist hostRules
+	denylist  hostRules
+	private   bool
+}
+
+func NewFetcher(cfg FetcherConfig) *Fetcher {
+	f := &Fetcher{
+		dialer: &net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		},
+		maxSize:   cfg.MaxSize,
+		allowlist: parseHostRules(cfg.Allowlist),
+		denylist:  parseHostRules(cfg.Denylist),
+		private:   cfg.AllowPrivateNetworks,
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = f.dialContext
+
+	f.client = &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("too many redirects")
+			}
+			return f.checkURL(req.URL)
+		},
+	}
+
+	return f
+}
+
+// Open returns the media for kind ("image", "audio", "video" or "document")
+// from a data URL or an http(s) URL. The caller must close the returned source.
+func (f *Fetcher) Open(ctx context.Context, value, kind string) (*Source, error) {
+	switch {
+	case strings.HasPrefix(value, "data:"):
+		return f.openDataURL(value, kind)
+	case strings.HasPrefix(value, "http://"), strings.HasPrefix(value, "https://"):
+		return f.openURL(ctx, value)
+	default:
+		if kind == "document" {
+			return nil, errors.New("document must be base64 encoded or an http(s) URL")
+		}
+		return nil, fmt.Errorf("%s must be base64 encoded (data:%s/...) or an http(s) URL", kind, kind)
+	}
+}
+
+func (f *Fetcher) openDataURL(value, kind string) (*Source, error) {
+	if kind != "document" && !strings.HasPrefix(value, "data:"+kind) {
+		return nil, fmt.Errorf("%s must be base64 encoded (data:%s/...) or an http(s) URL", kind, kind)
+	}
+
+	decoded, err := dataurl.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 %s data", kind)
+	}
+
+	if f.maxSize > 0 && int64(len(decoded.Data)) > f.maxSize {
+		return nil, ErrTooLarge
+	}
+
+	return newBytesSource(decoded.Data, "")
+}
+
+func (f *Fetcher) openURL(ctx context.Context, rawURL string) (*Source, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.New("invalid media URL")
+	}
+
+	if err := f.checkURL(parsed); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, parsed.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "FioZap-Media/1.0")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download media: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to download media: server returned status %d", resp.StatusCode)
+	}
+
+	if f.maxSize > 0 && resp.ContentLength > f.maxSize {
+		resp.Body.Close()
+		return nil, ErrTooLarge
+	}
+
+	declaredType := ""
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		if mediaType, _, err := mime.ParseMediaType(ct); err == nil {
+			declaredType = mediaType
+		}
+	}
+
+	var body io.Reader = resp.Body
+	if f.maxSize > 0 {
+		body = &limitedReader{r: resp.Body, remaining: f.maxSize}
+	}
+
+	return newSource(body, declaredType, resp.Body)
+}
+
+func (f *Fetcher) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported media URL scheme %q", u.Scheme)
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return errors.New("media URL must include a host")
+	}
+
+	if f.denylist.matchHost(host) {
+		return fmt.Errorf("media host %q is not allowed", host)
+	}
+
+	return nil
+}
+
+// dialContext resolves the host itself and only connects to addresses that
+// pass checkIP, so hostnames that resolve (or rebind) to internal addresses
+// are caught too.
+func (f *Fetcher) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	hostAllowed := f.allowlist.matchHost(host)
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	lastErr := fmt.Errorf("no addresses found for %q", host)
+	for _, ip := range ips {
+		if err := f.checkIP(ip.IP, hostAllowed); err != nil {
+			lastErr = err
+			continue
+		}
+
+		conn, err := f.dialer.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// checkIP applies the denylist, then the allowlist, then the private network
+// guard. Allowlisted hosts and ranges may point at private networks.
+func (f *Fetcher) checkIP(ip net.IP, hostAllowed bool) error {
+	if f.denylist.matchIP(ip) {
+		return fmt.Errorf("media address %s is not allowed", ip)
+	}
+
+	allowed := hostAllowed || f.allowlist.matchIP(ip)
+	if !f.allowlist.empty() && !allowed {
+		return fmt.Errorf("media address %s is not in the allowlist", ip)
+	}
+
+	if !allowed && !f.private && isPrivateIP(ip) {
+		return fmt.Errorf("media address %s is in a private network", ip)
+	}
+
+	return nil
+}
+
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// hostRules holds domain names (matching the domain and its subdomains) and
+// CIDR ranges.
+type hostRules struct {
+	domains  []string
+	networks []*net.IPNet
+}
+
+func parseHostRules(entries []string) hostRules {
+	var rules hostRules
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			rules.networks = append(rules.networks, network)
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			rules.networks = append(rules.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		rules.domains = append(rules.domains, strings.TrimPrefix(entry, "*."))
+	}
+	return rules
+}
+
+func (r hostRules) empty() bool {
+	return len(r.domains) == 0 && len(r.networks) == 0
+}
+
+func (r hostRules) matchHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, domain := range r.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return r.matchIP(ip)
+	}
+	return false
+}
+
+func (r hostRules) matchIP(ip net.IP) bool {
+	for _, network := range r.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitedReader fails instead of silently truncating once the limit is hit,
+// so an oversized download aborts the upload.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, ErrTooLarge
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
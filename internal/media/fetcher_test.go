@@ -0,0 +1,45 @@
+package media
+
+import (
+	"net"
+	"testing"
+)
+
+func TestHostRules(t *testing.T) {
+	rules := parseHostRules([]string{" Example.com ", "*.cdn.example.net", "10.0.0.0/8", "192.0.2.7", "2001:db8::1", ""})
+
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"example.com", true},
+		{"EXAMPLE.COM.", true},
+		{"media.example.com", true},
+		{"badexample.com", false},
+		{"example.com.evil.org", false},
+		{"img.cdn.example.net", true},
+		{"cdn.example.net", true},
+		{"10.1.2.3", true},
+		{"11.1.2.3", false},
+		{"192.0.2.7", true},
+		{"192.0.2.8", false},
+		{"2001:db8::1", true},
+		{"2001:db8::2", false},
+	}
+
+	for _, tt := range tests {
+		if got := rules.matchHost(tt.host); got != tt.want {
+			t.Errorf("matchHost(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+
+	if !rules.matchIP(net.ParseIP("10.255.255.255")) {
+		t.Error("matchIP(10.255.255.255) = false, want true")
+	}
+	if rules.empty() {
+		t.Error("empty() = true, want false")
+	}
+	if !parseHostRules([]string{"", "  "}).empty() {
+		t.Error("empty() = false for blank entries, want true")
+	}
+}
@@ -0,0 +1,279 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/patrickmn/go-cache"
+	"golang.org/x/image/draw"
+	"golang.org/x/net/html"
+
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	previewTimeout      = 10 * time.Second
+	maxPreviewPageSize  = 1 << 20
+	maxPreviewImageSize = 5 << 20
+	// a small compressed file can still decode to a huge bitmap
+	maxPreviewImagePixels = 25_000_000
+	// thumbnails are sent inline, images are uploaded for the large preview
+	previewThumbnailSize = 160
+	previewImageSize     = 640
+)
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"]+`)
+
+// LinkPreview is the OpenGraph data of the first link of a message. Thumbnail
+// and Image are JPEG encoded, and nil when the page has no usable image.
+type LinkPreview struct {
+	MatchedText string
+	Title       string
+	Description string
+	Thumbnail   []byte
+	Image       []byte
+	ImageWidth  uint32
+	ImageHeight uint32
+}
+
+// LinkPreviewer builds link previews, fetching pages and images through the
+// Fetcher so the same host restrictions apply. Previews are cached by URL.
+type LinkPreviewer struct {
+	fetcher *Fetcher
+	cache   *cache.Cache
+}
+
+func NewLinkPreviewer(fetcher *Fetcher, ttl time.Duration) *LinkPreviewer {
+	return &LinkPreviewer{
+		fetcher: fetcher,
+		cache:   cache.New(ttl, 2*ttl),
+	}
+}
+
+// Preview returns the preview of the first http(s) URL in text, or nil when
+// there is none or the page has no title.
+func (p *LinkPreviewer) Preview(ctx context.Context, text string) (*LinkPreview, error) {
+	matched := FindURL(text)
+	if matched == "" {
+		return nil, nil
+	}
+
+	if cached, ok := p.cache.Get(matched); ok {
+		return cached.(*LinkPreview), nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, previewTimeout)
+	defer cancel()
+
+	preview, err := p.fetch(ctx, matched)
+	if err != nil {
+		return nil, err
+	}
+
+	p.cache.SetDefault(matched, preview)
+	return preview, nil
+}
+
+func (p *LinkPreviewer) fetch(ctx context.Context, rawURL string) (*LinkPreview, error) {
+	page, err := p.fetcher.openURL(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer page.Close()
+
+	if !strings.HasPrefix(page.MimeType, "text/html") && !strings.HasPrefix(page.MimeType, "application/xhtml") {
+		return nil, nil
+	}
+
+	meta := parseOpenGraph(io.LimitReader(page, maxPreviewPageSize))
+	if meta.title == "" {
+		return nil, nil
+	}
+
+	preview := &LinkPreview{
+		MatchedText: rawURL,
+		Title:       meta.title,
+		Description: meta.description,
+	}
+
+	if meta.image != "" {
+		if err := p.fetchImage(ctx, rawURL, meta.image, preview); err != nil {
+			// a preview without image is still worth sending
+			preview.Thumbnail, preview.Image = nil, nil
+		}
+	}
+
+	return preview, nil
+}
+
+func (p *LinkPreviewer) fetchImage(ctx context.Context, pageURL, imageURL string, preview *LinkPreview) error {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return err
+	}
+	ref, err := url.Parse(imageURL)
+	if err != nil {
+		return err
+	}
+
+	src, err := p.fetcher.openURL(ctx, base.ResolveReference(ref).String())
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(io.LimitReader(src, maxPreviewImageSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxPreviewImageSize {
+		return ErrTooLarge
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to decode preview image: %w", err)
+	}
+	if int64(config.Width)*int64(config.Height) > maxPreviewImagePixels {
+		return fmt.Errorf("preview image too large (%dx%d)", config.Width, config.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to decode preview image: %w", err)
+	}
+
+	if preview.Thumbnail, _, _, err = encodeJPEG(img, previewThumbnailSize, 70); err != nil {
+		return err
+	}
+	preview.Image, preview.ImageWidth, preview.ImageHeight, err = encodeJPEG(img, previewImageSize, 80)
+	return err
+}
+
+// FindURL returns the first http(s) URL in text, without trailing
+// punctuation.
+func FindURL(text string) string {
+	return strings.TrimRight(urlPattern.FindString(text), ".,;:!?)]}'")
+}
+
+// encodeJPEG scales img down to fit in a maxSide square and encodes it.
+func encodeJPEG(img image.Image, maxSide, quality int) ([]byte, uint32, uint32, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return nil, 0, 0, errors.New("empty image")
+	}
+
+	if width > maxSide || height > maxSide {
+		if width >= height {
+			height = max(1, height*maxSide/width)
+			width = maxSide
+		} else {
+			width = max(1, width*maxSide/height)
+			height = maxSide
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	// JPEG has no alpha, transparent images get a white background
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, 0, 0, err
+	}
+	return buf.Bytes(), uint32(width), uint32(height), nil
+}
+
+type openGraph struct {
+	title       string
+	description string
+	image       string
+}
+
+// parseOpenGraph reads the OpenGraph tags of a page head, falling back to the
+// title element, the description meta tag and Twitter cards.
+func parseOpenGraph(r io.Reader) openGraph {
+	var og, fallback openGraph
+	tokenizer := html.NewTokenizer(r)
+	inTitle := false
+
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return og.withFallback(fallback)
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			switch token.Data {
+			case "body":
+				return og.withFallback(fallback)
+			case "title":
+				inTitle = true
+			case "meta":
+				var property, content string
+				for _, attr := range token.Attr {
+					switch attr.Key {
+					case "property", "name":
+						property = strings.ToLower(attr.Val)
+					case "content":
+						content = strings.TrimSpace(attr.Val)
+					}
+				}
+				switch property {
+				case "og:title":
+					og.title = content
+				case "og:description":
+					og.description = content
+				case "og:image", "og:image:url", "og:image:secure_url":
+					if og.image == "" {
+						og.image = content
+					}
+				case "twitter:title":
+					setIfEmpty(&fallback.title, content)
+				case "description", "twitter:description":
+					setIfEmpty(&fallback.description, content)
+				case "twitter:image", "twitter:image:src":
+					setIfEmpty(&fallback.image, content)
+				}
+			}
+
+		case html.EndTagToken:
+			if tokenizer.Token().Data == "head" {
+				return og.withFallback(fallback)
+			}
+			inTitle = false
+
+		case html.TextToken:
+			if inTitle && fallback.title == "" {
+				fallback.title = strings.TrimSpace(string(tokenizer.Text()))
+			}
+		}
+	}
+}
+
+func (og openGraph) withFallback(fallback openGraph) openGraph {
+	setIfEmpty(&og.title, fallback.title)
+	setIfEmpty(&og.description, fallback.description)
+	setIfEmpty(&og.image, fallback.image)
+	return og
+}
+
+func setIfEmpty(dst *string, value string) {
+	if *dst == "" {
+		*dst = value
+	}
+}
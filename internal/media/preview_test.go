@@ -0,0 +1,77 @@
+package media
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseOpenGraph(t *testing.T) {
+	tests := []struct {
+		name string
+		page string
+		want openGraph
+	}{
+		{
+			name: "open graph",
+			page: `<html><head>
+				<title>Page title</title>
+				<meta property="og:title" content=" OG title ">
+				<meta property="og:description" content="OG description">
+				<meta property="og:image" content="/first.png">
+				<meta property="og:image" content="/second.png">
+			</head><body></body></html>`,
+			want: openGraph{title: "OG title", description: "OG description", image: "/first.png"},
+		},
+		{
+			name: "fallbacks",
+			page: `<html><head>
+				<title> Page title </title>
+				<meta name="description" content="Meta description">
+				<meta name="twitter:image" content="https://cdn.example.com/card.jpg">
+			</head></html>`,
+			want: openGraph{title: "Page title", description: "Meta description", image: "https://cdn.example.com/card.jpg"},
+		},
+		{
+			name: "twitter title over title element",
+			page: `<head><meta name="twitter:title" content="Card title"><title>Page title</title></head>`,
+			want: openGraph{title: "Card title"},
+		},
+		{
+			name: "stops at body",
+			page: `<head><title>Title</title></head><body><meta property="og:image" content="/late.png"></body>`,
+			want: openGraph{title: "Title"},
+		},
+		{
+			name: "no metadata",
+			page: `<p>plain</p>`,
+			want: openGraph{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseOpenGraph(strings.NewReader(tt.page)); got != tt.want {
+				t.Errorf("parseOpenGraph() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindURL(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"see https://example.com/page.", "https://example.com/page"},
+		{"(http://example.com/a?b=c)", "http://example.com/a?b=c"},
+		{"first https://a.example then https://b.example", "https://a.example"},
+		{"no link here", ""},
+		{"ftp://example.com", ""},
+	}
+
+	for _, tt := range tests {
+		if got := FindURL(tt.text); got != tt.want {
+			t.Errorf("FindURL(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
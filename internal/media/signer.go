@@ -0,0 +1,73 @@
+package media
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid media signature")
+	ErrExpiredSignature = errors.New("media link has expired")
+)
+
+// URLSigner creates and checks time-limited download links for stored
+// media, so they can be fetched without the user's API token.
+type URLSigner struct {
+	key     []byte
+	baseURL string
+	ttl     time.Duration
+}
+
+func NewURLSigner(key, baseURL string, ttl time.Duration) *URLSigner {
+	return &URLSigner{
+		key:     []byte(key),
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		ttl:     ttl,
+	}
+}
+
+// URL returns a signed link to the media and when it stops working.
+func (s *URLSigner) URL(sessionID, mediaID string) (string, time.Time) {
+	expiresAt := time.Now().Add(s.ttl).Truncate(time.Second)
+	expires := expiresAt.Unix()
+
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", s.sign(sessionID, mediaID, expires))
+
+	return fmt.Sprintf("%s%s?%s", s.baseURL, mediaPath(sessionID, mediaID), query.Encode()), expiresAt
+}
+
+func (s *URLSigner) Verify(sessionID, mediaID, expires, signature string) error {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	expected := s.sign(sessionID, mediaID, expiresAt)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+
+	if time.Now().Unix() > expiresAt {
+		return ErrExpiredSignature
+	}
+	return nil
+}
+
+func (s *URLSigner) sign(sessionID, mediaID string, expires int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "%s\n%d", mediaPath(sessionID, mediaID), expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func mediaPath(sessionID, mediaID string) string {
+	return "/media/signed/" + url.PathEscape(sessionID) + "/" + url.PathEscape(mediaID)
+}
@@ -0,0 +1,63 @@
+package media
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestURLSigner(t *testing.T) {
+	signer := NewURLSigner("key", "https://api.example.com/", time.Hour)
+
+	link, expiresAt := signer.URL("session 1", "media/1")
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("URL() returned an invalid link %q: %v", link, err)
+	}
+	if want := "https://api.example.com/media/signed/session%201/media%2F1"; !strings.HasPrefix(link, want+"?") {
+		t.Fatalf("URL() = %s, want prefix %s", link, want)
+	}
+	if until := time.Until(expiresAt); until <= 59*time.Minute || until > time.Hour {
+		t.Errorf("URL() expires in %s, want about an hour", until)
+	}
+
+	expires := u.Query().Get("expires")
+	signature := u.Query().Get("signature")
+	if expires != strconv.FormatInt(expiresAt.Unix(), 10) {
+		t.Errorf("expires = %s, want %d", expires, expiresAt.Unix())
+	}
+
+	past := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	pastSignature := signer.sign("session 1", "media/1", time.Now().Add(-time.Minute).Unix())
+
+	tests := []struct {
+		name      string
+		signer    *URLSigner
+		sessionID string
+		mediaID   string
+		expires   string
+		signature string
+		want      error
+	}{
+		{"valid", signer, "session 1", "media/1", expires, signature, nil},
+		{"other session", signer, "session 2", "media/1", expires, signature, ErrInvalidSignature},
+		{"other media", signer, "session 1", "media/2", expires, signature, ErrInvalidSignature},
+		{"extended expiry", signer, "session 1", "media/1", strconv.FormatInt(expiresAt.Unix()+3600, 10), signature, ErrInvalidSignature},
+		{"malformed expiry", signer, "session 1", "media/1", "tomorrow", signature, ErrInvalidSignature},
+		{"empty signature", signer, "session 1", "media/1", expires, "", ErrInvalidSignature},
+		{"other key", NewURLSigner("other", "https://api.example.com", time.Hour), "session 1", "media/1", expires, signature, ErrInvalidSignature},
+		{"expired", signer, "session 1", "media/1", past, pastSignature, ErrExpiredSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.signer.Verify(tt.sessionID, tt.mediaID, tt.expires, tt.signature)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Verify() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Source is media ready to be streamed to WhatsApp. MimeType is sniffed from
+// the first bytes of the content, falling back to the declared type.
+type Source struct {
+	io.Reader
+	MimeType string
+	closer   io.Closer
+}
+
+func (s *Source) Close() error {
+	if s.closer != nil {
+		return s.closer.Close()
+	}
+	return nil
+}
+
+func newSource(r io.Reader, declaredType string, closer io.Closer) (*Source, error) {
+	br := bufio.NewReaderSize(r, 512)
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		if closer != nil {
+			closer.Close()
+		}
+		return nil, err
+	}
+
+	return &Source{
+		Reader:   br,
+		MimeType: detectMimeType(head, declaredType),
+		closer:   closer,
+	}, nil
+}
+
+func newBytesSource(data []byte, declaredType string) (*Source, error) {
+	return newSource(bytes.NewReader(data), declaredType, nil)
+}
+
+func detectMimeType(head []byte, declaredType string) string {
+	sniffed := http.DetectContentType(head)
+	if declaredType == "" {
+		return sniffed
+	}
+
+	// DetectContentType only recognises a handful of formats, so a generic
+	// result shouldn't override what the sender told us
+	if strings.HasPrefix(sniffed, "application/octet-stream") || strings.HasPrefix(sniffed, "text/plain") {
+		return declaredType
+	}
+	return sniffed
+}
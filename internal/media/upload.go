@@ -0,0 +1,51 @@
+package media
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+)
+
+var ErrUnsupportedType = errors.New("unsupported media type")
+
+// allowedTypes lists the formats WhatsApp accepts for each media kind.
+// Documents may be anything, so they have no entry.
+var allowedTypes = map[string][]string{
+	"image": {"image/jpeg", "image/png", "image/webp"},
+	"audio": {
+		"audio/ogg", "application/ogg", "audio/mpeg", "audio/mp4", "audio/aac", "audio/amr",
+		// m4a files sniff as video/mp4
+		"video/mp4",
+	},
+	"video": {"video/mp4", "video/3gpp"},
+}
+
+// NewUpload wraps a file streamed from a client upload. Reads fail with
+// ErrTooLarge once more than maxSize bytes have been consumed.
+func NewUpload(r io.Reader, declaredType string, maxSize int64) (*Source, error) {
+	if maxSize > 0 {
+		r = &limitedReader{r: r, remaining: maxSize}
+	}
+	return newSource(r, declaredType, nil)
+}
+
+// ValidateMimeType checks that mimeType can be sent as the given media kind.
+func ValidateMimeType(kind, mimeType string) error {
+	allowed, ok := allowedTypes[kind]
+	if !ok {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUnsupportedType, mimeType)
+	}
+
+	for _, t := range allowed {
+		if mediaType == t {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w for %s: %s", ErrUnsupportedType, kind, mediaType)
+}
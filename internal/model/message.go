@@ -4,6 +4,16 @@ type TextMessage struct {
 	Phone   string `json:"phone"`
 	Message string `json:"message"`
 	ID      string `json:"id,omitempty"`
+	// QuotedMessageID replies to a message. Its sender and text are looked up
+	// in the message store unless QuotedSender and QuotedText are given
+	QuotedMessageID string `json:"quotedMessageId,omitempty"`
+	QuotedSender    string `json:"quotedSender,omitempty" example:"5511999999999@s.whatsapp.net"`
+	QuotedText      string `json:"quotedText,omitempty"`
+	// Mentions lists the phone numbers or JIDs to mention. When empty, the
+	// @number tokens of the message are mentioned
+	Mentions []string `json:"mentions,omitempty"`
+	// LinkPreview attaches a preview of the first URL in the message
+	LinkPreview bool `json:"linkPreview,omitempty"`
 }
 
 type ImageMessage struct {
@@ -55,6 +65,15 @@ type ContactMessage struct {
 	ID           string `json:"id,omitempty"`
 }
 
+type PollMessage struct {
+	Phone    string   `json:"phone"`
+	Question string   `json:"question"`
+	Options  []string `json:"options"`
+	// SelectableCount is how many options a voter may pick, 0 for any number
+	SelectableCount int    `json:"selectableCount,omitempty"`
+	ID              string `json:"id,omitempty"`
+}
+
 type ReactionMessage struct {
 	Phone     string `json:"phone"`
 	MessageID string `json:"message_id"`
@@ -66,6 +85,15 @@ type DeleteMessage struct {
 	MessageID string `json:"message_id"`
 }
 
+// EditMessage replaces the text of a sent text message, or the caption of a
+// sent image, video or document. Exactly one of Text and Caption is set.
+type EditMessage struct {
+	Phone     string `json:"phone"`
+	MessageID string `json:"message_id"`
+	Text      string `json:"text,omitempty"`
+	Caption   string `json:"caption,omitempty"`
+}
+
 type ConnectRequest struct {
 	Subscribe []string `json:"subscribe,omitempty"`
 	Immediate bool     `json:"immediate,omitempty"`
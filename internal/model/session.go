@@ -3,33 +3,74 @@ package model
 import "time"
 
 type Session struct {
-	ID        string    `json:"id" db:"id"`
-	UserID    string    `json:"userId" db:"userId"`
-	Name      string    `json:"name" db:"name"`
-	JID       string    `json:"jid,omitempty" db:"jid"`
-	QRCode    string    `json:"qrCode,omitempty" db:"qrCode"`
-	Connected int       `json:"connected" db:"connected"`
-	Webhook   string    `json:"webhook,omitempty" db:"webhook"`
-	Events    string    `json:"events,omitempty" db:"events"`
-	ProxyURL  string    `json:"proxyUrl,omitempty" db:"proxyUrl"`
-	CreatedAt time.Time `json:"createdAt" db:"createdAt"`
+	ID              string    `json:"id" db:"id"`
+	UserID          string    `json:"userId" db:"userId"`
+	Name            string    `json:"name" db:"name"`
+	JID             string    `json:"jid,omitempty" db:"jid"`
+	QRCode          string    `json:"qrCode,omitempty" db:"qrCode"`
+	Connected       int       `json:"connected" db:"connected"`
+	Webhook         string    `json:"webhook,omitempty" db:"webhook"`
+	Events          string    `json:"events,omitempty" db:"events"`
+	ProxyURL        string    `json:"proxyUrl,omitempty" db:"proxyUrl"`
+	DeviceJID       string    `json:"-" db:"deviceJid"`
+	StoreMessages   bool      `json:"storeMessages" db:"storeMessages"`
+	SearchLanguage  string    `json:"searchLanguage" db:"searchLanguage"`
+	HistoryDays     int       `json:"historyDays" db:"historyDays"`
+	HistoryMessages int       `json:"historyMessages" db:"historyMessages"`
+	CreatedAt       time.Time `json:"createdAt" db:"createdAt"`
+
+	WebhookMaxAttempts int  `json:"webhookMaxAttempts" db:"webhookMaxAttempts"`
+	WebhookMaxAge      int  `json:"webhookMaxAge" db:"webhookMaxAge"`
+	RedisStream        bool `json:"redisStream" db:"redisStream"`
+
+	WebhookSecret                  string     `json:"-" db:"webhookSecret"`
+	WebhookSecretPrevious          string     `json:"-" db:"webhookSecretPrevious"`
+	WebhookSecretPreviousExpiresAt *time.Time `json:"-" db:"webhookSecretPreviousExpiresAt"`
 }
 
-type SessionCreateRequest struct {
-	Name     string `json:"name" validate:"required"`
-	Webhook  string `json:"webhook,omitempty"`
-	Events   string `json:"events,omitempty"`
-	ProxyURL string `json:"proxyUrl,omitempty"`
+// WebhookSecrets returns the secrets deliveries are signed with: the current
+// one, plus the previous one while its rotation grace period lasts.
+func (s *Session) WebhookSecrets(now time.Time) []string {
+	secrets := []string{s.WebhookSecret}
+	if s.WebhookSecretPrevious != "" && s.WebhookSecretPreviousExpiresAt != nil && now.Before(*s.WebhookSecretPreviousExpiresAt) {
+		secrets = append(secrets, s.WebhookSecretPrevious)
+	}
+	return secrets
 }
 
-type SessionUpdateRequest struct {
-	Name     *string `json:"name,omitempty"`
-	Webhook  *string `json:"webhook,omitempty"`
-	Events   *string `json:"events,omitempty"`
-	ProxyURL *string `json:"proxyUrl,omitempty"`
+type SessionCreateRequest struct {
+	Name            string `json:"name" validate:"required"`
+	Webhook         string `json:"webhook,omitempty"`
+	Events          string `json:"events,omitempty"`
+	ProxyURL        string `json:"proxyUrl,omitempty"`
+	StoreMessages   *bool  `json:"storeMessages,omitempty"` // messages, polls and poll votes
+	SearchLanguage  string `json:"searchLanguage,omitempty" example:"portuguese"`
+	HistoryDays     int    `json:"historyDays,omitempty" example:"30"`
+	HistoryMessages int    `json:"historyMessages,omitempty" example:"500"`
+	// WebhookMaxAttempts defaults to 8, WebhookMaxAge (seconds, 0 = no limit) to 86400
+	WebhookMaxAttempts int  `json:"webhookMaxAttempts,omitempty" example:"8"`
+	WebhookMaxAge      *int `json:"webhookMaxAge,omitempty" example:"86400"`
+	// RedisStream publishes the events to the Redis Streams sink as well. The
+	// flag is read when events are published, not when they are created:
+	// turning it on also publishes the events still waiting for the sink,
+	// turning it off drops them
+	RedisStream bool `json:"redisStream,omitempty"`
 }
 
+type SessionUpdateRequest struct {
+	Name            *string `json:"name,omitempty"`
+	Webhook         *string `json:"webhook,omitempty"`
+	Events          *string `json:"events,omitempty"`
+	ProxyURL        *string `json:"proxyUrl,omitempty"`
+	StoreMessages   *bool   `json:"storeMessages,omitempty"`
+	SearchLanguage  *string `json:"searchLanguage,omitempty"`
+	HistoryDays     *int    `json:"historyDays,omitempty"`
+	HistoryMessages *int    `json:"historyMessages,omitempty"`
 
+	WebhookMaxAttempts *int  `json:"webhookMaxAttempts,omitempty"`
+	WebhookMaxAge      *int  `json:"webhookMaxAge,omitempty"`
+	RedisStream        *bool `json:"redisStream,omitempty"`
+}
 
 type SessionStatusResponse struct {
 	ID        string `json:"id"`
@@ -39,4 +80,5 @@ type SessionStatusResponse struct {
 	JID       string `json:"jid,omitempty"`
 	Webhook   string `json:"webhook,omitempty"`
 	Events    string `json:"events,omitempty"`
+	Proxy     string `json:"proxy,omitempty"`
 }
@@ -7,6 +7,7 @@ type User struct {
 	Name        string    `json:"name" db:"name"`
 	Token       string    `json:"token" db:"token"`
 	MaxSessions int       `json:"maxSessions" db:"maxSessions"`
+	MaxUploadMB int       `json:"maxUploadMB" db:"maxUploadMB"`
 	CreatedAt   time.Time `json:"createdAt" db:"createdAt"`
 }
 
@@ -14,10 +15,12 @@ type UserCreateRequest struct {
 	Name        string `json:"name" example:"Felipe"`
 	Token       string `json:"token" example:"abc123xyz"`
 	MaxSessions int    `json:"maxSessions,omitempty" example:"5"`
+	MaxUploadMB int    `json:"maxUploadMB,omitempty" example:"64"`
 }
 
 type UserUpdateRequest struct {
 	Name        *string `json:"name,omitempty"`
 	Token       *string `json:"token,omitempty"`
 	MaxSessions *int    `json:"maxSessions,omitempty"`
+	MaxUploadMB *int    `json:"maxUploadMB,omitempty"`
 }
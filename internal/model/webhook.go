@@ -0,0 +1,84 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// WebhookEndpoint is an additional webhook receiver of a session, with its
+// own subscription, secret and retry policy.
+type WebhookEndpoint struct {
+	ID          string         `json:"id" db:"id"`
+	UserID      string         `json:"-" db:"userId"`
+	SessionID   string         `json:"sessionId" db:"sessionId"`
+	URL         string         `json:"url" db:"url"`
+	Events      string         `json:"events" db:"events"`
+	Headers     WebhookHeaders `json:"headers" db:"headers"`
+	Enabled     bool           `json:"enabled" db:"enabled"`
+	MaxAttempts int            `json:"maxAttempts" db:"maxAttempts"`
+	MaxAge      int            `json:"maxAge" db:"maxAge"`
+	CreatedAt   time.Time      `json:"createdAt" db:"createdAt"`
+	UpdatedAt   time.Time      `json:"updatedAt" db:"updatedAt"`
+
+	Secret                  string     `json:"-" db:"secret"`
+	SecretPrevious          string     `json:"-" db:"secretPrevious"`
+	SecretPreviousExpiresAt *time.Time `json:"-" db:"secretPreviousExpiresAt"`
+}
+
+// Secrets returns the secrets deliveries are signed with, like
+// Session.WebhookSecrets.
+func (e *WebhookEndpoint) Secrets(now time.Time) []string {
+	secrets := []string{e.Secret}
+	if e.SecretPrevious != "" && e.SecretPreviousExpiresAt != nil && now.Before(*e.SecretPreviousExpiresAt) {
+		secrets = append(secrets, e.SecretPrevious)
+	}
+	return secrets
+}
+
+// WebhookHeaders are extra request headers sent to an endpoint, stored as
+// JSONB.
+type WebhookHeaders map[string]string
+
+func (h WebhookHeaders) Value() (driver.Value, error) {
+	if h == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(h)
+}
+
+func (h *WebhookHeaders) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*h = WebhookHeaders{}
+		return nil
+	default:
+		return errors.New("unsupported type for webhook headers")
+	}
+	return json.Unmarshal(data, h)
+}
+
+type WebhookEndpointCreateRequest struct {
+	URL     string            `json:"url" validate:"required"`
+	Events  []string          `json:"events,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Enabled *bool             `json:"enabled,omitempty"`
+	// MaxAttempts defaults to 8, MaxAge (seconds, 0 = no limit) to 86400
+	MaxAttempts int  `json:"maxAttempts,omitempty" example:"8"`
+	MaxAge      *int `json:"maxAge,omitempty" example:"86400"`
+}
+
+type WebhookEndpointUpdateRequest struct {
+	URL         *string            `json:"url,omitempty"`
+	Events      *[]string          `json:"events,omitempty"`
+	Headers     *map[string]string `json:"headers,omitempty"`
+	Enabled     *bool              `json:"enabled,omitempty"`
+	MaxAttempts *int               `json:"maxAttempts,omitempty"`
+	MaxAge      *int               `json:"maxAge,omitempty"`
+}
@@ -1,23 +1,34 @@
 package router
 
 import (
+	"context"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/jmoiron/sqlx"
 	httpSwagger "github.com/swaggo/http-swagger"
 
 	"fiozap/internal/config"
+	"fiozap/internal/database"
 	"fiozap/internal/database/repository"
 	"fiozap/internal/handler"
+	"fiozap/internal/logger"
+	"fiozap/internal/media"
 	"fiozap/internal/middleware"
 	"fiozap/internal/service"
+	"fiozap/internal/sink"
+	"fiozap/internal/storage"
+	"fiozap/internal/stream"
 	"fiozap/internal/webhook"
 )
 
 type Router struct {
 	mux            *mux.Router
 	dispatcher     *webhook.Dispatcher
+	hub            *stream.Hub
+	publishers     []*sink.Publisher
 	sessionService *service.SessionService
 }
 
@@ -27,6 +38,11 @@ func New(cfg *config.Config, db *sqlx.DB) *Router {
 	userRepo := repository.NewUserRepository(db)
 	sessionRepo := repository.NewSessionRepository(db)
 	webhookRepo := repository.NewWebhookRepository(db)
+	endpointRepo := repository.NewWebhookEndpointRepository(db)
+	messageRepo := repository.NewMessageRepository(db)
+	chatRepo := repository.NewChatRepository(db)
+	mediaRepo := repository.NewMediaRepository(db)
+	pollRepo := repository.NewPollRepository(db)
 
 	authMiddleware := middleware.NewAuthMiddleware(userRepo)
 	adminMiddleware := middleware.NewAdminMiddleware(cfg.AdminToken)
@@ -37,13 +53,57 @@ func New(cfg *config.Config, db *sqlx.DB) *Router {
 
 	sessionService := service.NewSessionService(userRepo, sessionRepo, cfg)
 	sessionService.SetWebhookRepo(webhookRepo)
+	sessionService.SetMessageRepo(messageRepo)
+	sessionService.SetChatRepo(chatRepo)
+	sessionService.SetMediaRepo(mediaRepo)
+	sessionService.SetPollRepo(pollRepo)
+
+	mediaStorage, err := storage.New(context.Background(), storage.Config{
+		Backend:     cfg.MediaStorage,
+		LocalPath:   cfg.MediaStoragePath,
+		PublicURL:   cfg.MediaPublicURL,
+		S3Endpoint:  cfg.S3Endpoint,
+		S3Region:    cfg.S3Region,
+		S3Bucket:    cfg.S3Bucket,
+		S3AccessKey: cfg.S3AccessKey,
+		S3SecretKey: cfg.S3SecretKey,
+		S3UseSSL:    cfg.S3UseSSL,
+	})
+	if err != nil {
+		logger.Fatalf("Failed to set up media storage: %v", err)
+	}
+	sessionService.SetMediaStorage(mediaStorage, int64(cfg.MediaStoreMaxMB)<<20)
 
-	dispatcher := webhook.NewDispatcher(webhookRepo, sessionRepo)
+	if cfg.MediaSigningKeyEphemeral {
+		logger.Warn("Neither MEDIA_SIGNING_KEY nor ADMIN_TOKEN is set, signed media links will stop working on restart")
+	}
+	mediaSigner := media.NewURLSigner(cfg.MediaSigningKey, cfg.PublicURL, time.Duration(cfg.MediaURLTTL)*time.Second)
+	sessionService.SetMediaSigner(mediaSigner)
+
+	dispatcher := webhook.NewDispatcher(webhookRepo, sessionRepo, endpointRepo)
+	dispatcher.SetConcurrency(cfg.WebhookWorkers, cfg.WebhookMaxInFlight)
+	if listener, err := database.Listen(cfg, repository.WebhookNotifyChannel); err != nil {
+		logger.Warnf("Webhook notifications unavailable, polling only: %v", err)
+		dispatcher.SetListener(nil, 2*time.Second)
+	} else {
+		dispatcher.SetListener(listener, time.Duration(cfg.WebhookPollInterval)*time.Second)
+	}
 	sessionService.SetDispatcher(dispatcher)
 
 	sessionHandler := handler.NewSessionHandler(sessionService)
 
-	messageService := service.NewMessageService(sessionService)
+	mediaFetcher := media.NewFetcher(media.FetcherConfig{
+		MaxSize:              int64(cfg.MediaMaxDownloadMB) << 20,
+		Timeout:              time.Duration(cfg.MediaDownloadTimeout) * time.Second,
+		Allowlist:            splitList(cfg.MediaURLAllowlist),
+		Denylist:             splitList(cfg.MediaURLDenylist),
+		AllowPrivateNetworks: cfg.MediaAllowPrivateNetworks,
+	})
+
+	messageService := service.NewMessageService(sessionService, messageRepo, mediaFetcher)
+	messageService.SetMaxUploadSize(int64(cfg.MediaMaxUploadMB) << 20)
+	messageService.SetPollRepo(pollRepo)
+	messageService.SetLinkPreviewer(media.NewLinkPreviewer(mediaFetcher, time.Duration(cfg.LinkPreviewCacheTTL)*time.Second))
 	messageHandler := handler.NewMessageHandler(messageService)
 
 	userService := service.NewUserService(sessionService)
@@ -52,7 +112,49 @@ func New(cfg *config.Config, db *sqlx.DB) *Router {
 	groupService := service.NewGroupService(sessionService)
 	groupHandler := handler.NewGroupHandler(groupService)
 
-	webhookHandler := handler.NewWebhookHandler(sessionRepo)
+	webhookHandler := handler.NewWebhookHandler(sessionRepo, webhookRepo, endpointRepo)
+
+	eventListener, err := database.Listen(cfg, repository.EventNotifyChannel)
+	if err != nil {
+		logger.Warnf("Event notifications unavailable, streams will poll: %v", err)
+		eventListener = nil
+	}
+	hub := stream.NewHub(eventListener)
+	eventHandler := handler.NewEventHandler(webhookRepo, hub, sessionService)
+
+	var publishers []*sink.Publisher
+	sinkRepo := repository.NewEventSinkRepository(db)
+	if cfg.NATSURL != "" {
+		natsSink, err := sink.NewNATS(context.Background(), sink.NATSConfig{
+			URL:             cfg.NATSURL,
+			SubjectPrefix:   cfg.NATSSubjectPrefix,
+			Stream:          cfg.NATSStream,
+			CreateStream:    cfg.NATSCreateStream,
+			MaxAge:          time.Duration(cfg.NATSStreamMaxAge) * time.Second,
+			DuplicateWindow: time.Duration(cfg.NATSDuplicateWindow) * time.Second,
+		})
+		if err != nil {
+			logger.Fatalf("Failed to set up NATS event sink: %v", err)
+		}
+		publishers = append(publishers, sink.NewPublisher(natsSink, sinkRepo, webhookRepo, hub))
+	}
+	if cfg.RedisURL != "" {
+		redisSink, err := sink.NewRedis(context.Background(), sink.RedisConfig{
+			URL:           cfg.RedisURL,
+			KeyTemplate:   cfg.RedisStreamKey,
+			MaxLen:        int64(cfg.RedisStreamMaxLen),
+			ConsumerGroup: cfg.RedisConsumerGroup,
+		})
+		if err != nil {
+			logger.Fatalf("Failed to set up Redis event sink: %v", err)
+		}
+		publisher := sink.NewPublisher(redisSink, sinkRepo, webhookRepo, hub)
+		publisher.SetFilter(repository.EventFilter{RedisStream: true})
+		publishers = append(publishers, publisher)
+	}
+
+	mediaService := service.NewMediaService(mediaRepo, mediaStorage, mediaSigner)
+	mediaHandler := handler.NewMediaHandler(mediaService)
 
 	r.Use(cors)
 	r.Use(middleware.Logging)
@@ -70,6 +172,9 @@ func New(cfg *config.Config, db *sqlx.DB) *Router {
 	admin.HandleFunc("/users/{id}", adminHandler.DeleteUser).Methods("DELETE")
 	admin.HandleFunc("/sessions", sessionHandler.AdminListAllSessions).Methods("GET")
 
+	// Signed media links carry their own authorization instead of a token
+	r.HandleFunc("/media/signed/{sessionId}/{mediaId}", mediaHandler.DownloadSigned).Methods("GET", "HEAD")
+
 	// API routes (authenticated)
 	api := r.PathPrefix("").Subrouter()
 	api.Use(authMiddleware.Authenticate)
@@ -77,6 +182,8 @@ func New(cfg *config.Config, db *sqlx.DB) *Router {
 	// Sessions CRUD (user manages own sessions)
 	api.HandleFunc("/sessions", sessionHandler.ListSessions).Methods("GET")
 	api.HandleFunc("/sessions", sessionHandler.CreateSession).Methods("POST")
+	api.HandleFunc("/events/ws", eventHandler.UserWebSocket).Methods("GET")
+	api.HandleFunc("/events/sse", eventHandler.UserSSE).Methods("GET")
 
 	// Session-specific routes (require session validation)
 	sessionRoutes := api.PathPrefix("/sessions/{sessionId}").Subrouter()
@@ -103,8 +210,20 @@ func New(cfg *config.Config, db *sqlx.DB) *Router {
 	sessionRoutes.HandleFunc("/messages/document", messageHandler.SendDocument).Methods("POST")
 	sessionRoutes.HandleFunc("/messages/location", messageHandler.SendLocation).Methods("POST")
 	sessionRoutes.HandleFunc("/messages/contact", messageHandler.SendContact).Methods("POST")
+	sessionRoutes.HandleFunc("/messages/poll", messageHandler.SendPoll).Methods("POST")
 	sessionRoutes.HandleFunc("/messages/reaction", messageHandler.React).Methods("POST")
 	sessionRoutes.HandleFunc("/messages/delete", messageHandler.Delete).Methods("POST")
+	sessionRoutes.HandleFunc("/messages/edit", messageHandler.Edit).Methods("POST")
+
+	// Message history (per session)
+	sessionRoutes.HandleFunc("/chats/{jid}/messages", messageHandler.ListChatMessages).Methods("GET")
+	sessionRoutes.HandleFunc("/messages/search", messageHandler.SearchMessages).Methods("GET")
+	sessionRoutes.HandleFunc("/messages/{messageId}", messageHandler.GetMessage).Methods("GET")
+	sessionRoutes.HandleFunc("/polls/{messageId}/results", messageHandler.GetPollResults).Methods("GET")
+
+	// Media (per session)
+	sessionRoutes.HandleFunc("/media/{mediaId}", mediaHandler.Download).Methods("GET", "HEAD")
+	sessionRoutes.HandleFunc("/media/{mediaId}/url", mediaHandler.GetURL).Methods("GET")
 
 	// User operations (per session)
 	sessionRoutes.HandleFunc("/user/info", userHandler.GetInfo).Methods("POST")
@@ -129,10 +248,27 @@ func New(cfg *config.Config, db *sqlx.DB) *Router {
 	sessionRoutes.HandleFunc("/webhook", webhookHandler.Set).Methods("POST")
 	sessionRoutes.HandleFunc("/webhook", webhookHandler.Update).Methods("PUT")
 	sessionRoutes.HandleFunc("/webhook", webhookHandler.Delete).Methods("DELETE")
+	sessionRoutes.HandleFunc("/webhook/secret/rotate", webhookHandler.RotateSecret).Methods("POST")
+	sessionRoutes.HandleFunc("/webhook/endpoints", webhookHandler.ListEndpoints).Methods("GET")
+	sessionRoutes.HandleFunc("/webhook/endpoints", webhookHandler.CreateEndpoint).Methods("POST")
+	sessionRoutes.HandleFunc("/webhook/endpoints/{endpointId}", webhookHandler.GetEndpoint).Methods("GET")
+	sessionRoutes.HandleFunc("/webhook/endpoints/{endpointId}", webhookHandler.UpdateEndpoint).Methods("PUT")
+	sessionRoutes.HandleFunc("/webhook/endpoints/{endpointId}", webhookHandler.DeleteEndpoint).Methods("DELETE")
+	sessionRoutes.HandleFunc("/webhook/endpoints/{endpointId}/secret/rotate", webhookHandler.RotateEndpointSecret).Methods("POST")
+	sessionRoutes.HandleFunc("/webhook/deliveries", webhookHandler.ListDeliveries).Methods("GET")
+	sessionRoutes.HandleFunc("/webhook/deliveries/replay", webhookHandler.ReplayDeliveries).Methods("POST")
+	sessionRoutes.HandleFunc("/webhook/deliveries/{deliveryId}", webhookHandler.GetDelivery).Methods("GET")
+	sessionRoutes.HandleFunc("/webhook/deliveries/{deliveryId}/replay", webhookHandler.ReplayDelivery).Methods("POST")
+
+	// Event streams (per session)
+	sessionRoutes.HandleFunc("/events/ws", eventHandler.SessionWebSocket).Methods("GET")
+	sessionRoutes.HandleFunc("/events/sse", eventHandler.SessionSSE).Methods("GET")
 
 	return &Router{
 		mux:            r,
 		dispatcher:     dispatcher,
+		hub:            hub,
+		publishers:     publishers,
 		sessionService: sessionService,
 	}
 }
@@ -143,9 +279,19 @@ func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 func (rt *Router) StartDispatcher() {
 	rt.dispatcher.Start()
+	rt.hub.Start()
+	for _, p := range rt.publishers {
+		if err := p.Start(); err != nil {
+			logger.Errorf("%v", err)
+		}
+	}
 }
 
 func (rt *Router) StopDispatcher() {
+	for _, p := range rt.publishers {
+		p.Stop()
+	}
+	rt.hub.Stop()
 	rt.dispatcher.Stop()
 }
 
@@ -157,7 +303,7 @@ func cors(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Token")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Token, Range, Last-Event-ID")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -167,3 +313,10 @@ func cors(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"fiozap/internal/database/repository"
+	"fiozap/internal/model"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 200
+)
+
+// ErrInvalidQuery marks errors caused by bad client input to a history query.
+var ErrInvalidQuery = errors.New("invalid query")
+
+type MessageHistoryQuery struct {
+	Cursor      string
+	Limit       int
+	MessageType string
+	Sender      string
+	FromMe      *bool
+	Since       *time.Time
+	Until       *time.Time
+}
+
+func (s *MessageService) ListChatMessages(sessionID, chat string, q *MessageHistoryQuery) (map[string]interface{}, error) {
+	chatJID, err := parseJID(chat)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	filter := repository.MessageFilter{
+		MessageType: q.MessageType,
+		FromMe:      q.FromMe,
+		Since:       q.Since,
+		Until:       q.Until,
+		Limit:       limit + 1,
+	}
+
+	if q.Sender != "" {
+		senderJID, err := parseJID(q.Sender)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+		}
+		filter.SenderJID = senderJID.ToNonAD().String()
+	}
+
+	if q.Cursor != "" {
+		ts, id, err := decodeMessageCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		filter.BeforeTimestamp = &ts
+		filter.BeforeID = id
+	}
+
+	messages, err := s.messageRepo.GetByChat(sessionID, chatJID.String(), filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+
+	nextCursor := ""
+	if len(messages) > limit {
+		messages = messages[:limit]
+		last := messages[len(messages)-1]
+		nextCursor = encodeMessageCursor(last.Timestamp, last.ID)
+	}
+
+	if messages == nil {
+		messages = []repository.Message{}
+	}
+
+	return map[string]interface{}{
+		"chat":       chatJID.String(),
+		"messages":   messages,
+		"nextCursor": nextCursor,
+	}, nil
+}
+
+// GetMessage returns a stored message along with its revisions when it was
+// edited.
+func (s *MessageService) GetMessage(sessionID, messageID string) (*repository.Message, error) {
+	msg, err := s.messageRepo.GetByID(sessionID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.Revision > 0 {
+		if msg.Revisions, err = s.messageRepo.GetRevisions(sessionID, messageID); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// Cursors are opaque to clients: base64("<unix micros>:<row id>") of the last
+// message on the previous page.
+func encodeMessageCursor(ts time.Time, id int64) string {
+	raw := fmt.Sprintf("%d:%d", ts.UnixMicro(), id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeMessageCursor(cursor string) (time.Time, int64, error) {
+	invalid := fmt.Errorf("%w: invalid cursor", ErrInvalidQuery)
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, invalid
+	}
+
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return time.Time{}, 0, invalid
+	}
+
+	micros, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, invalid
+	}
+
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return time.Time{}, 0, invalid
+	}
+
+	return time.UnixMicro(micros).UTC(), id, nil
+}
+
+type MessageSearchQuery struct {
+	Query  string
+	Chat   string
+	Since  *time.Time
+	Until  *time.Time
+	Limit  int
+	Offset int
+}
+
+func (s *MessageService) SearchMessages(session *model.Session, q *MessageSearchQuery) (map[string]interface{}, error) {
+	if strings.TrimSpace(q.Query) == "" {
+		return nil, fmt.Errorf("%w: q is required", ErrInvalidQuery)
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+	if limit > maxHistoryLimit {
+		limit = maxHistoryLimit
+	}
+
+	search := repository.MessageSearch{
+		Query:  q.Query,
+		Since:  q.Since,
+		Until:  q.Until,
+		Limit:  limit,
+		Offset: q.Offset,
+	}
+
+	if q.Chat != "" {
+		chatJID, err := parseJID(q.Chat)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidQuery, err)
+		}
+		search.ChatJID = chatJID.String()
+	}
+
+	results, err := s.messageRepo.Search(session.ID, search)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+
+	if results == nil {
+		results = []repository.MessageSearchResult{}
+	}
+
+	return map[string]interface{}{
+		"query":   q.Query,
+		"results": results,
+		"limit":   limit,
+		"offset":  q.Offset,
+	}, nil
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMessageCursor(t *testing.T) {
+	ts := time.Date(2024, 5, 6, 7, 8, 9, 123456000, time.UTC)
+
+	cursor := encodeMessageCursor(ts, 42)
+	gotTS, gotID, err := decodeMessageCursor(cursor)
+	if err != nil {
+		t.Fatalf("decodeMessageCursor(%q) error = %v", cursor, err)
+	}
+	if !gotTS.Equal(ts) || gotID != 42 {
+		t.Errorf("decodeMessageCursor() = %s, %d, want %s, 42", gotTS, gotID, ts)
+	}
+}
+
+func TestDecodeMessageCursorInvalid(t *testing.T) {
+	encode := func(raw string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(raw))
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{"not base64", "%%%"},
+		{"no separator", encode("1714979289123456")},
+		{"bad timestamp", encode("soon:42")},
+		{"bad id", encode("1714979289123456:x")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := decodeMessageCursor(tt.cursor); !errors.Is(err, ErrInvalidQuery) {
+				t.Errorf("decodeMessageCursor(%q) error = %v, want ErrInvalidQuery", tt.cursor, err)
+			}
+		})
+	}
+}
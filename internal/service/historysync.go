@@ -0,0 +1,122 @@
+package service
+
+import (
+	"sort"
+	"time"
+
+	"go.mau.fi/whatsmeow/proto/waHistorySync"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"fiozap/internal/database/repository"
+	"fiozap/internal/logger"
+	"fiozap/internal/wameow"
+)
+
+// ingestHistorySync decodes a history sync blob, stores its chats and
+// messages according to the session retention settings and emits a compact
+// HistorySyncProgress event instead of the raw protobuf.
+func (s *SessionService) ingestHistorySync(userID, sessionID string, client *wameow.Client, evt *events.HistorySync) {
+	data := evt.Data
+
+	session, err := s.sessionRepo.GetByID(sessionID)
+	if err != nil {
+		logger.Warnf("Failed to load session %s for history sync: %v", sessionID, err)
+		return
+	}
+
+	var cutoff time.Time
+	if session.HistoryDays > 0 {
+		cutoff = time.Now().AddDate(0, 0, -session.HistoryDays)
+	}
+
+	storeMessages := session.StoreMessages && s.messageRepo != nil
+	var conversations, messages int
+	var stored int64
+
+	for _, conv := range data.GetConversations() {
+		chatJID, err := types.ParseJID(conv.GetID())
+		if err != nil {
+			logger.Debugf("Skipping history conversation with invalid JID %q: %v", conv.GetID(), err)
+			continue
+		}
+		conversations++
+		messages += len(conv.GetMessages())
+
+		s.storeHistoryChat(userID, sessionID, chatJID, conv)
+
+		if !storeMessages {
+			continue
+		}
+
+		var kept []*events.Message
+		for _, item := range conv.GetMessages() {
+			msgEvt, err := client.GetClient().ParseWebMessage(chatJID, item.GetMessage())
+			if err != nil {
+				logger.Debugf("Failed to parse history message in %s: %v", chatJID, err)
+				continue
+			}
+			if !cutoff.IsZero() && msgEvt.Info.Timestamp.Before(cutoff) {
+				continue
+			}
+			kept = append(kept, msgEvt)
+		}
+
+		if session.HistoryMessages > 0 && len(kept) > session.HistoryMessages {
+			sort.Slice(kept, func(i, j int) bool {
+				return kept[i].Info.Timestamp.After(kept[j].Info.Timestamp)
+			})
+			kept = kept[:session.HistoryMessages]
+		}
+
+		records := make([]repository.Message, 0, len(kept))
+		for _, msgEvt := range kept {
+			records = append(records, newMessageRecord(userID, sessionID, &msgEvt.Info, msgEvt.Message))
+			// polls follow the same retention as the messages
+			s.storePoll(userID, sessionID, &msgEvt.Info, msgEvt.Message)
+		}
+
+		n, err := s.messageRepo.CreateBatch(records)
+		if err != nil {
+			logger.Warnf("Failed to store history messages for %s: %v", chatJID, err)
+			continue
+		}
+		stored += n
+	}
+
+	logger.Infof("History sync for session %s: %d conversations, %d messages, %d stored",
+		sessionID, conversations, messages, stored)
+
+	s.handleEvent(userID, sessionID, "HistorySyncProgress", wameow.NewHistorySyncProgressEvent(
+		data.GetSyncType().String(), data.GetChunkOrder(), data.GetProgress(), conversations, messages, stored))
+}
+
+func (s *SessionService) storeHistoryChat(userID, sessionID string, chatJID types.JID, conv *waHistorySync.Conversation) {
+	if s.chatRepo == nil {
+		return
+	}
+
+	name := conv.GetName()
+	if name == "" {
+		name = conv.GetDisplayName()
+	}
+
+	chat := &repository.Chat{
+		UserID:      userID,
+		SessionID:   sessionID,
+		ChatJID:     chatJID.String(),
+		Name:        name,
+		UnreadCount: int(conv.GetUnreadCount()),
+		Archived:    conv.GetArchived(),
+		Pinned:      conv.GetPinned() > 0,
+	}
+
+	if ts := conv.GetConversationTimestamp(); ts > 0 {
+		lastMessageAt := time.Unix(int64(ts), 0).UTC()
+		chat.LastMessageAt = &lastMessageAt
+	}
+
+	if err := s.chatRepo.Upsert(chat); err != nil {
+		logger.Warnf("Failed to store chat %s: %v", chatJID, err)
+	}
+}
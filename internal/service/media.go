@@ -0,0 +1,299 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"fiozap/internal/database/repository"
+	"fiozap/internal/logger"
+	"fiozap/internal/media"
+	"fiozap/internal/storage"
+	"fiozap/internal/wameow"
+)
+
+const (
+	mediaDownloadTimeout = 5 * time.Minute
+	mediaWorkers         = 4
+	mediaQueueSize       = 256
+)
+
+var (
+	ErrMediaNotFound  = errors.New("media not found")
+	ErrMediaQueueFull = errors.New("too many media downloads pending")
+)
+
+// preferredExtensions avoids mime.ExtensionsByType picking odd aliases such
+// as ".jfif" for JPEG.
+var preferredExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/webp":      ".webp",
+	"video/mp4":       ".mp4",
+	"video/3gpp":      ".3gp",
+	"audio/ogg":       ".ogg",
+	"audio/mpeg":      ".mp3",
+	"audio/mp4":       ".m4a",
+	"audio/aac":       ".aac",
+	"audio/amr":       ".amr",
+	"application/pdf": ".pdf",
+}
+
+// incomingMedia is the downloadable part of a received message.
+type incomingMedia struct {
+	downloadable whatsmeow.DownloadableMessage
+	mimeType     string
+	fileName     string
+	size         uint64
+	sha256       []byte
+}
+
+func getIncomingMedia(m *waE2E.Message) *incomingMedia {
+	switch {
+	case m.GetImageMessage() != nil:
+		img := m.GetImageMessage()
+		return &incomingMedia{img, img.GetMimetype(), "", img.GetFileLength(), img.GetFileSHA256()}
+	case m.GetVideoMessage() != nil:
+		video := m.GetVideoMessage()
+		return &incomingMedia{video, video.GetMimetype(), "", video.GetFileLength(), video.GetFileSHA256()}
+	case m.GetAudioMessage() != nil:
+		audio := m.GetAudioMessage()
+		return &incomingMedia{audio, audio.GetMimetype(), "", audio.GetFileLength(), audio.GetFileSHA256()}
+	case m.GetDocumentMessage() != nil:
+		doc := m.GetDocumentMessage()
+		return &incomingMedia{doc, doc.GetMimetype(), doc.GetFileName(), doc.GetFileLength(), doc.GetFileSHA256()}
+	case m.GetStickerMessage() != nil:
+		sticker := m.GetStickerMessage()
+		return &incomingMedia{sticker, sticker.GetMimetype(), "", sticker.GetFileLength(), sticker.GetFileSHA256()}
+	default:
+		return nil
+	}
+}
+
+// mediaJob is a received message whose media is stored in the background.
+// media is a copy of the media of its Message event.
+type mediaJob struct {
+	userID    string
+	sessionID string
+	client    *wameow.Client
+	messageID string
+	chat      string
+	incoming  *incomingMedia
+	media     wameow.MessageMedia
+}
+
+// startMediaWorkers starts the workers storing incoming media. Downloads can
+// take minutes, so they must not run in the whatsmeow event handler, which
+// would hold back every later event of the session.
+func (s *SessionService) startMediaWorkers() {
+	s.mediaJobs = make(chan *mediaJob, mediaQueueSize)
+	for i := 0; i < mediaWorkers; i++ {
+		go func() {
+			for job := range s.mediaJobs {
+				s.storeIncomingMedia(job)
+			}
+		}()
+	}
+}
+
+// queueIncomingMedia marks the media of a received message as pending and
+// queues it for storage when storage is configured. When the queue is full
+// the media is not stored and the Message event carries the error instead.
+func (s *SessionService) queueIncomingMedia(userID, sessionID string, client *wameow.Client, evt *events.Message, payload *wameow.MessageEvent) {
+	if s.mediaJobs == nil || s.mediaRepo == nil {
+		return
+	}
+	incoming := getIncomingMedia(evt.Message)
+	if incoming == nil || payload.Message.Media == nil {
+		return
+	}
+	media := payload.Message.Media
+
+	job := &mediaJob{
+		userID:    userID,
+		sessionID: sessionID,
+		client:    client,
+		messageID: evt.Info.ID,
+		chat:      evt.Info.Chat.String(),
+		incoming:  incoming,
+		media:     *media,
+	}
+	select {
+	case s.mediaJobs <- job:
+		media.Pending = true
+	default:
+		logger.Warnf("Media queue full, not storing media of message %s", evt.Info.ID)
+		media.Error = ErrMediaQueueFull.Error()
+	}
+}
+
+// storeIncomingMedia stores the media of a job, points the stored message at
+// it and emits the MediaStored event.
+func (s *SessionService) storeIncomingMedia(job *mediaJob) {
+	media := &job.media
+	media.Pending = false
+
+	stored, err := s.downloadMedia(job.userID, job.sessionID, job.client, job.messageID, job.incoming)
+	if err != nil {
+		logger.Warnf("Failed to store media of message %s: %v", job.messageID, err)
+		media.Error = err.Error()
+	} else {
+		mediaLink := s.mediaStorage.URL(stored.StorageKey)
+		media.ID = stored.ID
+		media.URL = mediaLink
+		media.Size = uint64(stored.Size)
+		if s.mediaSigner != nil {
+			signedURL, expiresAt := s.mediaSigner.URL(job.sessionID, stored.ID)
+			media.URL = signedURL
+			media.URLExpiresAt = expiresAt.Unix()
+		}
+
+		if s.messageRepo != nil {
+			if err := s.messageRepo.SetMediaLink(job.sessionID, job.messageID, mediaLink); err != nil {
+				logger.Warnf("Failed to record media of message %s: %v", job.messageID, err)
+			}
+		}
+	}
+
+	s.handleEvent(job.userID, job.sessionID, "MediaStored", wameow.NewMediaStoredEvent(job.messageID, job.chat, media))
+}
+
+// downloadMedia decrypts the media into a temp file and copies it to the
+// storage backend.
+func (s *SessionService) downloadMedia(userID, sessionID string, client *wameow.Client, messageID string, incoming *incomingMedia) (*repository.Media, error) {
+	// messages can be redelivered, e.g. after a retry receipt
+	if existing, err := s.mediaRepo.GetByMessageID(sessionID, messageID); err == nil {
+		return existing, nil
+	}
+
+	if s.mediaMaxSize > 0 && incoming.size > uint64(s.mediaMaxSize) {
+		return nil, fmt.Errorf("media too large to store (%d bytes)", incoming.size)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mediaDownloadTimeout)
+	defer cancel()
+
+	tmp, err := os.CreateTemp("", "fiozap-media-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := client.GetClient().DownloadToFile(ctx, incoming.downloadable, tmp); err != nil {
+		return nil, fmt.Errorf("failed to download media: %w", err)
+	}
+
+	info, err := tmp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tmp.Seek(0, 0); err != nil {
+		return nil, err
+	}
+
+	key := fmt.Sprintf("%s/%s%s", sessionID, messageID, mediaExtension(incoming.mimeType))
+	if err := s.mediaStorage.Put(ctx, key, tmp, info.Size(), incoming.mimeType); err != nil {
+		return nil, fmt.Errorf("failed to store media: %w", err)
+	}
+
+	return s.mediaRepo.Create(&repository.Media{
+		UserID:     userID,
+		SessionID:  sessionID,
+		MessageID:  messageID,
+		StorageKey: key,
+		MimeType:   incoming.mimeType,
+		FileName:   incoming.fileName,
+		Size:       info.Size(),
+		// whatsmeow verifies the plaintext hash while decrypting
+		SHA256: hex.EncodeToString(incoming.sha256),
+	})
+}
+
+func mediaExtension(mimeType string) string {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return ""
+	}
+	if ext, ok := preferredExtensions[mediaType]; ok {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// MediaService serves media downloaded by the sessions.
+type MediaService struct {
+	mediaRepo *repository.MediaRepository
+	storage   storage.Storage
+	signer    *media.URLSigner
+}
+
+func NewMediaService(mediaRepo *repository.MediaRepository, store storage.Storage, signer *media.URLSigner) *MediaService {
+	return &MediaService{
+		mediaRepo: mediaRepo,
+		storage:   store,
+		signer:    signer,
+	}
+}
+
+// Open returns the media record and its content. The caller must close the
+// reader.
+func (s *MediaService) Open(ctx context.Context, sessionID, mediaID string) (*repository.Media, io.ReadSeekCloser, error) {
+	if s.storage == nil {
+		return nil, nil, ErrMediaNotFound
+	}
+
+	record, err := s.mediaRepo.GetByID(sessionID, mediaID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, ErrMediaNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	content, err := s.storage.Get(ctx, record.StorageKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil, ErrMediaNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read media: %w", err)
+	}
+
+	return record, content, nil
+}
+
+func (s *MediaService) VerifySignature(sessionID, mediaID, expires, signature string) error {
+	return s.signer.Verify(sessionID, mediaID, expires, signature)
+}
+
+// SignedURL issues a fresh download link for media the caller can access.
+func (s *MediaService) SignedURL(sessionID, mediaID string) (map[string]interface{}, error) {
+	record, err := s.mediaRepo.GetByID(sessionID, mediaID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMediaNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signedURL, expiresAt := s.signer.URL(sessionID, record.ID)
+	return map[string]interface{}{
+		"url":       signedURL,
+		"expiresAt": expiresAt.Unix(),
+		"mimeType":  record.MimeType,
+		"size":      record.Size,
+	}, nil
+}
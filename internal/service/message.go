@@ -2,28 +2,69 @@ package service
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"fmt"
-	"net/http"
+	"regexp"
 	"strings"
+	"time"
 
-	"github.com/vincent-petithory/dataurl"
 	"go.mau.fi/whatsmeow"
 	"go.mau.fi/whatsmeow/proto/waCommon"
 	"go.mau.fi/whatsmeow/proto/waE2E"
 	"go.mau.fi/whatsmeow/types"
 	"google.golang.org/protobuf/proto"
 
+	"fiozap/internal/database/repository"
 	"fiozap/internal/logger"
+	"fiozap/internal/media"
 	"fiozap/internal/model"
 )
 
+// ErrInvalidMessage marks errors caused by bad client input to a send
+// request.
+var ErrInvalidMessage = errors.New("invalid message")
+
+const maxPollOptions = 12
+
+// mentionPattern matches the @number tokens WhatsApp renders as mentions.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@(\d{5,16})\b`)
+
 type MessageService struct {
 	sessionService *SessionService
+	messageRepo    *repository.MessageRepository
+	fetcher        *media.Fetcher
+	previewer      *media.LinkPreviewer
+	pollRepo       *repository.PollRepository
+	maxUploadSize  int64
+}
+
+func NewMessageService(sessionService *SessionService, messageRepo *repository.MessageRepository, fetcher *media.Fetcher) *MessageService {
+	return &MessageService{
+		sessionService: sessionService,
+		messageRepo:    messageRepo,
+		fetcher:        fetcher,
+	}
+}
+
+func (s *MessageService) SetMaxUploadSize(size int64) {
+	s.maxUploadSize = size
+}
+
+func (s *MessageService) SetLinkPreviewer(previewer *media.LinkPreviewer) {
+	s.previewer = previewer
+}
+
+func (s *MessageService) SetPollRepo(repo *repository.PollRepository) {
+	s.pollRepo = repo
 }
 
-func NewMessageService(sessionService *SessionService) *MessageService {
-	return &MessageService{sessionService: sessionService}
+// UploadLimit returns the largest file the user may upload, in bytes.
+func (s *MessageService) UploadLimit(user *model.User) int64 {
+	if user.MaxUploadMB > 0 {
+		return int64(user.MaxUploadMB) << 20
+	}
+	return s.maxUploadSize
 }
 
 func (s *MessageService) SendText(ctx context.Context, userID, sessionID string, req *model.TextMessage) (map[string]interface{}, error) {
@@ -42,15 +83,37 @@ func (s *MessageService) SendText(ctx context.Context, userID, sessionID string,
 		msgID = client.GenerateMessageID()
 	}
 
+	contextInfo, err := s.textContext(client, sessionID, recipient, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var extended *waE2E.ExtendedTextMessage
+	if req.LinkPreview {
+		extended = s.linkPreview(ctx, client, req.Message)
+	}
+	if contextInfo != nil {
+		if extended == nil {
+			extended = &waE2E.ExtendedTextMessage{}
+		}
+		extended.ContextInfo = contextInfo
+	}
+
 	msg := &waE2E.Message{
 		Conversation: proto.String(req.Message),
 	}
+	if extended != nil {
+		extended.Text = proto.String(req.Message)
+		msg = &waE2E.Message{ExtendedTextMessage: extended}
+	}
 
 	resp, err := client.SendMessage(ctx, recipient, msg, whatsmeow.SendRequestExtra{ID: msgID})
 	if err != nil {
 		return nil, fmt.Errorf("failed to send message: %w", err)
 	}
 
+	s.storeSent(client, userID, sessionID, recipient, msgID, resp, msg)
+
 	logger.Infof("Message sent: %s", msgID)
 
 	return map[string]interface{}{
@@ -60,7 +123,7 @@ func (s *MessageService) SendText(ctx context.Context, userID, sessionID string,
 	}, nil
 }
 
-func (s *MessageService) SendImage(ctx context.Context, userID, sessionID string, req *model.ImageMessage) (map[string]interface{}, error) {
+func (s *MessageService) SendImage(ctx context.Context, userID, sessionID string, req *model.ImageMessage, upload *media.Source) (map[string]interface{}, error) {
 	client := s.sessionService.GetWhatsmeowClient(userID, sessionID)
 	if client == nil {
 		return nil, errors.New("no session")
@@ -76,25 +139,20 @@ func (s *MessageService) SendImage(ctx context.Context, userID, sessionID string
 		msgID = client.GenerateMessageID()
 	}
 
-	var filedata []byte
-	if strings.HasPrefix(req.Image, "data:image") {
-		dataURL, err := dataurl.DecodeString(req.Image)
-		if err != nil {
-			return nil, errors.New("invalid base64 image data")
-		}
-		filedata = dataURL.Data
-	} else {
-		return nil, errors.New("image must be base64 encoded (data:image/...)")
+	src, err := s.openMedia(ctx, upload, req.Image, "image")
+	if err != nil {
+		return nil, err
 	}
+	defer src.Close()
 
-	uploaded, err := client.Upload(ctx, filedata, whatsmeow.MediaImage)
+	uploaded, err := client.UploadReader(ctx, src, nil, whatsmeow.MediaImage)
 	if err != nil {
 		return nil, fmt.Errorf("failed to upload image: %w", err)
 	}
 
 	mimeType := req.MimeType
 	if mimeType == "" {
-		mimeType = http.DetectContentType(filedata)
+		mimeType = src.MimeType
 	}
 
 	msg := &waE2E.Message{
@@ -106,7 +164,7 @@ func (s *MessageService) SendImage(ctx context.Context, userID, sessionID string
 			Mimetype:      proto.String(mimeType),
 			FileEncSHA256: uploaded.FileEncSHA256,
 			FileSHA256:    uploaded.FileSHA256,
-			FileLength:    proto.Uint64(uint64(len(filedata))),
+			FileLength:    proto.Uint64(uploaded.FileLength),
 		},
 	}
 
@@ -115,6 +173,8 @@ func (s *MessageService) SendImage(ctx context.Context, userID, sessionID string
 		return nil, fmt.Errorf("failed to send image: %w", err)
 	}
 
+	s.storeSent(client, userID, sessionID, recipient, msgID, resp, msg)
+
 	return map[string]interface{}{
 		"details":   "Sent",
 		"timestamp": resp.Timestamp.Unix(),
@@ -122,7 +182,7 @@ func (s *MessageService) SendImage(ctx context.Context, userID, sessionID string
 	}, nil
 }
 
-func (s *MessageService) SendAudio(ctx context.Context, userID, sessionID string, req *model.AudioMessage) (map[string]interface{}, error) {
+func (s *MessageService) SendAudio(ctx context.Context, userID, sessionID string, req *model.AudioMessage, upload *media.Source) (map[string]interface{}, error) {
 	client := s.sessionService.GetWhatsmeowClient(userID, sessionID)
 	if client == nil {
 		return nil, errors.New("no session")
@@ -138,18 +198,13 @@ func (s *MessageService) SendAudio(ctx context.Context, userID, sessionID string
 		msgID = client.GenerateMessageID()
 	}
 
-	var filedata []byte
-	if strings.HasPrefix(req.Audio, "data:audio") {
-		dataURL, err := dataurl.DecodeString(req.Audio)
-		if err != nil {
-			return nil, errors.New("invalid base64 audio data")
-		}
-		filedata = dataURL.Data
-	} else {
-		return nil, errors.New("audio must be base64 encoded (data:audio/...)")
+	src, err := s.openMedia(ctx, upload, req.Audio, "audio")
+	if err != nil {
+		return nil, err
 	}
+	defer src.Close()
 
-	uploaded, err := client.Upload(ctx, filedata, whatsmeow.MediaAudio)
+	uploaded, err := client.UploadReader(ctx, src, nil, whatsmeow.MediaAudio)
 	if err != nil {
 		return nil, fmt.Errorf("failed to upload audio: %w", err)
 	}
@@ -176,7 +231,7 @@ func (s *MessageService) SendAudio(ctx context.Context, userID, sessionID string
 			Mimetype:      proto.String(mimeType),
 			FileEncSHA256: uploaded.FileEncSHA256,
 			FileSHA256:    uploaded.FileSHA256,
-			FileLength:    proto.Uint64(uint64(len(filedata))),
+			FileLength:    proto.Uint64(uploaded.FileLength),
 			PTT:           proto.Bool(ptt),
 		},
 	}
@@ -186,6 +241,8 @@ func (s *MessageService) SendAudio(ctx context.Context, userID, sessionID string
 		return nil, fmt.Errorf("failed to send audio: %w", err)
 	}
 
+	s.storeSent(client, userID, sessionID, recipient, msgID, resp, msg)
+
 	return map[string]interface{}{
 		"details":   "Sent",
 		"timestamp": resp.Timestamp.Unix(),
@@ -193,7 +250,7 @@ func (s *MessageService) SendAudio(ctx context.Context, userID, sessionID string
 	}, nil
 }
 
-func (s *MessageService) SendVideo(ctx context.Context, userID, sessionID string, req *model.VideoMessage) (map[string]interface{}, error) {
+func (s *MessageService) SendVideo(ctx context.Context, userID, sessionID string, req *model.VideoMessage, upload *media.Source) (map[string]interface{}, error) {
 	client := s.sessionService.GetWhatsmeowClient(userID, sessionID)
 	if client == nil {
 		return nil, errors.New("no session")
@@ -209,25 +266,20 @@ func (s *MessageService) SendVideo(ctx context.Context, userID, sessionID string
 		msgID = client.GenerateMessageID()
 	}
 
-	var filedata []byte
-	if strings.HasPrefix(req.Video, "data:video") {
-		dataURL, err := dataurl.DecodeString(req.Video)
-		if err != nil {
-			return nil, errors.New("invalid base64 video data")
-		}
-		filedata = dataURL.Data
-	} else {
-		return nil, errors.New("video must be base64 encoded (data:video/...)")
+	src, err := s.openMedia(ctx, upload, req.Video, "video")
+	if err != nil {
+		return nil, err
 	}
+	defer src.Close()
 
-	uploaded, err := client.Upload(ctx, filedata, whatsmeow.MediaVideo)
+	uploaded, err := client.UploadReader(ctx, src, nil, whatsmeow.MediaVideo)
 	if err != nil {
 		return nil, fmt.Errorf("failed to upload video: %w", err)
 	}
 
 	mimeType := req.MimeType
 	if mimeType == "" {
-		mimeType = http.DetectContentType(filedata)
+		mimeType = src.MimeType
 	}
 
 	msg := &waE2E.Message{
@@ -239,7 +291,7 @@ func (s *MessageService) SendVideo(ctx context.Context, userID, sessionID string
 			Mimetype:      proto.String(mimeType),
 			FileEncSHA256: uploaded.FileEncSHA256,
 			FileSHA256:    uploaded.FileSHA256,
-			FileLength:    proto.Uint64(uint64(len(filedata))),
+			FileLength:    proto.Uint64(uploaded.FileLength),
 		},
 	}
 
@@ -248,6 +300,8 @@ func (s *MessageService) SendVideo(ctx context.Context, userID, sessionID string
 		return nil, fmt.Errorf("failed to send video: %w", err)
 	}
 
+	s.storeSent(client, userID, sessionID, recipient, msgID, resp, msg)
+
 	return map[string]interface{}{
 		"details":   "Sent",
 		"timestamp": resp.Timestamp.Unix(),
@@ -255,7 +309,7 @@ func (s *MessageService) SendVideo(ctx context.Context, userID, sessionID string
 	}, nil
 }
 
-func (s *MessageService) SendDocument(ctx context.Context, userID, sessionID string, req *model.DocumentMessage) (map[string]interface{}, error) {
+func (s *MessageService) SendDocument(ctx context.Context, userID, sessionID string, req *model.DocumentMessage, upload *media.Source) (map[string]interface{}, error) {
 	client := s.sessionService.GetWhatsmeowClient(userID, sessionID)
 	if client == nil {
 		return nil, errors.New("no session")
@@ -271,25 +325,20 @@ func (s *MessageService) SendDocument(ctx context.Context, userID, sessionID str
 		msgID = client.GenerateMessageID()
 	}
 
-	var filedata []byte
-	if strings.HasPrefix(req.Document, "data:") {
-		dataURL, err := dataurl.DecodeString(req.Document)
-		if err != nil {
-			return nil, errors.New("invalid base64 document data")
-		}
-		filedata = dataURL.Data
-	} else {
-		return nil, errors.New("document must be base64 encoded")
+	src, err := s.openMedia(ctx, upload, req.Document, "document")
+	if err != nil {
+		return nil, err
 	}
+	defer src.Close()
 
-	uploaded, err := client.Upload(ctx, filedata, whatsmeow.MediaDocument)
+	uploaded, err := client.UploadReader(ctx, src, nil, whatsmeow.MediaDocument)
 	if err != nil {
 		return nil, fmt.Errorf("failed to upload document: %w", err)
 	}
 
 	mimeType := req.MimeType
 	if mimeType == "" {
-		mimeType = http.DetectContentType(filedata)
+		mimeType = src.MimeType
 	}
 
 	msg := &waE2E.Message{
@@ -301,7 +350,7 @@ func (s *MessageService) SendDocument(ctx context.Context, userID, sessionID str
 			Mimetype:      proto.String(mimeType),
 			FileEncSHA256: uploaded.FileEncSHA256,
 			FileSHA256:    uploaded.FileSHA256,
-			FileLength:    proto.Uint64(uint64(len(filedata))),
+			FileLength:    proto.Uint64(uploaded.FileLength),
 			FileName:      proto.String(req.FileName),
 		},
 	}
@@ -311,6 +360,8 @@ func (s *MessageService) SendDocument(ctx context.Context, userID, sessionID str
 		return nil, fmt.Errorf("failed to send document: %w", err)
 	}
 
+	s.storeSent(client, userID, sessionID, recipient, msgID, resp, msg)
+
 	return map[string]interface{}{
 		"details":   "Sent",
 		"timestamp": resp.Timestamp.Unix(),
@@ -348,6 +399,8 @@ func (s *MessageService) SendLocation(ctx context.Context, userID, sessionID str
 		return nil, fmt.Errorf("failed to send location: %w", err)
 	}
 
+	s.storeSent(client, userID, sessionID, recipient, msgID, resp, msg)
+
 	return map[string]interface{}{
 		"details":   "Sent",
 		"timestamp": resp.Timestamp.Unix(),
@@ -383,6 +436,47 @@ func (s *MessageService) SendContact(ctx context.Context, userID, sessionID stri
 		return nil, fmt.Errorf("failed to send contact: %w", err)
 	}
 
+	s.storeSent(client, userID, sessionID, recipient, msgID, resp, msg)
+
+	return map[string]interface{}{
+		"details":   "Sent",
+		"timestamp": resp.Timestamp.Unix(),
+		"id":        msgID,
+	}, nil
+}
+
+// SendPoll sends a poll. A SelectableCount of 0 lets voters pick any number
+// of options.
+func (s *MessageService) SendPoll(ctx context.Context, userID, sessionID string, req *model.PollMessage) (map[string]interface{}, error) {
+	client := s.sessionService.GetWhatsmeowClient(userID, sessionID)
+	if client == nil {
+		return nil, errors.New("no session")
+	}
+
+	recipient, err := parseJID(req.Phone)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validatePoll(req); err != nil {
+		return nil, err
+	}
+
+	msgID := req.ID
+	if msgID == "" {
+		msgID = client.GenerateMessageID()
+	}
+
+	msg := client.BuildPollCreation(req.Question, req.Options, req.SelectableCount)
+	resp, err := client.SendMessage(ctx, recipient, msg, whatsmeow.SendRequestExtra{ID: msgID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to send poll: %w", err)
+	}
+
+	s.storeSent(client, userID, sessionID, recipient, msgID, resp, msg)
+
+	logger.Infof("Poll sent: %s", msgID)
+
 	return map[string]interface{}{
 		"details":   "Sent",
 		"timestamp": resp.Timestamp.Unix(),
@@ -390,6 +484,30 @@ func (s *MessageService) SendContact(ctx context.Context, userID, sessionID stri
 	}, nil
 }
 
+// validatePoll applies the limits of the WhatsApp clients, which reject
+// polls with blank or repeated options.
+func validatePoll(req *model.PollMessage) error {
+	if len(req.Options) < 2 || len(req.Options) > maxPollOptions {
+		return fmt.Errorf("%w: a poll needs between 2 and %d options", ErrInvalidMessage, maxPollOptions)
+	}
+
+	seen := make(map[string]bool, len(req.Options))
+	for _, option := range req.Options {
+		if strings.TrimSpace(option) == "" {
+			return fmt.Errorf("%w: poll options must not be empty", ErrInvalidMessage)
+		}
+		if seen[option] {
+			return fmt.Errorf("%w: duplicate poll option %q", ErrInvalidMessage, option)
+		}
+		seen[option] = true
+	}
+
+	if req.SelectableCount < 0 || req.SelectableCount > len(req.Options) {
+		return fmt.Errorf("%w: selectableCount must be between 0 and the number of options", ErrInvalidMessage)
+	}
+	return nil
+}
+
 func (s *MessageService) React(ctx context.Context, userID, sessionID string, req *model.ReactionMessage) (map[string]interface{}, error) {
 	client := s.sessionService.GetWhatsmeowClient(userID, sessionID)
 	if client == nil {
@@ -445,6 +563,254 @@ func (s *MessageService) Delete(ctx context.Context, userID, sessionID string, r
 	}, nil
 }
 
+// Edit replaces the text or caption of a message sent by the session and
+// records the new text as a revision of the stored message. Captions can only
+// be edited on stored messages, whose type tells which media message to send.
+func (s *MessageService) Edit(ctx context.Context, userID, sessionID string, req *model.EditMessage) (map[string]interface{}, error) {
+	client := s.sessionService.GetWhatsmeowClient(userID, sessionID)
+	if client == nil {
+		return nil, errors.New("no session")
+	}
+
+	recipient, err := parseJID(req.Phone)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := s.messageRepo.GetByID(sessionID, req.MessageID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("failed to look up message: %w", err)
+	}
+	if stored != nil && !stored.FromMe {
+		return nil, fmt.Errorf("%w: only messages sent by the session can be edited", ErrInvalidMessage)
+	}
+
+	content := &waE2E.Message{Conversation: proto.String(req.Text)}
+	text := req.Text
+	if req.Caption != "" {
+		if content, err = captionEdit(stored, req); err != nil {
+			return nil, err
+		}
+		text = req.Caption
+	}
+
+	msg := client.BuildEdit(recipient, types.MessageID(req.MessageID), content)
+	resp, err := client.SendMessage(ctx, recipient, msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to edit message: %w", err)
+	}
+
+	s.sessionService.StoreEdit(sessionID, &repository.MessageEdit{
+		MessageID: req.MessageID,
+		ChatJID:   recipient.String(),
+		SenderJID: client.Store.GetJID().ToNonAD().String(),
+		FromMe:    true,
+		Text:      text,
+		EditedAt:  resp.Timestamp,
+	})
+
+	logger.Infof("Message edited: %s", req.MessageID)
+
+	return map[string]interface{}{
+		"details":   "Edited",
+		"timestamp": resp.Timestamp.Unix(),
+		"id":        req.MessageID,
+	}, nil
+}
+
+// captionEdit builds the new content of a media message from its stored type.
+func captionEdit(stored *repository.Message, req *model.EditMessage) (*waE2E.Message, error) {
+	if stored == nil {
+		return nil, fmt.Errorf("%w: message %s is not stored, its caption can't be edited", ErrInvalidMessage, req.MessageID)
+	}
+
+	caption := proto.String(req.Caption)
+	switch stored.MessageType {
+	case "image":
+		return &waE2E.Message{ImageMessage: &waE2E.ImageMessage{Caption: caption}}, nil
+	case "video":
+		return &waE2E.Message{VideoMessage: &waE2E.VideoMessage{Caption: caption}}, nil
+	case "document":
+		return &waE2E.Message{DocumentMessage: &waE2E.DocumentMessage{Caption: caption}}, nil
+	default:
+		return nil, fmt.Errorf("%w: a %s message has no caption", ErrInvalidMessage, stored.MessageType)
+	}
+}
+
+// linkPreview builds a text message with the preview of the first link of
+// text. Previews are best effort: it returns nil when there is no link or the
+// preview can't be made.
+func (s *MessageService) linkPreview(ctx context.Context, client *whatsmeow.Client, text string) *waE2E.ExtendedTextMessage {
+	if s.previewer == nil {
+		return nil
+	}
+
+	preview, err := s.previewer.Preview(ctx, text)
+	if err != nil {
+		logger.Warnf("Failed to build link preview: %v", err)
+		return nil
+	}
+	if preview == nil {
+		return nil
+	}
+
+	msg := &waE2E.ExtendedTextMessage{
+		MatchedText:   proto.String(preview.MatchedText),
+		Title:         proto.String(preview.Title),
+		Description:   proto.String(preview.Description),
+		PreviewType:   waE2E.ExtendedTextMessage_NONE.Enum(),
+		JPEGThumbnail: preview.Thumbnail,
+	}
+
+	if preview.Image != nil {
+		uploaded, err := client.Upload(ctx, preview.Image, whatsmeow.MediaLinkThumbnail)
+		if err != nil {
+			logger.Warnf("Failed to upload link preview image: %v", err)
+			return msg
+		}
+		msg.ThumbnailDirectPath = proto.String(uploaded.DirectPath)
+		msg.ThumbnailSHA256 = uploaded.FileSHA256
+		msg.ThumbnailEncSHA256 = uploaded.FileEncSHA256
+		msg.MediaKey = uploaded.MediaKey
+		msg.MediaKeyTimestamp = proto.Int64(time.Now().Unix())
+		msg.ThumbnailWidth = proto.Uint32(preview.ImageWidth)
+		msg.ThumbnailHeight = proto.Uint32(preview.ImageHeight)
+	}
+
+	return msg
+}
+
+// textContext builds the reply and mentions of a text message, or returns nil
+// when it has neither.
+func (s *MessageService) textContext(client *whatsmeow.Client, sessionID string, recipient types.JID, req *model.TextMessage) (*waE2E.ContextInfo, error) {
+	mentions, err := parseMentions(req.Mentions, req.Message)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.QuotedMessageID == "" && len(mentions) == 0 {
+		return nil, nil
+	}
+
+	contextInfo := &waE2E.ContextInfo{MentionedJID: mentions}
+	if req.QuotedMessageID == "" {
+		return contextInfo, nil
+	}
+
+	sender, text, messageType := req.QuotedSender, req.QuotedText, "text"
+	if sender == "" || text == "" {
+		if stored, err := s.messageRepo.GetByID(sessionID, req.QuotedMessageID); err == nil {
+			if sender == "" {
+				sender = stored.SenderJID
+				if stored.FromMe {
+					sender = client.Store.GetJID().String()
+				}
+			}
+			if text == "" && stored.TextContent != nil {
+				text = *stored.TextContent
+			}
+			messageType = stored.MessageType
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("failed to look up quoted message: %w", err)
+		}
+	}
+
+	// in a direct chat an unknown message is most likely the contact's
+	if sender == "" {
+		if recipient.Server == types.GroupServer {
+			return nil, fmt.Errorf("%w: quoted message %s not found, pass quotedSender", ErrInvalidMessage, req.QuotedMessageID)
+		}
+		sender = recipient.String()
+	}
+
+	participant, err := parseJID(sender)
+	if err != nil {
+		return nil, fmt.Errorf("%w: quotedSender: %v", ErrInvalidMessage, err)
+	}
+
+	contextInfo.StanzaID = proto.String(req.QuotedMessageID)
+	contextInfo.Participant = proto.String(participant.ToNonAD().String())
+	contextInfo.QuotedMessage = quotedMessage(messageType, text)
+	return contextInfo, nil
+}
+
+// quotedMessage rebuilds the quoted copy of a message from its stored type
+// and text or caption, so media replies show as such. The media itself is
+// not quoted.
+func quotedMessage(messageType, text string) *waE2E.Message {
+	var caption *string
+	if text != "" {
+		caption = proto.String(text)
+	}
+
+	switch messageType {
+	case "image":
+		return &waE2E.Message{ImageMessage: &waE2E.ImageMessage{Caption: caption}}
+	case "video":
+		return &waE2E.Message{VideoMessage: &waE2E.VideoMessage{Caption: caption}}
+	case "document":
+		return &waE2E.Message{DocumentMessage: &waE2E.DocumentMessage{Caption: caption}}
+	case "audio":
+		return &waE2E.Message{AudioMessage: &waE2E.AudioMessage{}}
+	case "sticker":
+		return &waE2E.Message{StickerMessage: &waE2E.StickerMessage{}}
+	case "location":
+		return &waE2E.Message{LocationMessage: &waE2E.LocationMessage{}}
+	case "contact":
+		return &waE2E.Message{ContactMessage: &waE2E.ContactMessage{}}
+	default:
+		return &waE2E.Message{Conversation: proto.String(text)}
+	}
+}
+
+// parseMentions returns the JIDs to mention: the given phone numbers or JIDs,
+// or else the @number tokens found in text.
+func parseMentions(mentions []string, text string) ([]string, error) {
+	if len(mentions) == 0 {
+		for _, match := range mentionPattern.FindAllStringSubmatch(text, -1) {
+			mentions = append(mentions, match[1])
+		}
+	}
+
+	seen := make(map[string]bool)
+	var jids []string
+	for _, mention := range mentions {
+		jid, err := parseJID(mention)
+		if err != nil {
+			return nil, fmt.Errorf("%w: mention %q: %v", ErrInvalidMessage, mention, err)
+		}
+		value := jid.ToNonAD().String()
+		if !seen[value] {
+			seen[value] = true
+			jids = append(jids, value)
+		}
+	}
+	return jids, nil
+}
+
+// openMedia returns the uploaded file when there is one, otherwise it resolves
+// the data URL or remote URL given in the JSON request.
+func (s *MessageService) openMedia(ctx context.Context, upload *media.Source, value, kind string) (*media.Source, error) {
+	if upload != nil {
+		return upload, nil
+	}
+	return s.fetcher.Open(ctx, value, kind)
+}
+
+func (s *MessageService) storeSent(client *whatsmeow.Client, userID, sessionID string, recipient types.JID, msgID string, resp whatsmeow.SendResponse, msg *waE2E.Message) {
+	info := &types.MessageInfo{
+		MessageSource: types.MessageSource{
+			Chat:     recipient,
+			Sender:   client.Store.GetJID(),
+			IsFromMe: true,
+			IsGroup:  recipient.Server == types.GroupServer,
+		},
+		ID:        msgID,
+		Timestamp: resp.Timestamp,
+	}
+	s.sessionService.StoreMessage(userID, sessionID, info, msg)
+}
+
 func parseJID(phone string) (types.JID, error) {
 	if phone == "" {
 		return types.JID{}, errors.New("phone is required")
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"fiozap/internal/model"
+	"fiozap/internal/wameow"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		name     string
+		mentions []string
+		text     string
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name: "tokens in text",
+			text: "hi @5511999990000 and @5511888880000",
+			want: []string{"5511999990000@s.whatsapp.net", "5511888880000@s.whatsapp.net"},
+		},
+		{
+			name: "token at start",
+			text: "@5511999990000 hi",
+			want: []string{"5511999990000@s.whatsapp.net"},
+		},
+		{
+			name: "emails and short numbers are not mentions",
+			text: "mail me at user@5511999990000.com or call @123",
+			want: nil,
+		},
+		{
+			name: "duplicates",
+			text: "@5511999990000 @5511999990000",
+			want: []string{"5511999990000@s.whatsapp.net"},
+		},
+		{
+			name:     "explicit mentions take precedence",
+			mentions: []string{"+5511777770000", "5511666660000@s.whatsapp.net"},
+			text:     "hi @5511999990000",
+			want:     []string{"5511777770000@s.whatsapp.net", "5511666660000@s.whatsapp.net"},
+		},
+		{
+			name:     "device suffix dropped",
+			mentions: []string{"5511777770000:3@s.whatsapp.net", "5511777770000@s.whatsapp.net"},
+			want:     []string{"5511777770000@s.whatsapp.net"},
+		},
+		{
+			name:     "invalid JID",
+			mentions: []string{"5511777770000:x@s.whatsapp.net"},
+			wantErr:  true,
+		},
+		{
+			name: "no mentions",
+			text: "hello",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMentions(tt.mentions, tt.text)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidMessage) {
+					t.Fatalf("parseMentions() error = %v, want ErrInvalidMessage", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseMentions() error = %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("parseMentions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuotedMessage(t *testing.T) {
+	tests := []struct {
+		messageType string
+		text        string
+		want        string
+		wantText    string
+	}{
+		{"text", "hello", "text", "hello"},
+		{"image", "a photo", "image", "a photo"},
+		{"video", "", "video", ""},
+		{"document", "report", "document", "report"},
+		{"audio", "", "audio", ""},
+		{"sticker", "", "sticker", ""},
+		{"unknown", "hi", "text", "hi"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.messageType, func(t *testing.T) {
+			got := quotedMessage(tt.messageType, tt.text)
+			if messageType := wameow.GetMessageType(got); messageType != tt.want {
+				t.Errorf("quotedMessage() type = %s, want %s", messageType, tt.want)
+			}
+			if text := wameow.GetMessageText(got); text != tt.wantText {
+				t.Errorf("quotedMessage() text = %q, want %q", text, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestValidatePoll(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     model.PollMessage
+		wantErr bool
+	}{
+		{"valid", model.PollMessage{Options: []string{"Yes", "No"}}, false},
+		{"single choice", model.PollMessage{Options: []string{"A", "B", "C"}, SelectableCount: 1}, false},
+		{"all selectable", model.PollMessage{Options: []string{"A", "B"}, SelectableCount: 2}, false},
+		{"one option", model.PollMessage{Options: []string{"Yes"}}, true},
+		{"too many options", model.PollMessage{Options: []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12", "13"}}, true},
+		{"blank option", model.PollMessage{Options: []string{"Yes", " "}}, true},
+		{"duplicate option", model.PollMessage{Options: []string{"Yes", "Yes"}}, true},
+		{"negative selectable count", model.PollMessage{Options: []string{"A", "B"}, SelectableCount: -1}, true},
+		{"selectable count above options", model.PollMessage{Options: []string{"A", "B"}, SelectableCount: 3}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePoll(&tt.req)
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("validatePoll() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidMessage) {
+				t.Errorf("validatePoll() error = %v, want ErrInvalidMessage", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+
+	"fiozap/internal/database/repository"
+	"fiozap/internal/logger"
+	"fiozap/internal/wameow"
+)
+
+// PollOptionResult is the tally of one poll option.
+type PollOptionResult struct {
+	Name   string   `json:"name"`
+	Votes  int      `json:"votes"`
+	Voters []string `json:"voters"`
+}
+
+// GetPollResults tallies the latest vote of each voter on a stored poll.
+func (s *MessageService) GetPollResults(sessionID, messageID string) (map[string]interface{}, error) {
+	poll, err := s.pollRepo.GetByMessageID(sessionID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	votes, err := s.pollRepo.GetVotes(sessionID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	options := make([]PollOptionResult, len(poll.Options))
+	index := make(map[string]int, len(poll.Options))
+	for i, name := range poll.Options {
+		options[i] = PollOptionResult{Name: name, Voters: []string{}}
+		index[wameow.HashPollOption(name)] = i
+	}
+
+	voters := 0
+	for _, vote := range votes {
+		counted := false
+		for _, hash := range vote.SelectedHashes {
+			if i, ok := index[hash]; ok {
+				options[i].Votes++
+				options[i].Voters = append(options[i].Voters, vote.VoterJID)
+				counted = true
+			}
+		}
+		if counted {
+			voters++
+		}
+	}
+
+	return map[string]interface{}{
+		"messageId":       poll.MessageID,
+		"chatJid":         poll.ChatJID,
+		"senderJid":       poll.SenderJID,
+		"question":        poll.Question,
+		"selectableCount": poll.SelectableCount,
+		"createdAt":       poll.CreatedAt,
+		"options":         options,
+		"totalVoters":     voters,
+	}, nil
+}
+
+// storePoll records a sent or received poll so its votes can be tallied. The
+// caller checks that the session stores messages.
+func (s *SessionService) storePoll(userID, sessionID string, info *types.MessageInfo, msg *waE2E.Message) {
+	poll := wameow.GetPollCreation(msg)
+	if poll == nil || s.pollRepo == nil {
+		return
+	}
+
+	record := repository.Poll{
+		UserID:          userID,
+		SessionID:       sessionID,
+		ChatJID:         info.Chat.String(),
+		SenderJID:       info.Sender.ToNonAD().String(),
+		MessageID:       info.ID,
+		Question:        poll.GetName(),
+		Options:         wameow.GetPollOptions(poll),
+		SelectableCount: int(poll.GetSelectableOptionsCount()),
+		CreatedAt:       info.Timestamp.UTC(),
+	}
+	if err := s.pollRepo.Create(&record); err != nil {
+		logger.Warnf("Failed to store poll %s: %v", info.ID, err)
+	}
+}
+
+// storePollVote records a vote when the session stores messages and, when
+// the poll is stored, completes the event with its question and the names of
+// the selected options.
+func (s *SessionService) storePollVote(sessionID string, vote *wameow.PollVoteEvent) {
+	if s.pollRepo == nil {
+		return
+	}
+
+	storeMessages, err := s.storesMessages(sessionID)
+	if err != nil {
+		logger.Warnf("Failed to load session %s for message storage: %v", sessionID, err)
+		return
+	}
+
+	if storeMessages {
+		voter := vote.From
+		if jid, err := types.ParseJID(vote.From); err == nil {
+			voter = jid.ToNonAD().String()
+		}
+
+		err := s.pollRepo.SaveVote(sessionID, vote.PollID, &repository.PollVote{
+			VoterJID:       voter,
+			SelectedHashes: vote.SelectedHashes,
+			VotedAt:        time.Unix(vote.Timestamp, 0),
+		})
+		if err != nil {
+			logger.Warnf("Failed to store vote %s on poll %s: %v", vote.ID, vote.PollID, err)
+		}
+	}
+
+	poll, err := s.pollRepo.GetByMessageID(sessionID, vote.PollID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		logger.Warnf("Failed to load poll %s: %v", vote.PollID, err)
+		return
+	}
+
+	names := make(map[string]string, len(poll.Options))
+	for _, name := range poll.Options {
+		names[wameow.HashPollOption(name)] = name
+	}
+
+	vote.Question = poll.Question
+	for _, hash := range vote.SelectedHashes {
+		if name, ok := names[hash]; ok {
+			vote.SelectedOptions = append(vote.SelectedOptions, name)
+		}
+	}
+}
@@ -4,26 +4,59 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/url"
+	"strings"
 	"sync"
+	"time"
 
 	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
 
 	"fiozap/internal/config"
 	"fiozap/internal/database/repository"
 	"fiozap/internal/logger"
+	"fiozap/internal/media"
 	"fiozap/internal/model"
+	"fiozap/internal/storage"
 	"fiozap/internal/wameow"
 	"fiozap/internal/webhook"
 )
 
+// storeSettingsTTL bounds how long a session's message storage setting is
+// cached, and so how long other instances take to pick up a change.
+const storeSettingsTTL = time.Minute
+
 type SessionService struct {
 	userRepo    *repository.UserRepository
 	sessionRepo *repository.SessionRepository
 	webhookRepo *repository.WebhookRepository
+	messageRepo *repository.MessageRepository
+	chatRepo    *repository.ChatRepository
+	mediaRepo   *repository.MediaRepository
+	pollRepo    *repository.PollRepository
 	clients     map[string]*wameow.Client // key: "userId:sessionId"
 	mu          sync.RWMutex
 	dbConnStr   string
+	container   *sqlstore.Container
 	dispatcher  *webhook.Dispatcher
+
+	mediaStorage storage.Storage
+	mediaMaxSize int64
+	mediaJobs    chan *mediaJob
+	mediaSigner  *media.URLSigner
+
+	// storeSettings caches whether each session stores messages, which is
+	// checked for every message
+	storeSettings   map[string]storeSetting
+	storeSettingsMu sync.Mutex
+}
+
+type storeSetting struct {
+	storeMessages bool
+	expiresAt     time.Time
 }
 
 func NewSessionService(userRepo *repository.UserRepository, sessionRepo *repository.SessionRepository, cfg *config.Config) *SessionService {
@@ -35,6 +68,8 @@ func NewSessionService(userRepo *repository.UserRepository, sessionRepo *reposit
 		sessionRepo: sessionRepo,
 		clients:     make(map[string]*wameow.Client),
 		dbConnStr:   connStr,
+
+		storeSettings: make(map[string]storeSetting),
 	}
 }
 
@@ -42,10 +77,58 @@ func (s *SessionService) clientKey(userID, sessionID string) string {
 	return fmt.Sprintf("%s:%s", userID, sessionID)
 }
 
+// getContainer lazily opens the whatsmeow device store shared by all sessions.
+// Callers must hold s.mu.
+func (s *SessionService) getContainer(ctx context.Context) (*sqlstore.Container, error) {
+	if s.container != nil {
+		return s.container, nil
+	}
+
+	container, err := wameow.NewContainer(ctx, s.dbConnStr)
+	if err != nil {
+		return nil, err
+	}
+
+	s.container = container
+	return container, nil
+}
+
 func (s *SessionService) SetWebhookRepo(repo *repository.WebhookRepository) {
 	s.webhookRepo = repo
 }
 
+func (s *SessionService) SetMessageRepo(repo *repository.MessageRepository) {
+	s.messageRepo = repo
+}
+
+func (s *SessionService) SetChatRepo(repo *repository.ChatRepository) {
+	s.chatRepo = repo
+}
+
+func (s *SessionService) SetMediaRepo(repo *repository.MediaRepository) {
+	s.mediaRepo = repo
+}
+
+func (s *SessionService) SetPollRepo(repo *repository.PollRepository) {
+	s.pollRepo = repo
+}
+
+// SetMediaStorage enables downloading incoming media into store in the
+// background. Media larger than maxSize bytes is skipped; 0 means no limit.
+func (s *SessionService) SetMediaStorage(store storage.Storage, maxSize int64) {
+	s.mediaStorage = store
+	s.mediaMaxSize = maxSize
+	if store != nil && s.mediaJobs == nil {
+		s.startMediaWorkers()
+	}
+}
+
+// SetMediaSigner makes Message events carry signed download links instead of
+// the storage URL.
+func (s *SessionService) SetMediaSigner(signer *media.URLSigner) {
+	s.mediaSigner = signer
+}
+
 func (s *SessionService) SetDispatcher(d *webhook.Dispatcher) {
 	s.dispatcher = d
 }
@@ -87,19 +170,42 @@ func (s *SessionService) GetAllSessions() ([]model.Session, error) {
 }
 
 func (s *SessionService) UpdateSession(sessionID string, req *model.SessionUpdateRequest) (*model.Session, error) {
-	return s.sessionRepo.Update(sessionID, req)
+	session, err := s.sessionRepo.Update(sessionID, req)
+	s.forgetStoreSetting(sessionID)
+	return session, err
 }
 
-func (s *SessionService) DeleteSession(userID, sessionID string) error {
+func (s *SessionService) DeleteSession(ctx context.Context, userID, sessionID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	session, err := s.sessionRepo.GetByID(sessionID)
+	if err != nil {
+		return err
+	}
+
 	key := s.clientKey(userID, sessionID)
 	if client, exists := s.clients[key]; exists {
+		if client.IsConnected() && client.IsLoggedIn() {
+			if err := client.GetClient().Logout(ctx); err != nil {
+				logger.Warnf("Failed to logout session %s: %v", sessionID, err)
+			}
+		}
 		client.Disconnect()
 		delete(s.clients, key)
 	}
 
+	if session.DeviceJID != "" {
+		container, err := s.getContainer(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to open device store: %w", err)
+		}
+		if err := wameow.DeleteDevice(ctx, container, session.DeviceJID); err != nil {
+			return fmt.Errorf("failed to delete device: %w", err)
+		}
+	}
+
+	s.forgetStoreSetting(sessionID)
 	return s.sessionRepo.Delete(sessionID)
 }
 
@@ -120,15 +226,52 @@ func (s *SessionService) Connect(ctx context.Context, userID string, session *mo
 		}
 	}
 
-	client, err := wameow.NewClient(ctx, s.dbConnStr, session.ID)
+	container, err := s.getContainer(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open device store: %w", err)
+	}
+
+	client, err := wameow.NewClient(ctx, container, session.DeviceJID, session.ID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create client: %w", err)
 	}
 
+	if err := client.SetProxy(session.ProxyURL); err != nil {
+		return nil, err
+	}
+
 	client.SetEventCallback(func(eventType string, data interface{}) {
 		s.handleEvent(userID, session.ID, eventType, data)
 	})
 
+	client.SetMessageCallback(func(evt *events.Message, payload *wameow.MessageEvent) {
+		s.storeMessage(userID, session.ID, &evt.Info, evt.Message, "")
+		s.queueIncomingMedia(userID, session.ID, client, evt, payload)
+	})
+
+	client.SetEditCallback(func(edit *wameow.MessageEditedEvent) bool {
+		sender := edit.From
+		if jid, err := types.ParseJID(edit.From); err == nil {
+			sender = jid.ToNonAD().String()
+		}
+		return s.StoreEdit(session.ID, &repository.MessageEdit{
+			MessageID: edit.TargetID,
+			ChatJID:   edit.Chat,
+			SenderJID: sender,
+			FromMe:    edit.IsFromMe,
+			Text:      edit.Text,
+			EditedAt:  time.Unix(edit.EditedAt, 0),
+		})
+	})
+
+	client.SetPollVoteCallback(func(vote *wameow.PollVoteEvent) {
+		s.storePollVote(session.ID, vote)
+	})
+
+	client.SetHistorySyncCallback(func(evt *events.HistorySync) {
+		s.ingestHistorySync(userID, session.ID, client, evt)
+	})
+
 	client.SetQRCallback(func(code string) {
 		if err := s.sessionRepo.UpdateQRCode(session.ID, code); err != nil {
 			logger.Warnf("Failed to update QR code: %v", err)
@@ -150,6 +293,9 @@ func (s *SessionService) Connect(ctx context.Context, userID string, session *mo
 		if err := s.sessionRepo.UpdateJID(session.ID, jid.String()); err != nil {
 			logger.Warnf("Failed to update JID: %v", err)
 		}
+		if err := s.sessionRepo.UpdateDeviceJID(session.ID, jid.String()); err != nil {
+			logger.Warnf("Failed to update device JID: %v", err)
+		}
 	}
 
 	return map[string]interface{}{
@@ -169,11 +315,12 @@ func (s *SessionService) handleEvent(userID, sessionID, eventType string, data i
 	}
 
 	if eventType == "Connected" {
-		if dataMap, ok := data.(map[string]interface{}); ok {
-			if jid, ok := dataMap["jid"].(string); ok {
-				if err := s.sessionRepo.UpdateJID(sessionID, jid); err != nil {
-					logger.Warnf("Failed to update JID: %v", err)
-				}
+		if connected, ok := data.(*wameow.ConnectedEvent); ok {
+			if err := s.sessionRepo.UpdateJID(sessionID, connected.JID); err != nil {
+				logger.Warnf("Failed to update JID: %v", err)
+			}
+			if err := s.sessionRepo.UpdateDeviceJID(sessionID, connected.JID); err != nil {
+				logger.Warnf("Failed to update device JID: %v", err)
 			}
 		}
 	}
@@ -183,6 +330,137 @@ func (s *SessionService) handleEvent(userID, sessionID, eventType string, data i
 			logger.Warnf("Failed to update connected status: %v", err)
 		}
 	}
+
+	// whatsmeow deletes the device keys itself when the phone unlinks us
+	if eventType == "LoggedOut" {
+		if err := s.sessionRepo.UpdateDeviceJID(sessionID, ""); err != nil {
+			logger.Warnf("Failed to clear device JID: %v", err)
+		}
+	}
+}
+
+// StoreMessage records a sent or received message in fzMessage, and polls in
+// fzPoll, when the session has message storage enabled.
+func (s *SessionService) StoreMessage(userID, sessionID string, info *types.MessageInfo, msg *waE2E.Message) {
+	s.storeMessage(userID, sessionID, info, msg, "")
+}
+
+// storeMessage records the message with mediaLink pointing at our copy of
+// the media, falling back to the WhatsApp CDN URL when there is none.
+func (s *SessionService) storeMessage(userID, sessionID string, info *types.MessageInfo, msg *waE2E.Message, mediaLink string) {
+	if s.messageRepo == nil && s.pollRepo == nil {
+		return
+	}
+
+	storeMessages, err := s.storesMessages(sessionID)
+	if err != nil {
+		logger.Warnf("Failed to load session %s for message storage: %v", sessionID, err)
+		return
+	}
+
+	if !storeMessages {
+		return
+	}
+
+	s.storePoll(userID, sessionID, info, msg)
+	if s.messageRepo == nil {
+		return
+	}
+
+	record := newMessageRecord(userID, sessionID, info, msg)
+	if mediaLink != "" {
+		record.MediaLink = &mediaLink
+	}
+	if err := s.messageRepo.Create(&record); err != nil {
+		logger.Warnf("Failed to store message %s: %v", info.ID, err)
+	}
+}
+
+// StoreEdit records the new text of an edited message as its next revision
+// when the session has message storage enabled. It returns false when the
+// edit does not come from the chat and sender of the stored message, and
+// should be dropped.
+func (s *SessionService) StoreEdit(sessionID string, edit *repository.MessageEdit) bool {
+	if s.messageRepo == nil {
+		return true
+	}
+
+	storeMessages, err := s.storesMessages(sessionID)
+	if err != nil {
+		logger.Warnf("Failed to load session %s for message storage: %v", sessionID, err)
+		return true
+	}
+
+	if !storeMessages {
+		return true
+	}
+
+	stored, err := s.messageRepo.AddRevision(sessionID, edit)
+	if errors.Is(err, repository.ErrEditNotAllowed) {
+		logger.Warnf("Dropping edit of message %s in %s from %s: %v", edit.MessageID, edit.ChatJID, edit.SenderJID, err)
+		return false
+	}
+	if err != nil {
+		logger.Warnf("Failed to store edit of message %s: %v", edit.MessageID, err)
+		return true
+	}
+	if !stored {
+		logger.Debugf("Edited message %s is not stored, skipping revision", edit.MessageID)
+	}
+	return true
+}
+
+func newMessageRecord(userID, sessionID string, info *types.MessageInfo, msg *waE2E.Message) repository.Message {
+	return repository.Message{
+		UserID:          userID,
+		SessionID:       sessionID,
+		ChatJID:         info.Chat.String(),
+		SenderJID:       info.Sender.ToNonAD().String(),
+		MessageID:       info.ID,
+		Timestamp:       info.Timestamp.UTC(),
+		MessageType:     wameow.GetMessageType(msg),
+		FromMe:          info.IsFromMe,
+		TextContent:     nullString(wameow.GetMessageText(msg)),
+		MediaLink:       nullString(wameow.GetMediaURL(msg)),
+		QuotedMessageID: nullString(wameow.GetQuotedMessageID(msg)),
+	}
+}
+
+// storesMessages reports whether the session has message storage enabled,
+// from a cache that UpdateSession refreshes.
+func (s *SessionService) storesMessages(sessionID string) (bool, error) {
+	s.storeSettingsMu.Lock()
+	setting, ok := s.storeSettings[sessionID]
+	s.storeSettingsMu.Unlock()
+	if ok && time.Now().Before(setting.expiresAt) {
+		return setting.storeMessages, nil
+	}
+
+	session, err := s.sessionRepo.GetByID(sessionID)
+	if err != nil {
+		return false, err
+	}
+
+	s.storeSettingsMu.Lock()
+	s.storeSettings[sessionID] = storeSetting{
+		storeMessages: session.StoreMessages,
+		expiresAt:     time.Now().Add(storeSettingsTTL),
+	}
+	s.storeSettingsMu.Unlock()
+	return session.StoreMessages, nil
+}
+
+func (s *SessionService) forgetStoreSetting(sessionID string) {
+	s.storeSettingsMu.Lock()
+	delete(s.storeSettings, sessionID)
+	s.storeSettingsMu.Unlock()
+}
+
+func nullString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
 }
 
 func (s *SessionService) Disconnect(userID string, session *model.Session) error {
@@ -238,6 +516,10 @@ func (s *SessionService) Logout(ctx context.Context, userID string, session *mod
 		logger.Warnf("Failed to clear JID: %v", err)
 	}
 
+	if err := s.sessionRepo.UpdateDeviceJID(session.ID, ""); err != nil {
+		logger.Warnf("Failed to clear device JID: %v", err)
+	}
+
 	return nil
 }
 
@@ -248,10 +530,12 @@ func (s *SessionService) GetStatus(userID string, session *model.Session) map[st
 	key := s.clientKey(userID, session.ID)
 	isConnected := false
 	isLoggedIn := false
+	proxy := ""
 
 	if client, exists := s.clients[key]; exists {
 		isConnected = client.IsConnected()
 		isLoggedIn = client.IsLoggedIn()
+		proxy = client.GetProxy()
 	}
 
 	return map[string]interface{}{
@@ -262,6 +546,7 @@ func (s *SessionService) GetStatus(userID string, session *model.Session) map[st
 		"jid":       session.JID,
 		"webhook":   session.Webhook,
 		"events":    session.Events,
+		"proxy":     proxy,
 	}
 }
 
@@ -353,3 +638,51 @@ func (s *SessionService) GetWhatsmeowClient(userID, sessionID string) *whatsmeow
 	}
 	return nil
 }
+
+// ValidateSearchLanguage checks that language names a PostgreSQL text search
+// configuration (e.g. portuguese, english, simple).
+func (s *SessionService) ValidateSearchLanguage(language string) error {
+	if language == "" {
+		return nil
+	}
+
+	exists, err := s.sessionRepo.SearchLanguageExists(language)
+	if err != nil {
+		return fmt.Errorf("failed to check search language: %w", err)
+	}
+
+	if !exists {
+		return fmt.Errorf("unsupported search language %q", language)
+	}
+
+	return nil
+}
+
+// ValidateProxyURL checks that a session proxy is something whatsmeow can use.
+// An empty string means no proxy.
+func ValidateProxyURL(proxyURL string) error {
+	if proxyURL == "" {
+		return nil
+	}
+
+	parsed, err := url.Parse(proxyURL)
+	if err != nil {
+		return errors.New("invalid proxy URL")
+	}
+
+	switch strings.ToLower(parsed.Scheme) {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("unsupported proxy scheme %q (use http, https or socks5)", parsed.Scheme)
+	}
+
+	if parsed.Hostname() == "" {
+		return errors.New("proxy URL must include a host")
+	}
+
+	if port := parsed.Port(); port == "" {
+		return errors.New("proxy URL must include a port")
+	}
+
+	return nil
+}
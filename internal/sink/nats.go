@@ -0,0 +1,96 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+type NATSConfig struct {
+	URL string
+	// SubjectPrefix is the first token of the subjects events are published
+	// to: <prefix>.<userId>.<sessionId>.<eventType>
+	SubjectPrefix string
+	// Stream is created, or updated, to capture <prefix>.> when CreateStream
+	// is set. Otherwise a stream capturing the subjects must already exist.
+	Stream          string
+	CreateStream    bool
+	MaxAge          time.Duration
+	DuplicateWindow time.Duration
+}
+
+// NATS publishes events to JetStream. Messages carry the event ID as
+// Nats-Msg-Id, so events published twice within the stream's duplicate
+// window are stored once.
+type NATS struct {
+	conn   *nats.Conn
+	js     jetstream.JetStream
+	prefix string
+}
+
+func NewNATS(ctx context.Context, cfg NATSConfig) (*NATS, error) {
+	conn, err := nats.Connect(cfg.URL, nats.Name("fiozap"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open JetStream: %w", err)
+	}
+
+	if cfg.CreateStream {
+		_, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+			Name:       cfg.Stream,
+			Subjects:   []string{cfg.SubjectPrefix + ".>"},
+			Storage:    jetstream.FileStorage,
+			MaxAge:     cfg.MaxAge,
+			Duplicates: cfg.DuplicateWindow,
+		})
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to create stream %s: %w", cfg.Stream, err)
+		}
+	}
+
+	return &NATS{conn: conn, js: js, prefix: cfg.SubjectPrefix}, nil
+}
+
+func (n *NATS) Name() string {
+	return "nats"
+}
+
+func (n *NATS) Publish(ctx context.Context, msg Message) error {
+	m := nats.NewMsg(strings.Join([]string{
+		n.prefix, subjectToken(msg.UserID), subjectToken(msg.SessionID), subjectToken(msg.Event),
+	}, "."))
+	m.Data = msg.Body
+	m.Header.Set("Fiozap-Event", msg.Event)
+
+	_, err := n.js.PublishMsg(ctx, m, jetstream.WithMsgID(strconv.FormatInt(msg.ID, 10)))
+	return err
+}
+
+func (n *NATS) Close() error {
+	return n.conn.Drain()
+}
+
+// subjectToken makes s usable as a single subject token.
+func subjectToken(s string) string {
+	if s == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, s)
+}
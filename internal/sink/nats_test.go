@@ -0,0 +1,102 @@
+package sink
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+func TestSubjectToken(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", "_"},
+		{"session-1", "session-1"},
+		{"my.session", "my_session"},
+		{"a*b>c", "a_b_c"},
+		{"two words\tand\r\nlines", "two_words_and__lines"},
+		{"sessão", "sessão"},
+	}
+
+	for _, tt := range tests {
+		if got := subjectToken(tt.in); got != tt.want {
+			t.Errorf("subjectToken(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func runNATSServer(t *testing.T) *server.Server {
+	t.Helper()
+
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Start()
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	t.Cleanup(srv.Shutdown)
+	return srv
+}
+
+func TestNATSPublish(t *testing.T) {
+	srv := runNATSServer(t)
+	ctx := context.Background()
+
+	n, err := NewNATS(ctx, NATSConfig{
+		URL:             srv.ClientURL(),
+		SubjectPrefix:   "fiozap",
+		Stream:          "FIOZAP",
+		CreateStream:    true,
+		DuplicateWindow: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("NewNATS() error = %v", err)
+	}
+	defer n.Close()
+
+	msg := Message{ID: 1, UserID: "u1", SessionID: "my.session", Event: "Message", Body: []byte(`{"id":1}`)}
+	for range 2 {
+		if err := n.Publish(ctx, msg); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+
+	stream, err := n.js.Stream(ctx, "FIOZAP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := stream.Info(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.State.Msgs != 1 {
+		t.Fatalf("stream holds %d messages after publishing the same event twice, want 1", info.State.Msgs)
+	}
+
+	stored, err := stream.GetLastMsgForSubject(ctx, "fiozap.u1.my_session.Message")
+	if err != nil {
+		t.Fatalf("no message on the event's subject: %v", err)
+	}
+	if string(stored.Data) != string(msg.Body) {
+		t.Errorf("message data = %s, want %s", stored.Data, msg.Body)
+	}
+	if got := stored.Header.Get("Fiozap-Event"); got != "Message" {
+		t.Errorf("Fiozap-Event = %q, want Message", got)
+	}
+	if got := stored.Header.Get(jetstream.MsgIDHeader); got != "1" {
+		t.Errorf("%s = %q, want 1", jetstream.MsgIDHeader, got)
+	}
+}
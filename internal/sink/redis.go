@@ -0,0 +1,118 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type RedisConfig struct {
+	URL string
+	// KeyTemplate names the stream of an event. {userId}, {sessionId} and
+	// {event} are replaced with the event's values.
+	KeyTemplate string
+	// MaxLen trims streams to about this many entries, 0 disables trimming
+	MaxLen int64
+	// ConsumerGroup is created on each stream before its first entry is
+	// added, when set
+	ConsumerGroup string
+}
+
+// Redis appends events to Redis Streams with XADD. Entries carry the event
+// ID, type, user and session next to the JSON message in "data"; consumers
+// can drop duplicates by ID. It follows the event log like every sink, and
+// only gets the events of sessions whose redisStream flag is set when the
+// events are published.
+type Redis struct {
+	client        *redis.Client
+	keyTemplate   string
+	maxLen        int64
+	consumerGroup string
+
+	// streams whose consumer group is known to exist
+	groups map[string]struct{}
+	mu     sync.Mutex
+}
+
+func NewRedis(ctx context.Context, cfg RedisConfig) (*Redis, error) {
+	opts, err := redis.ParseURL(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	return &Redis{
+		client:        client,
+		keyTemplate:   cfg.KeyTemplate,
+		maxLen:        cfg.MaxLen,
+		consumerGroup: cfg.ConsumerGroup,
+		groups:        make(map[string]struct{}),
+	}, nil
+}
+
+func (r *Redis) Name() string {
+	return "redis"
+}
+
+func (r *Redis) Publish(ctx context.Context, msg Message) error {
+	key := strings.NewReplacer(
+		"{userId}", msg.UserID,
+		"{sessionId}", msg.SessionID,
+		"{event}", msg.Event,
+	).Replace(r.keyTemplate)
+
+	if err := r.ensureGroup(ctx, key); err != nil {
+		return err
+	}
+
+	return r.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: key,
+		MaxLen: r.maxLen,
+		Approx: true,
+		Values: []interface{}{
+			"id", strconv.FormatInt(msg.ID, 10),
+			"event", msg.Event,
+			"userId", msg.UserID,
+			"sessionId", msg.SessionID,
+			"data", msg.Body,
+		},
+	}).Err()
+}
+
+// ensureGroup creates the consumer group of a stream, reading from its
+// start so the entry about to be added is delivered too.
+func (r *Redis) ensureGroup(ctx context.Context, key string) error {
+	if r.consumerGroup == "" {
+		return nil
+	}
+
+	r.mu.Lock()
+	_, ok := r.groups[key]
+	r.mu.Unlock()
+	if ok {
+		return nil
+	}
+
+	err := r.client.XGroupCreateMkStream(ctx, key, r.consumerGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create consumer group on %s: %w", key, err)
+	}
+
+	r.mu.Lock()
+	r.groups[key] = struct{}{}
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
@@ -0,0 +1,59 @@
+package sink
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisPublish(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+
+	r, err := NewRedis(ctx, RedisConfig{
+		URL:           "redis://" + mr.Addr(),
+		KeyTemplate:   "fiozap:{userId}:{sessionId}:{event}",
+		ConsumerGroup: "workers",
+	})
+	if err != nil {
+		t.Fatalf("NewRedis() error = %v", err)
+	}
+	defer r.Close()
+
+	for id := range int64(2) {
+		msg := Message{ID: id + 1, UserID: "u1", SessionID: "s1", Event: "Message", Body: []byte(`{"id":1}`)}
+		if err := r.Publish(ctx, msg); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+
+	key := "fiozap:u1:s1:Message"
+	entries, err := r.client.XRange(ctx, key, "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("stream %s holds %d entries, want 2", key, len(entries))
+	}
+	want := map[string]interface{}{"id": "1", "event": "Message", "userId": "u1", "sessionId": "s1", "data": `{"id":1}`}
+	for field, value := range want {
+		if got := entries[0].Values[field]; got != value {
+			t.Errorf("entry %s = %v, want %v", field, got, value)
+		}
+	}
+
+	// the group reads from the start of the stream, first entry included
+	read, err := r.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    "workers",
+		Consumer: "test",
+		Streams:  []string{key, ">"},
+	}).Result()
+	if err != nil {
+		t.Fatalf("XReadGroup() error = %v", err)
+	}
+	if len(read) != 1 || len(read[0].Messages) != 2 {
+		t.Fatalf("XReadGroup() = %v, want both entries", read)
+	}
+}
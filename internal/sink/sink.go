@@ -0,0 +1,194 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"fiozap/internal/database/repository"
+	"fiozap/internal/logger"
+	"fiozap/internal/stream"
+)
+
+const (
+	publishBatchSize = 100
+	publishTimeout   = 10 * time.Second
+	// retryInterval is the wait after a failed publish.
+	retryInterval = 5 * time.Second
+	// pollInterval bounds the wait when no event notification arrives.
+	pollInterval = time.Minute
+	// sinkLease is how long an instance holds a sink for a batch.
+	sinkLease = 2 * time.Minute
+)
+
+// Sink is an external system every session event is published to, such as
+// a message broker.
+type Sink interface {
+	// Name identifies the sink's position in the event log, so it must stay
+	// the same across restarts.
+	Name() string
+	Publish(ctx context.Context, msg Message) error
+	Close() error
+}
+
+// Message is an event as handed to sinks. Body is the JSON of the
+// stream.Event, the same message WebSocket and SSE clients receive. ID is
+// the event's position in the log, as in the body; it is unique and can be
+// used to drop duplicates.
+type Message struct {
+	ID        int64
+	UserID    string
+	SessionID string
+	Event     string
+	Body      []byte
+}
+
+// Publisher follows the event log and publishes each event to a sink, in
+// log order and at least once. Its position is stored in fzEventSink, so
+// events created while the sink was unreachable or fiozap was down are
+// published afterwards.
+type Publisher struct {
+	sink        Sink
+	sinkRepo    *repository.EventSinkRepository
+	webhookRepo *repository.WebhookRepository
+	hub         *stream.Hub
+	filter      repository.EventFilter
+	ctx         context.Context
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+}
+
+func NewPublisher(sink Sink, sinkRepo *repository.EventSinkRepository, webhookRepo *repository.WebhookRepository, hub *stream.Hub) *Publisher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Publisher{
+		sink:        sink,
+		sinkRepo:    sinkRepo,
+		webhookRepo: webhookRepo,
+		hub:         hub,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+// SetFilter restricts the events published, e.g. to the sessions that
+// enabled the sink. After and Limit are managed by the publisher.
+func (p *Publisher) SetFilter(filter repository.EventFilter) {
+	p.filter = filter
+}
+
+func (p *Publisher) Start() error {
+	if err := p.sinkRepo.Init(p.sink.Name()); err != nil {
+		return fmt.Errorf("failed to register event sink %s: %w", p.sink.Name(), err)
+	}
+
+	p.wg.Add(1)
+	go p.run()
+	logger.Infof("Publishing events to %s", p.sink.Name())
+	return nil
+}
+
+func (p *Publisher) Stop() {
+	p.cancel()
+	p.wg.Wait()
+	if err := p.sink.Close(); err != nil {
+		logger.Warnf("Failed to close event sink %s: %v", p.sink.Name(), err)
+	}
+}
+
+func (p *Publisher) run() {
+	defer p.wg.Done()
+
+	sub := p.hub.SubscribeAll()
+	defer p.hub.Unsubscribe(sub)
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-sub.C:
+		case <-timer.C:
+		}
+
+		wait := pollInterval
+		more, err := p.publish()
+		switch {
+		case err != nil:
+			if p.ctx.Err() != nil {
+				return
+			}
+			logger.Warnf("Event sink %s: %v", p.sink.Name(), err)
+			wait = retryInterval
+		case more:
+			wait = 0
+		}
+		timer.Reset(wait)
+	}
+}
+
+// publish sends the next batch of events. It reports whether more events are
+// waiting.
+func (p *Publisher) publish() (bool, error) {
+	// instances take turns, each batch is published by whichever leases the
+	// sink first
+	lease, err := p.sinkRepo.Lease(p.sink.Name(), sinkLease)
+	if err != nil || lease == nil {
+		return false, err
+	}
+
+	filter := p.filter
+	filter.After = lease.Position
+	filter.Limit = publishBatchSize
+
+	events, err := p.webhookRepo.ListEvents(filter)
+	if err != nil {
+		return false, errors.Join(err, p.sinkRepo.Release(p.sink.Name(), lease))
+	}
+
+	// leave time to store the position before the lease runs out
+	deadline := time.Now().Add(sinkLease - 2*publishTimeout)
+	published := 0
+	var publishErr error
+	for _, event := range events {
+		if time.Now().After(deadline) {
+			break
+		}
+
+		body, err := json.Marshal(stream.NewEvent(event))
+		if err != nil {
+			publishErr = err
+			break
+		}
+
+		ctx, cancel := context.WithTimeout(p.ctx, publishTimeout)
+		err = p.sink.Publish(ctx, Message{
+			ID:        event.Seq,
+			UserID:    event.UserID,
+			SessionID: event.SessionID,
+			Event:     event.EventType,
+			Body:      body,
+		})
+		cancel()
+		if err != nil {
+			publishErr = fmt.Errorf("failed to publish event %d: %w", event.Seq, err)
+			break
+		}
+
+		lease.Position = event.Seq
+		published++
+	}
+
+	if err := p.sinkRepo.Release(p.sink.Name(), lease); err != nil {
+		return false, errors.Join(publishErr, err)
+	}
+	if publishErr != nil {
+		return false, publishErr
+	}
+	more := len(events) == publishBatchSize || published < len(events)
+	return more, nil
+}
@@ -0,0 +1,137 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+
+	"fiozap/internal/database/dbtest"
+	"fiozap/internal/database/repository"
+	"fiozap/internal/model"
+	"fiozap/internal/stream"
+)
+
+// recordingSink keeps the messages it is handed and fails the publish of the
+// IDs in fail once.
+type recordingSink struct {
+	messages []Message
+	fail     map[int64]bool
+}
+
+func (s *recordingSink) Name() string {
+	return "test"
+}
+
+func (s *recordingSink) Publish(ctx context.Context, msg Message) error {
+	if s.fail[msg.ID] {
+		delete(s.fail, msg.ID)
+		return errors.New("unavailable")
+	}
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	return nil
+}
+
+func (s *recordingSink) ids() []int64 {
+	ids := make([]int64, len(s.messages))
+	for i, msg := range s.messages {
+		ids[i] = msg.ID
+	}
+	return ids
+}
+
+func newTestSession(t *testing.T, db *sqlx.DB) *model.Session {
+	t.Helper()
+
+	user, err := repository.NewUserRepository(db).Create(&model.UserCreateRequest{Name: "a", Token: "token-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := repository.NewSessionRepository(db).Create(user.ID, &model.SessionCreateRequest{Name: "a", Events: "All"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+// createEvents adds n events of the session to the log and returns their
+// seqs.
+func createEvents(t *testing.T, repo *repository.WebhookRepository, session *model.Session, n int) []int64 {
+	t.Helper()
+
+	for range n {
+		if err := repo.Create(session.UserID, session.ID, "Message", map[string]string{"text": "hi"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events, err := repo.ListEvents(repository.EventFilter{SessionID: session.ID, Limit: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seqs := make([]int64, 0, n)
+	for _, event := range events[len(events)-n:] {
+		seqs = append(seqs, event.Seq)
+	}
+	return seqs
+}
+
+func TestPublisher(t *testing.T) {
+	db := dbtest.Open(t)
+	webhookRepo := repository.NewWebhookRepository(db)
+	session := newTestSession(t, db)
+
+	// events from before the sink was registered are not published
+	createEvents(t, webhookRepo, session, 1)
+
+	s := &recordingSink{fail: map[int64]bool{}}
+	p := NewPublisher(s, repository.NewEventSinkRepository(db), webhookRepo, stream.NewHub(nil))
+	if err := p.sinkRepo.Init(s.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	seqs := createEvents(t, webhookRepo, session, 3)
+	s.fail[seqs[1]] = true
+
+	if _, err := p.publish(); err == nil {
+		t.Fatal("publish() error = nil, want the sink's error")
+	}
+	if got := s.ids(); !slices.Equal(got, seqs[:1]) {
+		t.Fatalf("published %v before the failure, want %v", got, seqs[:1])
+	}
+
+	// the next batch resumes at the failed event
+	more, err := p.publish()
+	if err != nil {
+		t.Fatalf("publish() error = %v", err)
+	}
+	if more {
+		t.Error("publish() reported more events after publishing all of them")
+	}
+	if got := s.ids(); !slices.Equal(got, seqs) {
+		t.Fatalf("published %v, want %v", got, seqs)
+	}
+
+	msg := s.messages[0]
+	if msg.UserID != session.UserID || msg.SessionID != session.ID || msg.Event != "Message" {
+		t.Errorf("message = %+v, want the event's user, session and type", msg)
+	}
+	var body stream.Event
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		t.Fatalf("message body is not a stream event: %v", err)
+	}
+	if body.ID != msg.ID || body.SessionID != session.ID {
+		t.Errorf("message body = %+v, want ID %d and session %s", body, msg.ID, session.ID)
+	}
+
+	if more, err := p.publish(); err != nil || more || len(s.messages) != len(seqs) {
+		t.Errorf("publish() = %v, %v and published %v with nothing new", more, err, s.ids())
+	}
+}
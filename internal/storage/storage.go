@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// Storage persists downloaded media. Keys are slash-separated paths such as
+// "<sessionId>/<messageId>.jpg".
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get returns a seekable reader so objects can be served with Range
+	// support.
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	Delete(ctx context.Context, key string) error
+	// URL returns where the object can be fetched from, or an empty string
+	// when the backend has no public address.
+	URL(key string) string
+}
+
+type Config struct {
+	Backend   string
+	LocalPath string
+	PublicURL string
+
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+	S3UseSSL    bool
+}
+
+// New returns the configured backend, or nil when media storage is disabled.
+func New(ctx context.Context, cfg Config) (Storage, error) {
+	switch cfg.Backend {
+	case "", "none":
+		return nil, nil
+	case "local":
+		return NewLocal(cfg.LocalPath, cfg.PublicURL)
+	case "s3":
+		return NewS3(ctx, cfg)
+	default:
+		return nil, fmt.Errorf("unknown media storage backend: %s", cfg.Backend)
+	}
+}
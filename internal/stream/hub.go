@@ -0,0 +1,177 @@
+package stream
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"fiozap/internal/database/repository"
+	"fiozap/internal/logger"
+)
+
+// fallbackInterval is how often subscribers re-check the backlog when no
+// Postgres listener is available.
+const fallbackInterval = 2 * time.Second
+
+// Event is a stream message. It carries the same payload as the webhook
+// delivery of the event, plus its position in the event log as ID, for
+// resuming. IDs increase in the order events are streamed.
+type Event struct {
+	ID        int64           `json:"id"`
+	Event     string          `json:"event"`
+	SessionID string          `json:"sessionId"`
+	Timestamp int64           `json:"timestamp"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func NewEvent(e repository.WebhookEvent) Event {
+	return Event{
+		ID:        e.Seq,
+		Event:     e.EventType,
+		SessionID: e.SessionID,
+		Timestamp: e.CreatedAt.Unix(),
+		Data:      e.Payload,
+	}
+}
+
+// Subscription is signaled on C when new events may be available for its
+// user or session. Signals coalesce; the events themselves are read from
+// fzWebhook, which also serves as the backlog when resuming.
+type Subscription struct {
+	C         chan struct{}
+	userID    string
+	sessionID string
+	all       bool
+}
+
+func (s *Subscription) signal() {
+	select {
+	case s.C <- struct{}{}:
+	default:
+	}
+}
+
+// Hub fans out event notifications from Postgres to the streams connected
+// to this instance, whichever instance produced the event.
+type Hub struct {
+	listener *pq.Listener
+	subs     map[*Subscription]struct{}
+	mu       sync.Mutex
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewHub creates a hub fed by listener, which must listen on
+// repository.EventNotifyChannel. Without a listener subscribers are
+// signaled periodically instead.
+func NewHub(listener *pq.Listener) *Hub {
+	return &Hub{
+		listener: listener,
+		subs:     make(map[*Subscription]struct{}),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+func (h *Hub) Start() {
+	h.wg.Add(1)
+	go h.run()
+}
+
+func (h *Hub) Stop() {
+	close(h.stopCh)
+	h.wg.Wait()
+	if h.listener != nil {
+		h.listener.Close()
+	}
+}
+
+// Subscribe registers a stream for the events of a session, or of all the
+// user's sessions when sessionID is empty.
+func (h *Hub) Subscribe(userID, sessionID string) *Subscription {
+	sub := &Subscription{
+		C:         make(chan struct{}, 1),
+		userID:    userID,
+		sessionID: sessionID,
+	}
+
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+// SubscribeAll registers a consumer of every event, such as an event sink.
+func (h *Hub) SubscribeAll() *Subscription {
+	sub := &Subscription{C: make(chan struct{}, 1), all: true}
+
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	delete(h.subs, sub)
+	h.mu.Unlock()
+}
+
+func (h *Hub) run() {
+	defer h.wg.Done()
+
+	var notifications <-chan *pq.Notification
+	if h.listener != nil {
+		notifications = h.listener.Notify
+	}
+
+	interval := fallbackInterval
+	if h.listener != nil {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.stopCh:
+			return
+		case n := <-notifications:
+			if n == nil {
+				// reconnected, notifications may have been missed
+				h.signal("", "")
+				continue
+			}
+			userID, sessionID, _ := strings.Cut(n.Extra, ":")
+			h.signal(userID, sessionID)
+		case <-ticker.C:
+			if h.listener == nil {
+				h.signal("", "")
+				continue
+			}
+			if err := h.listener.Ping(); err != nil {
+				logger.Warnf("Event stream listener ping failed: %v", err)
+			}
+		}
+	}
+}
+
+// signal wakes the subscribers interested in an event of the session. Empty
+// arguments wake everyone.
+func (h *Hub) signal(userID, sessionID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subs {
+		switch {
+		case userID == "" || sub.all:
+			sub.signal()
+		case sub.sessionID == "" && sub.userID == userID:
+			sub.signal()
+		case sub.sessionID != "" && sub.sessionID == sessionID:
+			sub.signal()
+		}
+	}
+}
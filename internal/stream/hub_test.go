@@ -0,0 +1,88 @@
+package stream
+
+import "testing"
+
+func signaled(sub *Subscription) bool {
+	select {
+	case <-sub.C:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestHubSignal(t *testing.T) {
+	h := NewHub(nil)
+	session := h.Subscribe("u1", "s1")
+	otherSession := h.Subscribe("u1", "s2")
+	user := h.Subscribe("u1", "")
+	otherUser := h.Subscribe("u2", "")
+
+	h.signal("u1", "s1")
+	for name, tt := range map[string]struct {
+		sub  *Subscription
+		want bool
+	}{
+		"session":       {session, true},
+		"other session": {otherSession, false},
+		"user":          {user, true},
+		"other user":    {otherUser, false},
+	} {
+		if got := signaled(tt.sub); got != tt.want {
+			t.Errorf("%s signaled = %v, want %v", name, got, tt.want)
+		}
+	}
+
+	// user events without a session only reach user streams
+	h.signal("u1", "")
+	if signaled(session) || !signaled(user) {
+		t.Error("a user event was not routed to the user stream only")
+	}
+
+	// an empty signal, e.g. after reconnecting, wakes everyone
+	h.signal("", "")
+	for _, sub := range []*Subscription{session, otherSession, user, otherUser} {
+		if !signaled(sub) {
+			t.Error("subscription not signaled by an empty signal")
+		}
+	}
+}
+
+func TestHubSignalsCoalesce(t *testing.T) {
+	h := NewHub(nil)
+	sub := h.Subscribe("u1", "s1")
+
+	h.signal("u1", "s1")
+	h.signal("u1", "s1")
+	if !signaled(sub) {
+		t.Fatal("subscription not signaled")
+	}
+	if signaled(sub) {
+		t.Error("signals did not coalesce")
+	}
+}
+
+func TestHubUnsubscribe(t *testing.T) {
+	h := NewHub(nil)
+	sub := h.Subscribe("u1", "s1")
+	h.Unsubscribe(sub)
+
+	h.signal("u1", "s1")
+	if signaled(sub) {
+		t.Error("unsubscribed stream was signaled")
+	}
+}
+
+func TestHubSubscribeAll(t *testing.T) {
+	h := NewHub(nil)
+	sub := h.SubscribeAll()
+
+	h.signal("u1", "s1")
+	if !signaled(sub) {
+		t.Error("SubscribeAll() subscription not signaled by a session event")
+	}
+	h.signal("u2", "")
+	if !signaled(sub) {
+		t.Error("SubscribeAll() subscription not signaled by a user event")
+	}
+}
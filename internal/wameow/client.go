@@ -6,6 +6,7 @@ import (
 
 	"github.com/mdp/qrterminal/v3"
 	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/store"
 	"go.mau.fi/whatsmeow/store/sqlstore"
 	"go.mau.fi/whatsmeow/types"
 	"go.mau.fi/whatsmeow/types/events"
@@ -21,13 +22,18 @@ func getWaLogger(module string) waLog.Logger {
 type EventCallback func(eventType string, data interface{})
 
 type Client struct {
-	wac           *whatsmeow.Client
-	userID        string
-	eventCallback EventCallback
-	qrCallback    func(string)
+	wac             *whatsmeow.Client
+	userID          string
+	eventCallback   EventCallback
+	qrCallback      func(string)
+	msgCallback     func(*events.Message, *MessageEvent)
+	editCallback    func(*MessageEditedEvent) bool
+	voteCallback    func(*PollVoteEvent)
+	historyCallback func(*events.HistorySync)
+	proxyURL        string
 }
 
-func NewClient(ctx context.Context, postgresConnStr string, userID string) (*Client, error) {
+func NewContainer(ctx context.Context, postgresConnStr string) (*sqlstore.Container, error) {
 	dbLog := getWaLogger("database")
 
 	container, err := sqlstore.New(ctx, "postgres", postgresConnStr, dbLog)
@@ -35,7 +41,13 @@ func NewClient(ctx context.Context, postgresConnStr string, userID string) (*Cli
 		return nil, fmt.Errorf("failed to create sqlstore: %w", err)
 	}
 
-	deviceStore, err := container.GetFirstDevice(ctx)
+	return container, nil
+}
+
+// NewClient loads the device mapped to deviceJID from the container, or
+// creates a fresh one when the session has never been paired.
+func NewClient(ctx context.Context, container *sqlstore.Container, deviceJID string, userID string) (*Client, error) {
+	deviceStore, err := getDevice(ctx, container, deviceJID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get device: %w", err)
 	}
@@ -52,6 +64,51 @@ func NewClient(ctx context.Context, postgresConnStr string, userID string) (*Cli
 	return client, nil
 }
 
+func getDevice(ctx context.Context, container *sqlstore.Container, deviceJID string) (*store.Device, error) {
+	if deviceJID == "" {
+		return container.NewDevice(), nil
+	}
+
+	jid, err := types.ParseJID(deviceJID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid device JID: %w", err)
+	}
+
+	device, err := container.GetDevice(ctx, jid)
+	if err != nil {
+		return nil, err
+	}
+	if device == nil {
+		logger.Warnf("Device %s not found in store, creating a new one", deviceJID)
+		return container.NewDevice(), nil
+	}
+
+	return device, nil
+}
+
+// DeleteDevice removes the device and all of its keys from the container.
+// It is a no-op when the device was never paired or is already gone.
+func DeleteDevice(ctx context.Context, container *sqlstore.Container, deviceJID string) error {
+	if deviceJID == "" {
+		return nil
+	}
+
+	jid, err := types.ParseJID(deviceJID)
+	if err != nil {
+		return fmt.Errorf("invalid device JID: %w", err)
+	}
+
+	device, err := container.GetDevice(ctx, jid)
+	if err != nil {
+		return fmt.Errorf("failed to get device: %w", err)
+	}
+	if device == nil {
+		return nil
+	}
+
+	return device.Delete(ctx)
+}
+
 func (c *Client) SetEventCallback(cb EventCallback) {
 	c.eventCallback = cb
 }
@@ -60,6 +117,30 @@ func (c *Client) SetQRCallback(cb func(string)) {
 	c.qrCallback = cb
 }
 
+// SetMessageCallback registers a hook that runs before the Message event is
+// emitted. It may complete the event payload, e.g. mark its media as pending.
+func (c *Client) SetMessageCallback(cb func(*events.Message, *MessageEvent)) {
+	c.msgCallback = cb
+}
+
+// SetEditCallback registers a hook that runs before the MessageEdited event
+// is emitted. The event is dropped when it returns false, e.g. for an edit
+// of someone else's message.
+func (c *Client) SetEditCallback(cb func(*MessageEditedEvent) bool) {
+	c.editCallback = cb
+}
+
+// SetPollVoteCallback registers a hook that runs before the PollVote event
+// is emitted. It may complete the event with the poll's question and the
+// names of the selected options.
+func (c *Client) SetPollVoteCallback(cb func(*PollVoteEvent)) {
+	c.voteCallback = cb
+}
+
+func (c *Client) SetHistorySyncCallback(cb func(*events.HistorySync)) {
+	c.historyCallback = cb
+}
+
 func (c *Client) Connect(ctx context.Context) error {
 	if c.wac.Store.ID == nil {
 		qrChan, _ := c.wac.GetQRChannel(ctx)
@@ -76,7 +157,7 @@ func (c *Client) Connect(ctx context.Context) error {
 						c.qrCallback(evt.Code)
 					}
 					if c.eventCallback != nil {
-						c.eventCallback("QR", map[string]string{"code": evt.Code})
+						c.eventCallback("QR", NewQREvent(evt.Code))
 					}
 				} else {
 					logger.Infof("Login event: %s", evt.Event)
@@ -98,147 +179,175 @@ func (c *Client) Disconnect() {
 	logger.Info("Disconnected from WhatsApp")
 }
 
+// handlePollVote decrypts a vote with the secret of the poll, which
+// whatsmeow keeps for polls sent or received by the session.
+func (c *Client) handlePollVote(evt *events.Message) {
+	vote, err := c.wac.DecryptPollVote(context.Background(), evt)
+	if err != nil {
+		logger.Warnf("Failed to decrypt poll vote %s from %s: %v", evt.Info.ID, evt.Info.Sender.String(), err)
+		return
+	}
+
+	data := NewPollVoteEvent(evt, vote)
+	logger.Infof("Poll vote on %s from %s", data.PollID, evt.Info.Sender.String())
+	if c.voteCallback != nil {
+		c.voteCallback(data)
+	}
+	if c.eventCallback != nil {
+		c.eventCallback("PollVote", data)
+	}
+}
+
 func (c *Client) eventHandler(evt interface{}) {
 	switch v := evt.(type) {
 	case *events.Message:
+		if v.Message.GetPollUpdateMessage() != nil {
+			c.handlePollVote(v)
+			return
+		}
+
+		// edits arrive as protocol messages and would otherwise look like a
+		// new message of unknown type
+		if edit := NewMessageEditedEvent(v); edit != nil {
+			logger.Infof("Message %s edited by %s", edit.TargetID, v.Info.Sender.String())
+			if c.editCallback != nil && !c.editCallback(edit) {
+				return
+			}
+			if c.eventCallback != nil {
+				c.eventCallback("MessageEdited", edit)
+			}
+			return
+		}
+
 		logger.Infof("Received message from %s", v.Info.Sender.String())
+		data := NewMessageEvent(v)
+		if c.msgCallback != nil {
+			c.msgCallback(v, data)
+		}
+		data.setV1Fields(v.Message)
 		if c.eventCallback != nil {
-			c.eventCallback("Message", map[string]interface{}{
-				"from":         v.Info.Sender.String(),
-				"chat":         v.Info.Chat.String(),
-				"id":           v.Info.ID,
-				"timestamp":    v.Info.Timestamp.Unix(),
-				"pushName":     v.Info.PushName,
-				"isGroup":      v.Info.IsGroup,
-				"isFromMe":     v.Info.IsFromMe,
-				"text":         v.Message.GetConversation(),
-				"extendedText": getExtendedText(v),
-				"messageType":  getMessageType(v),
-			})
+			c.eventCallback("Message", data)
 		}
 
 	case *events.Receipt:
 		if c.eventCallback != nil {
-			c.eventCallback("ReadReceipt", map[string]interface{}{
-				"chat":       v.Chat.String(),
-				"sender":     v.Sender.String(),
-				"type":       string(v.Type),
-				"messageIds": v.MessageIDs,
-				"timestamp":  v.Timestamp.Unix(),
+			c.eventCallback("ReadReceipt", &ReadReceiptEvent{
+				Payload:    newPayload(),
+				Chat:       v.Chat.String(),
+				Sender:     v.Sender.String(),
+				Type:       string(v.Type),
+				MessageIDs: v.MessageIDs,
+				Timestamp:  v.Timestamp.Unix(),
 			})
 		}
 
 	case *events.Presence:
 		if c.eventCallback != nil {
-			c.eventCallback("Presence", map[string]interface{}{
-				"from":        v.From.String(),
-				"unavailable": v.Unavailable,
-				"lastSeen":    v.LastSeen.Unix(),
+			c.eventCallback("Presence", &PresenceEvent{
+				Payload:     newPayload(),
+				From:        v.From.String(),
+				Unavailable: v.Unavailable,
+				LastSeen:    v.LastSeen.Unix(),
 			})
 		}
 
 	case *events.ChatPresence:
 		if c.eventCallback != nil {
-			c.eventCallback("ChatPresence", map[string]interface{}{
-				"chat":   v.Chat.String(),
-				"sender": v.Sender.String(),
-				"state":  string(v.State),
-				"media":  string(v.Media),
+			c.eventCallback("ChatPresence", &ChatPresenceEvent{
+				Payload: newPayload(),
+				Chat:    v.Chat.String(),
+				Sender:  v.Sender.String(),
+				State:   string(v.State),
+				Media:   string(v.Media),
 			})
 		}
 
 	case *events.Connected:
 		logger.Info("WhatsApp connected")
 		if c.eventCallback != nil {
-			c.eventCallback("Connected", map[string]interface{}{
-				"jid": c.wac.Store.ID.String(),
+			c.eventCallback("Connected", &ConnectedEvent{
+				Payload: newPayload(),
+				JID:     c.wac.Store.ID.String(),
 			})
 		}
 
 	case *events.Disconnected:
 		logger.Warn("WhatsApp disconnected")
 		if c.eventCallback != nil {
-			c.eventCallback("Disconnected", nil)
+			c.eventCallback("Disconnected", &DisconnectedEvent{Payload: newPayload()})
 		}
 
 	case *events.LoggedOut:
 		logger.Warn("WhatsApp logged out")
 		if c.eventCallback != nil {
-			c.eventCallback("LoggedOut", map[string]interface{}{
-				"reason": v.Reason.String(),
+			c.eventCallback("LoggedOut", &LoggedOutEvent{
+				Payload:   newPayload(),
+				Reason:    v.Reason.String(),
+				OnConnect: v.OnConnect,
 			})
 		}
 
 	case *events.HistorySync:
-		if c.eventCallback != nil {
-			c.eventCallback("HistorySync", map[string]interface{}{
-				"data": v.Data,
-			})
+		if c.historyCallback != nil {
+			c.historyCallback(v)
 		}
 
 	case *events.CallOffer:
 		if c.eventCallback != nil {
-			c.eventCallback("CallOffer", map[string]interface{}{
-				"from":      v.CallCreator.String(),
-				"timestamp": v.Timestamp.Unix(),
-				"callId":    v.CallID,
+			c.eventCallback("CallOffer", &CallOfferEvent{
+				Payload:   newPayload(),
+				CallID:    v.CallID,
+				From:      v.CallCreator.String(),
+				Timestamp: v.Timestamp.Unix(),
 			})
 		}
 
 	case *events.GroupInfo:
 		if c.eventCallback != nil {
-			c.eventCallback("GroupInfo", map[string]interface{}{
-				"jid":    v.JID.String(),
-				"notify": v.Notify,
-			})
+			data := &GroupInfoEvent{
+				Payload:   newPayload(),
+				JID:       v.JID.String(),
+				Notify:    v.Notify,
+				Timestamp: v.Timestamp.Unix(),
+				Join:      jidStrings(v.Join),
+				Leave:     jidStrings(v.Leave),
+				Promote:   jidStrings(v.Promote),
+				Demote:    jidStrings(v.Demote),
+			}
+			if v.Sender != nil {
+				data.Sender = v.Sender.String()
+			}
+			if v.Name != nil {
+				data.Name = v.Name.Name
+			}
+			if v.Topic != nil {
+				data.Topic = v.Topic.Topic
+			}
+			c.eventCallback("GroupInfo", data)
 		}
 
 	case *events.JoinedGroup:
 		if c.eventCallback != nil {
-			c.eventCallback("JoinedGroup", map[string]interface{}{
-				"jid":   v.JID.String(),
-				"type":  v.Type,
-				"name":  v.GroupInfo.Name,
-				"topic": v.GroupInfo.Topic,
+			c.eventCallback("JoinedGroup", &JoinedGroupEvent{
+				Payload: newPayload(),
+				JID:     v.JID.String(),
+				Type:    v.Type,
+				Name:    v.GroupInfo.Name,
+				Topic:   v.GroupInfo.Topic,
 			})
 		}
 	}
 }
 
-func getExtendedText(evt *events.Message) string {
-	if evt.Message != nil && evt.Message.ExtendedTextMessage != nil {
-		return evt.Message.ExtendedTextMessage.GetText()
-	}
-	return ""
-}
-
-func getMessageType(evt *events.Message) string {
-	if evt.Message == nil {
-		return "unknown"
+func jidStrings(jids []types.JID) []string {
+	if len(jids) == 0 {
+		return nil
 	}
-	m := evt.Message
-	switch {
-	case m.Conversation != nil || m.ExtendedTextMessage != nil:
-		return "text"
-	case m.ImageMessage != nil:
-		return "image"
-	case m.VideoMessage != nil:
-		return "video"
-	case m.AudioMessage != nil:
-		return "audio"
-	case m.DocumentMessage != nil:
-		return "document"
-	case m.StickerMessage != nil:
-		return "sticker"
-	case m.ContactMessage != nil:
-		return "contact"
-	case m.LocationMessage != nil:
-		return "location"
-	case m.ReactionMessage != nil:
-		return "reaction"
-	default:
-		return "unknown"
+	result := make([]string, len(jids))
+	for i, jid := range jids {
+		result[i] = jid.String()
 	}
+	return result
 }
 
 func (c *Client) GetClient() *whatsmeow.Client {
@@ -0,0 +1,528 @@
+package wameow
+
+import (
+	"encoding/hex"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// PayloadVersion is sent in every event payload. It is bumped when fields
+// are renamed, retyped or removed, so consumers can tell which shape they
+// got; new fields are added without a bump. Version 1 was the untyped
+// payload without the field. Its flat Message event fields are still sent
+// and will be removed in version 3.
+const PayloadVersion = 2
+
+// Payload is embedded in every event payload.
+type Payload struct {
+	PayloadVersion int `json:"payloadVersion"`
+}
+
+func newPayload() Payload {
+	return Payload{PayloadVersion: PayloadVersion}
+}
+
+type QREvent struct {
+	Payload
+	Code string `json:"code"`
+}
+
+type ConnectedEvent struct {
+	Payload
+	JID string `json:"jid"`
+}
+
+type DisconnectedEvent struct {
+	Payload
+}
+
+type LoggedOutEvent struct {
+	Payload
+	Reason string `json:"reason"`
+	// OnConnect is set when the session was found logged out while
+	// connecting, rather than unlinked while connected
+	OnConnect bool `json:"onConnect"`
+}
+
+type MessageEvent struct {
+	Payload
+	ID        string `json:"id"`
+	Chat      string `json:"chat"`
+	From      string `json:"from"`
+	Timestamp int64  `json:"timestamp"`
+	PushName  string `json:"pushName,omitempty"`
+	IsGroup   bool   `json:"isGroup"`
+	IsFromMe  bool   `json:"isFromMe"`
+	// MessageType repeats Message.Type, for consumers that only need a
+	// summary. Text is the plain conversation text, as in version 1; the text
+	// of other message types is in Message.
+	MessageType string      `json:"messageType"`
+	Text        string      `json:"text"`
+	Message     MessageBody `json:"message"`
+	IsEphemeral bool        `json:"isEphemeral,omitempty"`
+	IsViewOnce  bool        `json:"isViewOnce,omitempty"`
+
+	// Deprecated: version 1 fields, use Message instead.
+	ExtendedText      string `json:"extendedText"`
+	Caption           string `json:"caption,omitempty"`
+	MimeType          string `json:"mimeType,omitempty"`
+	FileName          string `json:"fileName,omitempty"`
+	Size              uint64 `json:"size,omitempty"`
+	SHA256            string `json:"sha256,omitempty"`
+	MediaID           string `json:"mediaId,omitempty"`
+	MediaURL          string `json:"mediaUrl,omitempty"`
+	MediaURLExpiresAt int64  `json:"mediaUrlExpiresAt,omitempty"`
+	MediaError        string `json:"mediaError,omitempty"`
+}
+
+// setV1Fields fills the version 1 fields from the message body. It runs
+// last, once the message callback has completed the media.
+func (e *MessageEvent) setV1Fields(msg *waE2E.Message) {
+	e.ExtendedText = msg.GetExtendedTextMessage().GetText()
+
+	media := e.Message.Media
+	if media == nil {
+		return
+	}
+	e.Caption = e.Message.Caption
+	e.MimeType = media.MimeType
+	e.FileName = media.FileName
+	e.Size = media.Size
+	e.SHA256 = media.SHA256
+	e.MediaID = media.ID
+	e.MediaURL = media.URL
+	e.MediaURLExpiresAt = media.URLExpiresAt
+	e.MediaError = media.Error
+}
+
+// MessageEditedEvent is sent instead of a Message event when a message is
+// edited. ID is the ID of the edit itself and TargetID the one of the edited
+// message; Message is its new content.
+type MessageEditedEvent struct {
+	Payload
+	ID          string      `json:"id"`
+	TargetID    string      `json:"targetId"`
+	Chat        string      `json:"chat"`
+	From        string      `json:"from"`
+	Timestamp   int64       `json:"timestamp"`
+	EditedAt    int64       `json:"editedAt"`
+	PushName    string      `json:"pushName,omitempty"`
+	IsGroup     bool        `json:"isGroup"`
+	IsFromMe    bool        `json:"isFromMe"`
+	MessageType string      `json:"messageType"`
+	Text        string      `json:"text"`
+	Message     MessageBody `json:"message"`
+}
+
+// PollVoteEvent is sent instead of a Message event for a decrypted poll vote.
+// A vote replaces the voter's previous one, and an empty selection withdraws
+// it. SelectedHashes are the hex SHA-256 hashes of the chosen option names;
+// SelectedOptions and Question are only set when the poll is stored.
+type PollVoteEvent struct {
+	Payload
+	ID              string   `json:"id"`
+	PollID          string   `json:"pollId"`
+	Chat            string   `json:"chat"`
+	From            string   `json:"from"`
+	Timestamp       int64    `json:"timestamp"`
+	PushName        string   `json:"pushName,omitempty"`
+	IsGroup         bool     `json:"isGroup"`
+	IsFromMe        bool     `json:"isFromMe"`
+	Question        string   `json:"question,omitempty"`
+	SelectedOptions []string `json:"selectedOptions"`
+	SelectedHashes  []string `json:"selectedHashes"`
+}
+
+// MessageBody is a normalized message. Type tells which of the optional
+// parts are set.
+type MessageBody struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text,omitempty"`
+	Caption  string          `json:"caption,omitempty"`
+	Media    *MessageMedia   `json:"media,omitempty"`
+	Location *Location       `json:"location,omitempty"`
+	Contacts []Contact       `json:"contacts,omitempty"`
+	Reaction *Reaction       `json:"reaction,omitempty"`
+	Poll     *Poll           `json:"poll,omitempty"`
+	Context  *MessageContext `json:"context,omitempty"`
+}
+
+// MessageMedia describes the attachment of an image, video, audio, document
+// or sticker message. Pending marks media being stored in the background: a
+// MediaStored event follows with ID, URL and URLExpiresAt, or Error when
+// storing it failed.
+type MessageMedia struct {
+	MimeType  string `json:"mimeType"`
+	FileName  string `json:"fileName,omitempty"`
+	Title     string `json:"title,omitempty"`
+	Size      uint64 `json:"size"`
+	SHA256    string `json:"sha256"`
+	Width     uint32 `json:"width,omitempty"`
+	Height    uint32 `json:"height,omitempty"`
+	Seconds   uint32 `json:"seconds,omitempty"`
+	PageCount uint32 `json:"pageCount,omitempty"`
+	// PTT marks voice notes
+	PTT         bool `json:"ptt,omitempty"`
+	GifPlayback bool `json:"gifPlayback,omitempty"`
+	Animated    bool `json:"animated,omitempty"`
+
+	Pending      bool   `json:"pending,omitempty"`
+	ID           string `json:"id,omitempty"`
+	URL          string `json:"url,omitempty"`
+	URLExpiresAt int64  `json:"urlExpiresAt,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Name      string  `json:"name,omitempty"`
+	Address   string  `json:"address,omitempty"`
+	URL       string  `json:"url,omitempty"`
+	IsLive    bool    `json:"isLive,omitempty"`
+}
+
+type Contact struct {
+	DisplayName string `json:"displayName"`
+	VCard       string `json:"vcard"`
+}
+
+// Poll is a poll creation. A SelectableCount of 0 lets voters pick any
+// number of options.
+type Poll struct {
+	Question        string   `json:"question"`
+	Options         []string `json:"options"`
+	SelectableCount uint32   `json:"selectableCount"`
+}
+
+// Reaction targets another message. An empty Emoji removes the reaction.
+type Reaction struct {
+	Emoji        string `json:"emoji"`
+	TargetID     string `json:"targetId"`
+	TargetChat   string `json:"targetChat,omitempty"`
+	TargetFromMe bool   `json:"targetFromMe"`
+}
+
+// MessageContext holds what a message replies to, who it mentions and
+// whether it was forwarded.
+type MessageContext struct {
+	Quoted          *QuotedMessage `json:"quoted,omitempty"`
+	Mentions        []string       `json:"mentions,omitempty"`
+	IsForwarded     bool           `json:"isForwarded,omitempty"`
+	ForwardingScore uint32         `json:"forwardingScore,omitempty"`
+}
+
+type QuotedMessage struct {
+	ID          string `json:"id"`
+	Participant string `json:"participant,omitempty"`
+	Type        string `json:"type,omitempty"`
+	Text        string `json:"text,omitempty"`
+}
+
+// MediaStoredEvent follows the Message event of a message whose media was
+// pending, once the file is stored or storing it failed. ID is the ID of the
+// message.
+type MediaStoredEvent struct {
+	Payload
+	ID    string        `json:"id"`
+	Chat  string        `json:"chat"`
+	Media *MessageMedia `json:"media"`
+}
+
+func NewMediaStoredEvent(messageID, chat string, media *MessageMedia) *MediaStoredEvent {
+	return &MediaStoredEvent{
+		Payload: newPayload(),
+		ID:      messageID,
+		Chat:    chat,
+		Media:   media,
+	}
+}
+
+type ReadReceiptEvent struct {
+	Payload
+	Chat       string   `json:"chat"`
+	Sender     string   `json:"sender"`
+	Type       string   `json:"type"`
+	MessageIDs []string `json:"messageIds"`
+	Timestamp  int64    `json:"timestamp"`
+}
+
+type PresenceEvent struct {
+	Payload
+	From        string `json:"from"`
+	Unavailable bool   `json:"unavailable"`
+	LastSeen    int64  `json:"lastSeen"`
+}
+
+type ChatPresenceEvent struct {
+	Payload
+	Chat   string `json:"chat"`
+	Sender string `json:"sender"`
+	State  string `json:"state"`
+	Media  string `json:"media"`
+}
+
+type CallOfferEvent struct {
+	Payload
+	CallID    string `json:"callId"`
+	From      string `json:"from"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+type GroupInfoEvent struct {
+	Payload
+	JID       string   `json:"jid"`
+	Notify    string   `json:"notify,omitempty"`
+	Sender    string   `json:"sender,omitempty"`
+	Timestamp int64    `json:"timestamp"`
+	Name      string   `json:"name,omitempty"`
+	Topic     string   `json:"topic,omitempty"`
+	Join      []string `json:"join,omitempty"`
+	Leave     []string `json:"leave,omitempty"`
+	Promote   []string `json:"promote,omitempty"`
+	Demote    []string `json:"demote,omitempty"`
+}
+
+type JoinedGroupEvent struct {
+	Payload
+	JID   string `json:"jid"`
+	Type  string `json:"type,omitempty"`
+	Name  string `json:"name"`
+	Topic string `json:"topic,omitempty"`
+}
+
+type HistorySyncProgressEvent struct {
+	Payload
+	SyncType      string `json:"syncType"`
+	ChunkOrder    uint32 `json:"chunkOrder"`
+	Progress      uint32 `json:"progress"`
+	Conversations int    `json:"conversations"`
+	Messages      int    `json:"messages"`
+	Stored        int64  `json:"stored"`
+}
+
+func NewQREvent(code string) *QREvent {
+	return &QREvent{Payload: newPayload(), Code: code}
+}
+
+func NewHistorySyncProgressEvent(syncType string, chunkOrder, progress uint32, conversations, messages int, stored int64) *HistorySyncProgressEvent {
+	return &HistorySyncProgressEvent{
+		Payload:       newPayload(),
+		SyncType:      syncType,
+		ChunkOrder:    chunkOrder,
+		Progress:      progress,
+		Conversations: conversations,
+		Messages:      messages,
+		Stored:        stored,
+	}
+}
+
+func NewMessageEvent(evt *events.Message) *MessageEvent {
+	body := NewMessageBody(evt.Message)
+	return &MessageEvent{
+		Payload:     newPayload(),
+		ID:          evt.Info.ID,
+		Chat:        evt.Info.Chat.String(),
+		From:        evt.Info.Sender.String(),
+		Timestamp:   evt.Info.Timestamp.Unix(),
+		PushName:    evt.Info.PushName,
+		IsGroup:     evt.Info.IsGroup,
+		IsFromMe:    evt.Info.IsFromMe,
+		MessageType: body.Type,
+		Text:        evt.Message.GetConversation(),
+		Message:     body,
+		IsEphemeral: evt.IsEphemeral,
+		IsViewOnce:  evt.IsViewOnce,
+	}
+}
+
+// NewMessageEditedEvent returns the edit carried by evt, or nil when evt is
+// not an edit.
+func NewMessageEditedEvent(evt *events.Message) *MessageEditedEvent {
+	protocol := evt.Message.GetProtocolMessage()
+	if protocol.GetType() != waE2E.ProtocolMessage_MESSAGE_EDIT {
+		return nil
+	}
+
+	edited := protocol.GetEditedMessage()
+	editedAt := evt.Info.Timestamp.Unix()
+	if ms := protocol.GetTimestampMS(); ms > 0 {
+		editedAt = ms / 1000
+	}
+
+	body := NewMessageBody(edited)
+	return &MessageEditedEvent{
+		Payload:     newPayload(),
+		ID:          evt.Info.ID,
+		TargetID:    protocol.GetKey().GetID(),
+		Chat:        evt.Info.Chat.String(),
+		From:        evt.Info.Sender.String(),
+		Timestamp:   evt.Info.Timestamp.Unix(),
+		EditedAt:    editedAt,
+		PushName:    evt.Info.PushName,
+		IsGroup:     evt.Info.IsGroup,
+		IsFromMe:    evt.Info.IsFromMe,
+		MessageType: body.Type,
+		Text:        GetMessageText(edited),
+		Message:     body,
+	}
+}
+
+func NewPollVoteEvent(evt *events.Message, vote *waE2E.PollVoteMessage) *PollVoteEvent {
+	hashes := make([]string, 0, len(vote.GetSelectedOptions()))
+	for _, hash := range vote.GetSelectedOptions() {
+		hashes = append(hashes, hex.EncodeToString(hash))
+	}
+
+	return &PollVoteEvent{
+		Payload:         newPayload(),
+		ID:              evt.Info.ID,
+		PollID:          evt.Message.GetPollUpdateMessage().GetPollCreationMessageKey().GetID(),
+		Chat:            evt.Info.Chat.String(),
+		From:            evt.Info.Sender.String(),
+		Timestamp:       evt.Info.Timestamp.Unix(),
+		PushName:        evt.Info.PushName,
+		IsGroup:         evt.Info.IsGroup,
+		IsFromMe:        evt.Info.IsFromMe,
+		SelectedOptions: []string{},
+		SelectedHashes:  hashes,
+	}
+}
+
+// NewMessageBody normalizes a message, which must already be unwrapped from
+// ephemeral and view once containers.
+func NewMessageBody(m *waE2E.Message) MessageBody {
+	body := MessageBody{Type: GetMessageType(m)}
+	if m == nil {
+		return body
+	}
+
+	switch {
+	case m.Conversation != nil:
+		body.Text = m.GetConversation()
+	case m.ExtendedTextMessage != nil:
+		body.Text = m.GetExtendedTextMessage().GetText()
+	case m.ImageMessage != nil:
+		img := m.GetImageMessage()
+		body.Caption = img.GetCaption()
+		body.Media = &MessageMedia{
+			MimeType: img.GetMimetype(),
+			Size:     img.GetFileLength(),
+			SHA256:   hex.EncodeToString(img.GetFileSHA256()),
+			Width:    img.GetWidth(),
+			Height:   img.GetHeight(),
+		}
+	case m.VideoMessage != nil:
+		video := m.GetVideoMessage()
+		body.Caption = video.GetCaption()
+		body.Media = &MessageMedia{
+			MimeType:    video.GetMimetype(),
+			Size:        video.GetFileLength(),
+			SHA256:      hex.EncodeToString(video.GetFileSHA256()),
+			Width:       video.GetWidth(),
+			Height:      video.GetHeight(),
+			Seconds:     video.GetSeconds(),
+			GifPlayback: video.GetGifPlayback(),
+		}
+	case m.AudioMessage != nil:
+		audio := m.GetAudioMessage()
+		body.Media = &MessageMedia{
+			MimeType: audio.GetMimetype(),
+			Size:     audio.GetFileLength(),
+			SHA256:   hex.EncodeToString(audio.GetFileSHA256()),
+			Seconds:  audio.GetSeconds(),
+			PTT:      audio.GetPTT(),
+		}
+	case m.DocumentMessage != nil:
+		doc := m.GetDocumentMessage()
+		body.Caption = doc.GetCaption()
+		body.Media = &MessageMedia{
+			MimeType:  doc.GetMimetype(),
+			FileName:  doc.GetFileName(),
+			Title:     doc.GetTitle(),
+			Size:      doc.GetFileLength(),
+			SHA256:    hex.EncodeToString(doc.GetFileSHA256()),
+			PageCount: doc.GetPageCount(),
+		}
+	case m.StickerMessage != nil:
+		sticker := m.GetStickerMessage()
+		body.Media = &MessageMedia{
+			MimeType: sticker.GetMimetype(),
+			Size:     sticker.GetFileLength(),
+			SHA256:   hex.EncodeToString(sticker.GetFileSHA256()),
+			Width:    sticker.GetWidth(),
+			Height:   sticker.GetHeight(),
+			Animated: sticker.GetIsAnimated(),
+		}
+	case m.ContactMessage != nil:
+		contact := m.GetContactMessage()
+		body.Contacts = []Contact{{DisplayName: contact.GetDisplayName(), VCard: contact.GetVcard()}}
+	case m.ContactsArrayMessage != nil:
+		for _, contact := range m.GetContactsArrayMessage().GetContacts() {
+			body.Contacts = append(body.Contacts, Contact{DisplayName: contact.GetDisplayName(), VCard: contact.GetVcard()})
+		}
+	case m.LocationMessage != nil:
+		loc := m.GetLocationMessage()
+		body.Location = &Location{
+			Latitude:  loc.GetDegreesLatitude(),
+			Longitude: loc.GetDegreesLongitude(),
+			Name:      loc.GetName(),
+			Address:   loc.GetAddress(),
+			URL:       loc.GetURL(),
+		}
+	case m.LiveLocationMessage != nil:
+		loc := m.GetLiveLocationMessage()
+		body.Caption = loc.GetCaption()
+		body.Location = &Location{
+			Latitude:  loc.GetDegreesLatitude(),
+			Longitude: loc.GetDegreesLongitude(),
+			IsLive:    true,
+		}
+	case m.ReactionMessage != nil:
+		reaction := m.GetReactionMessage()
+		body.Reaction = &Reaction{
+			Emoji:        reaction.GetText(),
+			TargetID:     reaction.GetKey().GetID(),
+			TargetChat:   reaction.GetKey().GetRemoteJID(),
+			TargetFromMe: reaction.GetKey().GetFromMe(),
+		}
+	case GetPollCreation(m) != nil:
+		poll := GetPollCreation(m)
+		body.Poll = &Poll{
+			Question:        poll.GetName(),
+			Options:         GetPollOptions(poll),
+			SelectableCount: poll.GetSelectableOptionsCount(),
+		}
+	}
+
+	body.Context = newMessageContext(getContextInfo(m))
+	return body
+}
+
+func newMessageContext(info *waE2E.ContextInfo) *MessageContext {
+	if info == nil {
+		return nil
+	}
+
+	ctx := &MessageContext{
+		Mentions:        info.GetMentionedJID(),
+		IsForwarded:     info.GetIsForwarded(),
+		ForwardingScore: info.GetForwardingScore(),
+	}
+	if info.GetStanzaID() != "" {
+		ctx.Quoted = &QuotedMessage{
+			ID:          info.GetStanzaID(),
+			Participant: info.GetParticipant(),
+		}
+		if quoted := info.GetQuotedMessage(); quoted != nil {
+			ctx.Quoted.Type = GetMessageType(quoted)
+			ctx.Quoted.Text = GetMessageText(quoted)
+		}
+	}
+
+	if ctx.Quoted == nil && len(ctx.Mentions) == 0 && !ctx.IsForwarded {
+		return nil
+	}
+	return ctx
+}
@@ -0,0 +1,156 @@
+package wameow
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+)
+
+func GetMessageType(m *waE2E.Message) string {
+	if m == nil {
+		return "unknown"
+	}
+	switch {
+	case m.Conversation != nil || m.ExtendedTextMessage != nil:
+		return "text"
+	case m.ImageMessage != nil:
+		return "image"
+	case m.VideoMessage != nil:
+		return "video"
+	case m.AudioMessage != nil:
+		return "audio"
+	case m.DocumentMessage != nil:
+		return "document"
+	case m.StickerMessage != nil:
+		return "sticker"
+	case m.ContactMessage != nil || m.ContactsArrayMessage != nil:
+		return "contact"
+	case m.LocationMessage != nil || m.LiveLocationMessage != nil:
+		return "location"
+	case m.ReactionMessage != nil:
+		return "reaction"
+	case GetPollCreation(m) != nil:
+		return "poll"
+	default:
+		return "unknown"
+	}
+}
+
+// GetMessageText returns the user-visible text of a message: the body for
+// text messages, the caption for media and the emoji for reactions.
+func GetMessageText(m *waE2E.Message) string {
+	if m == nil {
+		return ""
+	}
+	switch {
+	case m.Conversation != nil:
+		return m.GetConversation()
+	case m.ExtendedTextMessage != nil:
+		return m.GetExtendedTextMessage().GetText()
+	case m.ImageMessage != nil:
+		return m.GetImageMessage().GetCaption()
+	case m.VideoMessage != nil:
+		return m.GetVideoMessage().GetCaption()
+	case m.DocumentMessage != nil:
+		return m.GetDocumentMessage().GetCaption()
+	case m.ReactionMessage != nil:
+		return m.GetReactionMessage().GetText()
+	case GetPollCreation(m) != nil:
+		return GetPollCreation(m).GetName()
+	default:
+		return ""
+	}
+}
+
+// GetQuotedMessageID returns the ID of the message being replied to, or of
+// the message being reacted to.
+func GetQuotedMessageID(m *waE2E.Message) string {
+	if m == nil {
+		return ""
+	}
+	if m.ReactionMessage != nil {
+		return m.GetReactionMessage().GetKey().GetID()
+	}
+	return getContextInfo(m).GetStanzaID()
+}
+
+// GetMediaURL returns the WhatsApp CDN URL of a media message.
+func GetMediaURL(m *waE2E.Message) string {
+	if m == nil {
+		return ""
+	}
+	switch {
+	case m.ImageMessage != nil:
+		return m.GetImageMessage().GetURL()
+	case m.VideoMessage != nil:
+		return m.GetVideoMessage().GetURL()
+	case m.AudioMessage != nil:
+		return m.GetAudioMessage().GetURL()
+	case m.DocumentMessage != nil:
+		return m.GetDocumentMessage().GetURL()
+	case m.StickerMessage != nil:
+		return m.GetStickerMessage().GetURL()
+	default:
+		return ""
+	}
+}
+
+func getContextInfo(m *waE2E.Message) *waE2E.ContextInfo {
+	switch {
+	case m.ExtendedTextMessage != nil:
+		return m.GetExtendedTextMessage().GetContextInfo()
+	case m.ImageMessage != nil:
+		return m.GetImageMessage().GetContextInfo()
+	case m.VideoMessage != nil:
+		return m.GetVideoMessage().GetContextInfo()
+	case m.AudioMessage != nil:
+		return m.GetAudioMessage().GetContextInfo()
+	case m.DocumentMessage != nil:
+		return m.GetDocumentMessage().GetContextInfo()
+	case m.StickerMessage != nil:
+		return m.GetStickerMessage().GetContextInfo()
+	case m.ContactMessage != nil:
+		return m.GetContactMessage().GetContextInfo()
+	case m.ContactsArrayMessage != nil:
+		return m.GetContactsArrayMessage().GetContextInfo()
+	case m.LocationMessage != nil:
+		return m.GetLocationMessage().GetContextInfo()
+	case m.LiveLocationMessage != nil:
+		return m.GetLiveLocationMessage().GetContextInfo()
+	case GetPollCreation(m) != nil:
+		return GetPollCreation(m).GetContextInfo()
+	default:
+		return nil
+	}
+}
+
+// GetPollCreation returns the poll of a message, whichever of the poll
+// message versions it was sent as.
+func GetPollCreation(m *waE2E.Message) *waE2E.PollCreationMessage {
+	switch {
+	case m.GetPollCreationMessage() != nil:
+		return m.GetPollCreationMessage()
+	case m.GetPollCreationMessageV2() != nil:
+		return m.GetPollCreationMessageV2()
+	case m.GetPollCreationMessageV3() != nil:
+		return m.GetPollCreationMessageV3()
+	default:
+		return nil
+	}
+}
+
+func GetPollOptions(poll *waE2E.PollCreationMessage) []string {
+	options := make([]string, 0, len(poll.GetOptions()))
+	for _, option := range poll.GetOptions() {
+		options = append(options, option.GetOptionName())
+	}
+	return options
+}
+
+// HashPollOption returns the hex SHA-256 hash votes use to refer to an
+// option.
+func HashPollOption(option string) string {
+	hash := sha256.Sum256([]byte(option))
+	return hex.EncodeToString(hash[:])
+}
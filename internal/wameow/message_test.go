@@ -0,0 +1,19 @@
+package wameow
+
+import "testing"
+
+func TestHashPollOption(t *testing.T) {
+	tests := []struct {
+		option string
+		want   string
+	}{
+		{"Yes", "85a39ab345d672ff8ca9b9c6876f3adcacf45ee7c1e2dbd2408fd338bd55e07e"},
+		{"", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+	}
+
+	for _, tt := range tests {
+		if got := HashPollOption(tt.option); got != tt.want {
+			t.Errorf("HashPollOption(%q) = %s, want %s", tt.option, got, tt.want)
+		}
+	}
+}
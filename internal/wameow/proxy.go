@@ -0,0 +1,32 @@
+package wameow
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// SetProxy routes the websocket and media uploads/downloads through the given
+// proxy. An empty address clears any previously configured proxy.
+func (c *Client) SetProxy(proxyURL string) error {
+	if err := c.wac.SetProxyAddress(proxyURL); err != nil {
+		return fmt.Errorf("failed to set proxy: %w", err)
+	}
+	c.proxyURL = proxyURL
+	return nil
+}
+
+// GetProxy returns the proxy in use with any password redacted.
+func (c *Client) GetProxy() string {
+	return RedactProxyURL(c.proxyURL)
+}
+
+func RedactProxyURL(proxyURL string) string {
+	if proxyURL == "" {
+		return ""
+	}
+	parsed, err := url.Parse(proxyURL)
+	if err != nil {
+		return ""
+	}
+	return parsed.Redacted()
+}
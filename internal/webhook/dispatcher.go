@@ -3,111 +3,512 @@ package webhook
 import (
 	"context"
 	"encoding/json"
+	"hash/fnv"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/lib/pq"
+
 	"fiozap/internal/database/repository"
 	"fiozap/internal/logger"
 )
 
+const (
+	claimBatchSize = 50
+	// claimPerReceiver bounds how many events of one receiver are leased at
+	// once, so a worker gets through them well within claimLease.
+	claimPerReceiver = 10
+	claimLease       = 5 * time.Minute
+
+	defaultWorkers      = 8
+	defaultMaxInFlight  = 4
+	defaultPollInterval = 30 * time.Second
+)
+
+// Dispatcher delivers queued webhook events with a pool of workers. Events
+// are sharded by receiver (session and endpoint), so each receiver's events
+// are sent one at a time and in order, while a slow receiver only holds up
+// its own worker. Requests to the same URL are further capped at maxInFlight.
+//
+// New events wake the dispatcher through Postgres notifications; polling
+// every pollInterval only recovers from missed notifications and picks up
+// retries scheduled by other instances.
 type Dispatcher struct {
-	webhookRepo *repository.WebhookRepository
-	sessionRepo *repository.SessionRepository
-	sender      *Sender
-	stopCh      chan struct{}
-	wg          sync.WaitGroup
+	webhookRepo  *repository.WebhookRepository
+	sessionRepo  *repository.SessionRepository
+	endpointRepo *repository.WebhookEndpointRepository
+	sender       *Sender
+	workers      int
+	maxInFlight  int
+	queues       []chan repository.WebhookEvent
+	inFlight     map[string]*urlSlots
+	inFlightMu   sync.Mutex
+	listener     *pq.Listener
+	pollInterval time.Duration
+	wakeCh       chan struct{}
+	// receivers counts the dispatched events per receiver; once a receiver
+	// has none left its next events can be claimed
+	receivers  map[string]int
+	nextWake   time.Time
+	scheduleMu sync.Mutex
+	stopCh     chan struct{}
+	pollerWg   sync.WaitGroup
+	workerWg   sync.WaitGroup
 }
 
-func NewDispatcher(webhookRepo *repository.WebhookRepository, sessionRepo *repository.SessionRepository) *Dispatcher {
+func NewDispatcher(webhookRepo *repository.WebhookRepository, sessionRepo *repository.SessionRepository, endpointRepo *repository.WebhookEndpointRepository) *Dispatcher {
 	return &Dispatcher{
-		webhookRepo: webhookRepo,
-		sessionRepo: sessionRepo,
-		sender:      NewSender(),
-		stopCh:      make(chan struct{}),
+		webhookRepo:  webhookRepo,
+		sessionRepo:  sessionRepo,
+		endpointRepo: endpointRepo,
+		sender:       NewSender(),
+		workers:      defaultWorkers,
+		maxInFlight:  defaultMaxInFlight,
+		inFlight:     make(map[string]*urlSlots),
+		pollInterval: defaultPollInterval,
+		wakeCh:       make(chan struct{}, 1),
+		receivers:    make(map[string]int),
+		stopCh:       make(chan struct{}),
+	}
+}
+
+// SetListener makes the dispatcher wake up on notifications of new events.
+// The listener is closed on Stop. It must be called before Start.
+func (d *Dispatcher) SetListener(listener *pq.Listener, pollInterval time.Duration) {
+	d.listener = listener
+	if pollInterval > 0 {
+		d.pollInterval = pollInterval
+	}
+}
+
+// SetConcurrency sets the number of workers and the limit of concurrent
+// requests per webhook URL. It must be called before Start.
+func (d *Dispatcher) SetConcurrency(workers, maxInFlight int) {
+	if workers > 0 {
+		d.workers = workers
+	}
+	if maxInFlight > 0 {
+		d.maxInFlight = maxInFlight
 	}
 }
 
 func (d *Dispatcher) Start() {
-	d.wg.Add(1)
+	d.queues = make([]chan repository.WebhookEvent, d.workers)
+	for i := range d.queues {
+		d.queues[i] = make(chan repository.WebhookEvent, claimBatchSize)
+		d.workerWg.Add(1)
+		go d.worker(d.queues[i])
+	}
+
+	d.pollerWg.Add(1)
 	go d.processLoop()
-	logger.Info("Webhook dispatcher started")
+	logger.Infof("Webhook dispatcher started with %d workers", d.workers)
 }
 
+// Stop waits for the requests in progress. Events still queued are released
+// so they can be picked up right away by another instance or after restart.
 func (d *Dispatcher) Stop() {
 	close(d.stopCh)
-	d.wg.Wait()
+	d.pollerWg.Wait()
+	if d.listener != nil {
+		d.listener.Close()
+	}
+	for _, queue := range d.queues {
+		close(queue)
+	}
+	d.workerWg.Wait()
 	logger.Info("Webhook dispatcher stopped")
 }
 
 func (d *Dispatcher) processLoop() {
-	defer d.wg.Done()
+	defer d.pollerWg.Done()
+
+	var notifications <-chan *pq.Notification
+	if d.listener != nil {
+		notifications = d.listener.Notify
+	}
 
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
 
 	for {
 		select {
 		case <-d.stopCh:
 			return
-		case <-ticker.C:
-			d.processPending()
+		case <-notifications:
+			// a nil notification means the connection was re-established and
+			// notifications may have been missed, which claiming covers too
+		case <-d.wakeCh:
+		case <-timer.C:
+			if d.listener != nil {
+				go d.listener.Ping()
+			}
 		}
+
+		next := d.pollInterval
+		if d.claimPending() {
+			// there may be more due events than fit in a batch
+			next = 0
+		}
+		if wake := d.untilNextWake(time.Now()); wake < next {
+			next = wake
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(next)
 	}
 }
 
-func (d *Dispatcher) processPending() {
-	events, err := d.webhookRepo.GetPending(50)
+// claimPending leases due events and hands them to the worker of their
+// receiver. It reports whether a full batch was claimed.
+func (d *Dispatcher) claimPending() bool {
+	events, err := d.webhookRepo.Claim(claimBatchSize, claimPerReceiver, claimLease)
 	if err != nil {
-		logger.Errorf("Failed to get pending webhooks: %v", err)
-		return
+		logger.Errorf("Failed to claim pending webhooks: %v", err)
+		return false
 	}
 
-	for _, event := range events {
-		if event.SessionID == "" {
-			logger.Warnf("Webhook %d has no session ID, skipping", event.ID)
-			d.webhookRepo.MarkFailed(event.ID)
-			continue
+	for i, event := range events {
+		d.scheduleMu.Lock()
+		d.receivers[receiverKey(event)]++
+		d.scheduleMu.Unlock()
+
+		select {
+		case d.queues[d.shard(event)] <- event:
+		case <-d.stopCh:
+			d.release(events[i:])
+			return false
 		}
+	}
+	return len(events) == claimBatchSize
+}
 
-		session, err := d.sessionRepo.GetByID(event.SessionID)
-		if err != nil {
-			logger.Warnf("Session not found for webhook %d: %v", event.ID, err)
-			d.webhookRepo.MarkFailed(event.ID)
+// wake makes the dispatcher claim events now. Wake-ups coalesce.
+func (d *Dispatcher) wake() {
+	select {
+	case d.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// wakeAfter makes the dispatcher claim events once delay has passed, e.g.
+// when a retry becomes due.
+func (d *Dispatcher) wakeAfter(delay time.Duration) {
+	at := time.Now().Add(delay)
+
+	d.scheduleMu.Lock()
+	earlier := d.nextWake.IsZero() || at.Before(d.nextWake)
+	if earlier {
+		d.nextWake = at
+	}
+	d.scheduleMu.Unlock()
+
+	if earlier {
+		d.wake()
+	}
+}
+
+// untilNextWake returns the time left until the earliest scheduled wake-up,
+// forgetting it once it is due since events due by now were just claimed.
+func (d *Dispatcher) untilNextWake(now time.Time) time.Duration {
+	d.scheduleMu.Lock()
+	defer d.scheduleMu.Unlock()
+
+	if d.nextWake.IsZero() {
+		return d.pollInterval
+	}
+	if !now.Before(d.nextWake) {
+		d.nextWake = time.Time{}
+		return d.pollInterval
+	}
+	return d.nextWake.Sub(now)
+}
+
+// done is called once a dispatched event is handled. It reports whether the
+// receiver has no dispatched events left.
+func (d *Dispatcher) done(event repository.WebhookEvent) bool {
+	key := receiverKey(event)
+
+	d.scheduleMu.Lock()
+	d.receivers[key]--
+	idle := d.receivers[key] <= 0
+	if idle {
+		delete(d.receivers, key)
+	}
+	d.scheduleMu.Unlock()
+
+	// events that arrived for the receiver meanwhile were not claimable
+	if idle {
+		d.wake()
+	}
+	return idle
+}
+
+func receiverKey(event repository.WebhookEvent) string {
+	return event.SessionID + "\x00" + event.EndpointID
+}
+
+func (d *Dispatcher) shard(event repository.WebhookEvent) int {
+	h := fnv.New32a()
+	h.Write([]byte(receiverKey(event)))
+	return int(h.Sum32() % uint32(len(d.queues)))
+}
+
+func (d *Dispatcher) worker(queue chan repository.WebhookEvent) {
+	defer d.workerWg.Done()
+
+	// receivers with an event waiting for a retry; the rest of their batch
+	// is released so it is not sent ahead of that event
+	held := make(map[string]bool)
+	var unsent []repository.WebhookEvent
+	for event := range queue {
+		if d.stopping() {
+			unsent = append(unsent, event)
 			continue
 		}
 
-		if session.Webhook == "" {
-			d.webhookRepo.MarkFailed(event.ID)
-			continue
+		key := receiverKey(event)
+		if held[key] {
+			d.release([]repository.WebhookEvent{event})
+		} else if d.process(event) {
+			held[key] = true
 		}
 
-		if !d.shouldSendEvent(session.Events, event.EventType) {
-			d.webhookRepo.MarkSent(event.ID)
-			continue
+		if d.done(event) {
+			delete(held, key)
 		}
+	}
+	d.release(unsent)
+}
 
-		var data interface{}
-		json.Unmarshal(event.Payload, &data)
+func (d *Dispatcher) stopping() bool {
+	select {
+	case <-d.stopCh:
+		return true
+	default:
+		return false
+	}
+}
 
-		payload := &WebhookPayload{
-			Event:     event.EventType,
-			Timestamp: event.CreatedAt.Unix(),
-			Data:      data,
-		}
+func (d *Dispatcher) release(events []repository.WebhookEvent) {
+	if len(events) == 0 {
+		return
+	}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		err = d.sender.Send(ctx, session.Webhook, payload)
-		cancel()
+	ids := make([]int64, len(events))
+	for i, event := range events {
+		ids[i] = event.ID
+	}
+	if err := d.webhookRepo.Release(ids); err != nil {
+		logger.Errorf("Failed to release %d webhooks: %v", len(ids), err)
+	}
+}
 
+// urlSlots caps the requests in flight to a URL. users counts the workers
+// holding or waiting for a slot, so the URL is forgotten once it is idle.
+type urlSlots struct {
+	slots chan struct{}
+	users int
+}
+
+// acquire waits for a request slot for the URL. It returns false when the
+// dispatcher is stopping.
+func (d *Dispatcher) acquire(url string) bool {
+	d.inFlightMu.Lock()
+	s, ok := d.inFlight[url]
+	if !ok {
+		s = &urlSlots{slots: make(chan struct{}, d.maxInFlight)}
+		d.inFlight[url] = s
+	}
+	s.users++
+	d.inFlightMu.Unlock()
+
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	case <-d.stopCh:
+		d.inFlightMu.Lock()
+		d.leave(url, s)
+		d.inFlightMu.Unlock()
+		return false
+	}
+}
+
+func (d *Dispatcher) releaseSlot(url string) {
+	d.inFlightMu.Lock()
+	defer d.inFlightMu.Unlock()
+
+	s := d.inFlight[url]
+	<-s.slots
+	d.leave(url, s)
+}
+
+// leave drops a user of the URL's slots. inFlightMu must be held.
+func (d *Dispatcher) leave(url string, s *urlSlots) {
+	s.users--
+	if s.users == 0 {
+		delete(d.inFlight, url)
+	}
+}
+
+// target is where and how an event is delivered: the session's own webhook
+// or one of its endpoints.
+type target struct {
+	url         string
+	events      string
+	headers     map[string]string
+	secrets     []string
+	maxAttempts int
+	maxAge      int
+}
+
+// process delivers an event. It reports whether a retry was scheduled, in
+// which case the receiver's later events must wait for it.
+func (d *Dispatcher) process(event repository.WebhookEvent) bool {
+	if event.SessionID == "" {
+		logger.Warnf("Webhook %d has no session ID, skipping", event.ID)
+		d.webhookRepo.MarkFailed(event.ID, repository.WebhookAttempt{Error: "no session"})
+		return false
+	}
+
+	t, reason := d.resolveTarget(event)
+	if t == nil {
+		logger.Warnf("Webhook %d not delivered: %s", event.ID, reason)
+		d.webhookRepo.MarkFailed(event.ID, repository.WebhookAttempt{Error: reason})
+		return false
+	}
+
+	if !d.shouldSendEvent(t.events, event.EventType) {
+		d.webhookRepo.MarkSent(event.ID)
+		return false
+	}
+
+	var data interface{}
+	json.Unmarshal(event.Payload, &data)
+
+	payload := &WebhookPayload{
+		Event:     event.EventType,
+		Timestamp: event.CreatedAt.Unix(),
+		Data:      data,
+	}
+
+	delivery := Delivery{
+		ID:      strconv.FormatInt(event.ID, 10),
+		Secrets: t.secrets,
+		Headers: t.headers,
+	}
+
+	if !d.acquire(t.url) {
+		d.release([]repository.WebhookEvent{event})
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	resp, err := d.sender.Send(ctx, t.url, delivery, payload)
+	cancel()
+	d.releaseSlot(t.url)
+
+	var attempt repository.WebhookAttempt
+	if resp != nil {
+		attempt.StatusCode = resp.StatusCode
+		attempt.Response = resp.Body
+	}
+
+	if err != nil {
+		attempt.Error = err.Error()
+		return d.handleFailure(event, attempt, t.maxAttempts, t.maxAge, err)
+	}
+
+	logger.Debugf("Webhook %d sent successfully", event.ID)
+	d.webhookRepo.MarkDelivered(event.ID, attempt)
+	return false
+}
+
+// resolveTarget loads the current configuration for the event's receiver. It
+// returns the reason when the event can no longer be delivered.
+func (d *Dispatcher) resolveTarget(event repository.WebhookEvent) (*target, string) {
+	now := time.Now()
+
+	if event.EndpointID != "" {
+		endpoint, err := d.endpointRepo.GetByID(event.SessionID, event.EndpointID)
 		if err != nil {
-			logger.Warnf("Failed to send webhook %d: %v", event.ID, err)
-			d.webhookRepo.MarkFailed(event.ID)
-		} else {
-			logger.Debugf("Webhook %d sent successfully", event.ID)
-			d.webhookRepo.MarkSent(event.ID)
+			return nil, "endpoint not found"
 		}
+		if !endpoint.Enabled {
+			return nil, "endpoint disabled"
+		}
+		return &target{
+			url:         endpoint.URL,
+			events:      endpoint.Events,
+			headers:     endpoint.Headers,
+			secrets:     endpoint.Secrets(now),
+			maxAttempts: endpoint.MaxAttempts,
+			maxAge:      endpoint.MaxAge,
+		}, ""
+	}
+
+	session, err := d.sessionRepo.GetByID(event.SessionID)
+	if err != nil {
+		return nil, "session not found"
+	}
+	if session.Webhook == "" {
+		return nil, "no webhook configured"
+	}
+	return &target{
+		url:         session.Webhook,
+		events:      session.Events,
+		secrets:     session.WebhookSecrets(now),
+		maxAttempts: session.WebhookMaxAttempts,
+		maxAge:      session.WebhookMaxAge,
+	}, ""
+}
+
+// handleFailure either gives up on the event or schedules its next attempt
+// according to the session's retry policy. It reports whether the event is
+// still pending.
+func (d *Dispatcher) handleFailure(event repository.WebhookEvent, attempt repository.WebhookAttempt, maxAttempts, maxAge int, err error) bool {
+	if isPermanent(err) {
+		logger.Warnf("Webhook %d rejected, not retrying: %v", event.ID, err)
+		d.webhookRepo.MarkFailed(event.ID, attempt)
+		return false
+	}
+
+	delay := retryDelay(event.Attempts+1, err)
+	retrying, dbErr := d.webhookRepo.ScheduleRetry(event.ID, attempt, delay, maxAttempts, time.Duration(maxAge)*time.Second)
+	if dbErr != nil {
+		// the lease runs out and the event is tried again
+		logger.Errorf("Failed to schedule retry for webhook %d: %v", event.ID, dbErr)
+		return true
+	}
+
+	if retrying {
+		d.wakeAfter(delay)
+		logger.Warnf("Failed to send webhook %d (attempt %d), retrying in %s: %v", event.ID, event.Attempts+1, delay.Round(time.Second), err)
+	} else {
+		logger.Warnf("Failed to send webhook %d, giving up after %d attempts: %v", event.ID, event.Attempts+1, err)
+	}
+	return retrying
+}
+
+// renamedEvents maps the old names of renamed event types to their current
+// ones, so subscriptions stored with an old name keep matching.
+var renamedEvents = map[string]string{
+	"HistorySync": "HistorySyncProgress",
+}
+
+// CanonicalEvent returns the current name of an event type.
+func CanonicalEvent(eventType string) string {
+	if renamed, ok := renamedEvents[eventType]; ok {
+		return renamed
 	}
+	return eventType
 }
 
 func (d *Dispatcher) shouldSendEvent(subscribedEvents, eventType string) bool {
@@ -117,7 +518,7 @@ func (d *Dispatcher) shouldSendEvent(subscribedEvents, eventType string) bool {
 
 	events := strings.Split(subscribedEvents, ",")
 	for _, e := range events {
-		if e == "All" || e == eventType {
+		if e == "All" || CanonicalEvent(e) == eventType {
 			return true
 		}
 	}
@@ -0,0 +1,139 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"fiozap/internal/database/dbtest"
+	"fiozap/internal/database/repository"
+	"fiozap/internal/model"
+)
+
+func TestURLSlots(t *testing.T) {
+	d := NewDispatcher(nil, nil, nil)
+	d.SetConcurrency(1, 1)
+
+	if !d.acquire("https://a.example") {
+		t.Fatal("acquire() = false")
+	}
+
+	acquired := make(chan bool)
+	go func() {
+		acquired <- d.acquire("https://a.example")
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("acquire() did not wait for the URL's only slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// other URLs have their own slots
+	if !d.acquire("https://b.example") {
+		t.Fatal("acquire() = false for another URL")
+	}
+	d.releaseSlot("https://b.example")
+
+	d.releaseSlot("https://a.example")
+	if !<-acquired {
+		t.Fatal("acquire() = false after the slot was released")
+	}
+	d.releaseSlot("https://a.example")
+
+	if n := len(d.inFlight); n != 0 {
+		t.Errorf("%d idle URLs still tracked", n)
+	}
+}
+
+func TestURLSlotsStopping(t *testing.T) {
+	d := NewDispatcher(nil, nil, nil)
+	d.SetConcurrency(1, 1)
+
+	d.acquire("https://a.example")
+	acquired := make(chan bool)
+	go func() {
+		acquired <- d.acquire("https://a.example")
+	}()
+
+	close(d.stopCh)
+	if <-acquired {
+		t.Error("acquire() = true while stopping")
+	}
+	d.releaseSlot("https://a.example")
+
+	if n := len(d.inFlight); n != 0 {
+		t.Errorf("%d idle URLs still tracked", n)
+	}
+}
+
+func TestDispatcherHoldsReceiverForRetry(t *testing.T) {
+	db := dbtest.Open(t)
+	webhookRepo := repository.NewWebhookRepository(db)
+	sessionRepo := repository.NewSessionRepository(db)
+
+	var (
+		mu        sync.Mutex
+		delivered []string
+	)
+	received := make(chan struct{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		id := r.Header.Get(HeaderDeliveryID)
+		first := len(delivered) == 0
+		delivered = append(delivered, id)
+		mu.Unlock()
+
+		// the first event fails once, the receiver asks to retry in a second
+		if first {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		received <- struct{}{}
+	}))
+	defer srv.Close()
+
+	user, err := repository.NewUserRepository(db).Create(&model.UserCreateRequest{Name: "a", Token: "token-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := sessionRepo.Create(user.ID, &model.SessionCreateRequest{Name: "a", Webhook: srv.URL, Events: "All"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if err := webhookRepo.Create(user.ID, session.ID, "Message", map[string]string{"text": "hi"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var ids []string
+	if err := db.Select(&ids, `SELECT "id"::text FROM "fzWebhook" WHERE "sessionId" = $1 ORDER BY "id"`, session.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDispatcher(webhookRepo, sessionRepo, repository.NewWebhookEndpointRepository(db))
+	d.SetConcurrency(1, 1)
+	d.SetListener(nil, 100*time.Millisecond)
+	d.Start()
+	defer d.Stop()
+
+	for range 4 {
+		select {
+		case <-received:
+		case <-time.After(10 * time.Second):
+			mu.Lock()
+			defer mu.Unlock()
+			t.Fatalf("delivered %v, want 4 requests", delivered)
+		}
+	}
+
+	// the later events wait for the retry instead of overtaking it
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{ids[0], ids[0], ids[1], ids[2]}; !slices.Equal(delivered, want) {
+		t.Errorf("delivered %v, want %v", delivered, want)
+	}
+}
@@ -0,0 +1,96 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	retryBaseDelay = 5 * time.Second
+	retryMaxDelay  = time.Hour
+)
+
+// DeliveryError describes a failed webhook request. Permanent errors are not
+// retried; RetryAfter is set when the receiver sent a Retry-After header.
+type DeliveryError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Permanent  bool
+	Err        error
+}
+
+func (e *DeliveryError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("webhook returned status %d", e.StatusCode)
+	}
+	return e.Err.Error()
+}
+
+func (e *DeliveryError) Unwrap() error {
+	return e.Err
+}
+
+// newStatusError classifies an HTTP error response. Client errors mean the
+// receiver rejected the payload, so only the ones that ask to try again later
+// are retried.
+func newStatusError(resp *http.Response) *DeliveryError {
+	permanent := resp.StatusCode < 500
+	switch resp.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		permanent = false
+	}
+
+	return &DeliveryError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		Permanent:  permanent,
+	}
+}
+
+// parseRetryAfter accepts both delay-seconds and HTTP-date values.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// retryDelay returns how long to wait before the next attempt, given the
+// number of attempts made so far. The delay doubles on every attempt up to
+// an hour, with half of it randomized so receivers recovering from an outage
+// are not hit by every queued event at once. A Retry-After from the receiver
+// takes precedence, still capped at an hour.
+func retryDelay(attempts int, err error) time.Duration {
+	var deliveryErr *DeliveryError
+	if errors.As(err, &deliveryErr) && deliveryErr.RetryAfter > 0 {
+		return min(deliveryErr.RetryAfter, retryMaxDelay)
+	}
+
+	delay := retryMaxDelay
+	if attempts < 10 {
+		delay = min(retryBaseDelay<<max(attempts-1, 0), retryMaxDelay)
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func isPermanent(err error) bool {
+	var deliveryErr *DeliveryError
+	return errors.As(err, &deliveryErr) && deliveryErr.Permanent
+}
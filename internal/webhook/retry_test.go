@@ -0,0 +1,112 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{"empty", "", 0},
+		{"seconds", "120", 2 * time.Minute},
+		{"padded seconds", " 30 ", 30 * time.Second},
+		{"zero", "0", 0},
+		{"negative", "-5", 0},
+		{"http date", now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{"past http date", now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"garbage", "soon", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.value, now); got != tt.want {
+				t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		err      error
+		min, max time.Duration
+	}{
+		{"first attempt", 1, errors.New("timeout"), 2500 * time.Millisecond, 5 * time.Second},
+		{"no attempts", 0, errors.New("timeout"), 2500 * time.Millisecond, 5 * time.Second},
+		{"doubles", 3, errors.New("timeout"), 10 * time.Second, 20 * time.Second},
+		{"capped", 12, errors.New("timeout"), 30 * time.Minute, time.Hour},
+		{"overflow", 100, errors.New("timeout"), 30 * time.Minute, time.Hour},
+		{
+			name:     "retry after",
+			attempts: 1,
+			err:      &DeliveryError{StatusCode: 429, RetryAfter: 2 * time.Minute},
+			min:      2 * time.Minute,
+			max:      2 * time.Minute,
+		},
+		{
+			name:     "wrapped retry after",
+			attempts: 1,
+			err:      fmt.Errorf("send: %w", &DeliveryError{StatusCode: 503, RetryAfter: 10 * time.Second}),
+			min:      10 * time.Second,
+			max:      10 * time.Second,
+		},
+		{
+			name:     "retry after capped",
+			attempts: 1,
+			err:      &DeliveryError{StatusCode: 429, RetryAfter: 3 * time.Hour},
+			min:      time.Hour,
+			max:      time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the delay is randomized, so check its bounds a few times
+			for range 20 {
+				got := retryDelay(tt.attempts, tt.err)
+				if got < tt.min || got > tt.max {
+					t.Fatalf("retryDelay(%d) = %s, want between %s and %s", tt.attempts, got, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestNewStatusError(t *testing.T) {
+	tests := []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusBadRequest, true},
+		{http.StatusNotFound, true},
+		{http.StatusRequestTimeout, false},
+		{http.StatusTooEarly, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusInternalServerError, false},
+		{http.StatusServiceUnavailable, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{"Retry-After": {"7"}}}
+			err := newStatusError(resp)
+			if err.Permanent != tt.permanent {
+				t.Errorf("Permanent = %v, want %v", err.Permanent, tt.permanent)
+			}
+			if err.RetryAfter != 7*time.Second {
+				t.Errorf("RetryAfter = %s, want 7s", err.RetryAfter)
+			}
+		})
+	}
+}
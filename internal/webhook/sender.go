@@ -3,12 +3,28 @@ package webhook
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	HeaderSignature  = "X-FioZap-Signature"
+	HeaderTimestamp  = "X-FioZap-Timestamp"
+	HeaderDeliveryID = "X-FioZap-Delivery-Id"
+)
+
+// maxResponseSnippet caps how much of the receiver's response is kept for
+// inspection.
+const maxResponseSnippet = 1024
+
 type Sender struct {
 	client *http.Client
 }
@@ -27,29 +43,85 @@ type WebhookPayload struct {
 	Data      interface{} `json:"data"`
 }
 
-func (s *Sender) Send(ctx context.Context, url string, payload *WebhookPayload) error {
+// Delivery identifies a webhook request, the secrets it is signed with and
+// any extra headers of the receiver. The ID stays the same across retries so
+// receivers can deduplicate.
+type Delivery struct {
+	ID      string
+	Secrets []string
+	Headers map[string]string
+}
+
+// Sign computes the hex HMAC-SHA256 of "<timestamp>.<body>". Receivers
+// recompute it with their secret and compare it to each "sha256=" entry of
+// the X-FioZap-Signature header, rejecting stale timestamps.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Response is what the receiver answered, with the body truncated.
+type Response struct {
+	StatusCode int
+	Body       string
+}
+
+// Send posts the payload. The response is returned whenever one was
+// received, including for error statuses.
+func (s *Sender) Send(ctx context.Context, url string, delivery Delivery, payload *WebhookPayload) (*Response, error) {
 	body, err := json.Marshal(payload)
 	if err != nil {
-		return fmt.Errorf("failed to marshal payload: %w", err)
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// custom headers go first so they cannot replace the signature
+	for name, value := range delivery.Headers {
+		req.Header.Set(name, value)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", "FioZap-Webhook/1.0")
 
+	timestamp := time.Now().Unix()
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderDeliveryID, delivery.ID)
+
+	// during a secret rotation both the new and the old signature are sent
+	var signatures []string
+	for _, secret := range delivery.Secrets {
+		if secret != "" {
+			signatures = append(signatures, "sha256="+Sign(secret, timestamp, body))
+		}
+	}
+	if len(signatures) > 0 {
+		req.Header.Set(HeaderSignature, strings.Join(signatures, ","))
+	}
+
 	resp, err := s.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send webhook: %w", err)
+		return nil, &DeliveryError{Err: fmt.Errorf("failed to send webhook: %w", err)}
 	}
 	defer resp.Body.Close()
 
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseSnippet))
+	response := &Response{StatusCode: resp.StatusCode, Body: sanitizeSnippet(snippet)}
+
 	if resp.StatusCode >= 400 {
-		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+		return response, newStatusError(resp)
 	}
 
-	return nil
+	return response, nil
+}
+
+// sanitizeSnippet makes the response storable in a TEXT column.
+func sanitizeSnippet(b []byte) string {
+	return strings.ReplaceAll(strings.ToValidUTF8(string(b), ""), "\x00", "")
 }
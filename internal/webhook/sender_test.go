@@ -0,0 +1,50 @@
+package webhook
+
+import "testing"
+
+func TestSign(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp int64
+		body      string
+		want      string
+	}{
+		{
+			name:      "payload",
+			secret:    "secret",
+			timestamp: 1700000000,
+			body:      `{"event":"Message"}`,
+			want:      "fc39f9db6e1f05dfa22d429bc4e6dbac24745a059222941b19fbfe6a320c317d",
+		},
+		{
+			name:      "timestamp is signed",
+			secret:    "secret",
+			timestamp: 1700000001,
+			body:      `{"event":"Message"}`,
+			want:      "3e33c6024d9b8a1eb52b611b9808e0754dcb6686d7242e65f275bcb5894f627c",
+		},
+		{
+			name:      "empty body",
+			secret:    "secret",
+			timestamp: 1700000000,
+			body:      "",
+			want:      "4bc5f74d868b97888288889c5d9d65df02526f94c1592a79fdf4fe8b26e311e5",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sign(tt.secret, tt.timestamp, []byte(tt.body)); got != tt.want {
+				t.Errorf("Sign() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignDependsOnSecret(t *testing.T) {
+	body := []byte(`{"event":"Message"}`)
+	if Sign("secret", 1700000000, body) == Sign("other", 1700000000, body) {
+		t.Error("Sign() returned the same signature for different secrets")
+	}
+}